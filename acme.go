@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// acmeChallenge is a DNS-01 challenge handed to ripple by an ACME client.
+type acmeChallenge struct {
+	name    string // the _acme-challenge name, before following aliases
+	value   string // the TXT value the client expects
+	cleanup bool   // the record is being removed; nothing to wait for
+	args    []string
+}
+
+// challengeName returns the DNS-01 name for an identifier: wildcards are
+// validated at the base domain.
+func challengeName(domain string) string {
+	domain = strings.TrimSuffix(strings.TrimPrefix(domain, "*."), ".")
+	if strings.HasPrefix(strings.ToLower(domain), "_acme-challenge.") {
+		return domain
+	}
+	return "_acme-challenge." + domain
+}
+
+// certbotChallenge reads the challenge from the environment certbot gives
+// --manual-auth-hook and --manual-cleanup-hook scripts. Only cleanup hooks
+// get CERTBOT_AUTH_OUTPUT.
+func certbotChallenge(lookupEnv func(string) (string, bool)) (*acmeChallenge, error) {
+	domain, _ := lookupEnv("CERTBOT_DOMAIN")
+	value, _ := lookupEnv("CERTBOT_VALIDATION")
+	if domain == "" || value == "" {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "CERTBOT_DOMAIN and CERTBOT_VALIDATION must be set; run as a certbot manual hook")
+	}
+	_, cleanup := lookupEnv("CERTBOT_AUTH_OUTPUT")
+	return &acmeChallenge{name: challengeName(domain), value: value, cleanup: cleanup}, nil
+}
+
+// legoChallenge reads the arguments of lego's exec provider: "present" or
+// "cleanup", then the FQDN and TXT value, or with EXEC_MODE=RAW "--" and the
+// domain, token and key authorization the value is derived from.
+func legoChallenge(args []string, getenv func(string) string) (*acmeChallenge, error) {
+	if len(args) == 0 || (args[0] != "present" && args[0] != "cleanup") {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "expected present or cleanup, as lego's exec provider runs it")
+	}
+	ch := &acmeChallenge{cleanup: args[0] == "cleanup", args: args}
+	raw := getenv("EXEC_MODE") == "RAW"
+	switch {
+	case raw && len(args) == 5 && args[1] == "--":
+		sum := sha256.Sum256([]byte(args[4]))
+		ch.name, ch.value = challengeName(args[2]), base64.RawURLEncoding.EncodeToString(sum[:])
+	case !raw && len(args) == 3:
+		ch.name, ch.value = strings.TrimSuffix(args[1], "."), args[2]
+	default:
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "unexpected lego arguments %q", args)
+	}
+	return ch, nil
+}
+
+// acmeWaitChallenge reads "<domain> <validation>" arguments, for clients
+// such as acme.sh that call a script of their own.
+func acmeWaitChallenge(args []string) (*acmeChallenge, error) {
+	if len(args) != 2 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "expected a domain and a validation value")
+	}
+	return &acmeChallenge{name: challengeName(args[0]), value: args[1]}, nil
+}
+
+func setupACME(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	g.register(fs)
+	fs.StringVar(&c.retry, "r", "", "retry interval (default from config, lego's EXEC_POLLING_INTERVAL or 5s)")
+	fs.StringVar(&c.duration, "w", "", "how long to wait (default from config, lego's EXEC_PROPAGATION_TIMEOUT or 1m)")
+	fs.StringVar(&c.output, "o", "text", "output format, written to stderr: "+strings.Join(outputFormats, ", "))
+	fs.BoolVar(&c.quiet, "q", false, "print only the final verdict (same as -o quiet)")
+	hook := fs.String("exec", "", "program that creates and removes the record, run first with the same arguments and environment")
+	return func(args []string) int {
+		if len(args) == 0 {
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "expected certbot, lego or wait"))
+		}
+		var ch *acmeChallenge
+		var err error
+		switch args[0] {
+		case "certbot":
+			ch, err = certbotChallenge(os.LookupEnv)
+		case "lego":
+			ch, err = legoChallenge(args[1:], os.Getenv)
+			// lego passes its timing in seconds
+			if c.duration == "" && os.Getenv("EXEC_PROPAGATION_TIMEOUT") != "" {
+				c.duration = os.Getenv("EXEC_PROPAGATION_TIMEOUT") + "s"
+			}
+			if c.retry == "" && os.Getenv("EXEC_POLLING_INTERVAL") != "" {
+				c.retry = os.Getenv("EXEC_POLLING_INTERVAL") + "s"
+			}
+		case "wait":
+			ch, err = acmeWaitChallenge(args[1:])
+		default:
+			err = dnspkg.Errorf(dnspkg.ErrInvalidInput, "unknown ACME client %q: expected certbot, lego or wait", args[0])
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		if *hook != "" {
+			err := runCommand(exec.Command(*hook, ch.args...), os.Environ())
+			if code := commandExitCode(err); code != exitPropagated {
+				return code
+			}
+		}
+		if ch.cleanup {
+			return exitPropagated
+		}
+
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+		// ACME clients keep stdout for themselves
+		out, err := c.newOutput(os.Stderr)
+		if err != nil {
+			return fail(err)
+		}
+		c.recordType = "txt"
+		c.match = ch.value
+		opts, err := c.options(ch.name, dnspkg.CaptureHeader{})
+		if err != nil {
+			return fail(err)
+		}
+		s.primeRoots()
+		return runACME(out, opts)
+	}
+}
+
+// runACME waits until every authoritative server of the challenge record has
+// the TXT value. An _acme-challenge name delegated to another zone with a
+// CNAME is checked at the servers of its target.
+func runACME(out checkOutput, opts *checkOptions) int {
+	name, err := dnspkg.PrepareName(opts.domain, dnspkg.ParseRecordType("txt"))
+	if err != nil {
+		out.fail(err)
+		return exitCodeFor(err)
+	}
+	ctx, cancel := context.WithTimeout(opts.context(), min(opts.duration, 30*time.Second))
+	target, chain, err := dnspkg.ResolveAlias(ctx, name, dnspkg.ParseRecordType("txt"), config.Roots())
+	cancel()
+	if err != nil {
+		out.fail(fmt.Errorf("following %s: %w", strings.TrimSuffix(name, "."), err))
+		return exitCodeFor(err)
+	}
+	switch out.(type) {
+	case *textOutput, *tableOutput:
+		if len(chain) == 0 {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "%s is delegated: %s\n", strings.TrimSuffix(name, "."), dnspkg.FormatChain(chain))
+	}
+
+	opts.domain = target
+	opts.authOnly = true
+	return runCLI(out, opts)
+}
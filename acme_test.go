@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChallengeName(t *testing.T) {
+	for domain, want := range map[string]string{
+		"example.com":                  "_acme-challenge.example.com",
+		"*.example.com":                "_acme-challenge.example.com",
+		"_acme-challenge.example.com.": "_acme-challenge.example.com",
+	} {
+		if got := challengeName(domain); got != want {
+			t.Errorf("challengeName(%q) = %q, want %q", domain, got, want)
+		}
+	}
+}
+
+func TestCertbotChallenge(t *testing.T) {
+	env := map[string]string{"CERTBOT_DOMAIN": "*.example.com", "CERTBOT_VALIDATION": "token"}
+	lookup := func(k string) (string, bool) { v, ok := env[k]; return v, ok }
+
+	ch, err := certbotChallenge(lookup)
+	if err != nil || ch.name != "_acme-challenge.example.com" || ch.value != "token" || ch.cleanup {
+		t.Errorf("auth hook: %+v, %v", ch, err)
+	}
+	env["CERTBOT_AUTH_OUTPUT"] = ""
+	if ch, _ := certbotChallenge(lookup); !ch.cleanup {
+		t.Error("cleanup hook not recognized")
+	}
+	delete(env, "CERTBOT_VALIDATION")
+	if _, err := certbotChallenge(lookup); err == nil {
+		t.Error("missing CERTBOT_VALIDATION accepted")
+	}
+}
+
+func TestLegoChallenge(t *testing.T) {
+	tests := []struct {
+		args        []string
+		mode        string
+		name, value string
+		cleanup     bool
+	}{
+		{[]string{"present", "_acme-challenge.example.com.", "token"}, "", "_acme-challenge.example.com", "token", false},
+		{[]string{"cleanup", "_acme-challenge.example.com.", "token"}, "", "_acme-challenge.example.com", "token", true},
+		// base64url(sha256("keyauth")), as lego computes it
+		{[]string{"present", "--", "example.com", "tok", "keyauth"}, "RAW", "_acme-challenge.example.com", "wbH9j6vkAXpfR6sTmPqJCzHZtba8qe5WvxoAP9hMTzs", false},
+	}
+	for _, tt := range tests {
+		getenv := func(string) string { return tt.mode }
+		ch, err := legoChallenge(tt.args, getenv)
+		if err != nil || ch.name != tt.name || ch.value != tt.value || ch.cleanup != tt.cleanup {
+			t.Errorf("%q: got %+v, %v", tt.args, ch, err)
+		}
+	}
+	if _, err := legoChallenge([]string{"present", "example.com"}, func(string) string { return "" }); err == nil {
+		t.Error("short arguments accepted")
+	}
+	if _, err := legoChallenge([]string{"present", "example.com", "tok", "keyauth"}, func(string) string { return "RAW" }); err == nil {
+		t.Error("RAW arguments without -- accepted")
+	}
+}
+
+func TestRunACMEFollowsCNAME(t *testing.T) {
+	h := useHierarchy(t)
+	acme := h.AddZone(t, h.TLD, "acme.test.")
+	const challenge = "_acme-challenge." + www
+	for _, ns := range h.NS {
+		ns.Add(challenge + " 300 IN CNAME abc.acme.test.")
+	}
+
+	run := func() (int, string) {
+		var buf strings.Builder
+		opts := &checkOptions{domain: challenge, recordType: "txt", match: "token", retry: 50 * time.Millisecond, duration: 300 * time.Millisecond}
+		return runACME(&quietOutput{w: &buf}, opts), buf.String()
+	}
+
+	// Until the target zone has the value, the servers of the alias do not count
+	if code, out := run(); code != exitNotAuthoritative || !strings.Contains(out, "abc.acme.test TXT token reached 0/1 authoritative servers") {
+		t.Errorf("exit code %d, output %q", code, out)
+	}
+	acme.Add(`abc.acme.test. 60 IN TXT "token"`)
+	if code, out := run(); code != exitPropagated || !strings.HasPrefix(out, "propagated: abc.acme.test TXT token reached 1/1 authoritative servers") {
+		t.Errorf("exit code %d, output %q", code, out)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	dnspkg "ripple/dns"
+)
+
+// Batch file formats for -format
+var batchFormats = []string{"yaml", "csv", "jsonl"}
+
+// batchEntry is one check of a batch file. Empty fields take the defaults of
+// the command line.
+type batchEntry struct {
+	Domain  string `yaml:"domain" json:"domain"`
+	Type    string `yaml:"type" json:"type"`
+	Match   string `yaml:"match" json:"match"`
+	Timeout string `yaml:"timeout" json:"timeout"`
+}
+
+// BatchResult is the outcome of one entry of a batch.
+type BatchResult struct {
+	Domain   string                `json:"domain"`
+	Type     string                `json:"type"`
+	Match    string                `json:"match"`
+	Status   string                `json:"status"` // propagated, partial, authoritative_timeout or an error code
+	ExitCode int                   `json:"exit_code"`
+	Reached  string                `json:"reached,omitempty"` // "found/total" servers, see checkResult.reached
+	Elapsed  string                `json:"elapsed,omitempty"`
+	Error    string                `json:"error,omitempty"`
+	Response *dnspkg.CheckResponse `json:"response,omitempty"`
+
+	elapsed time.Duration
+}
+
+// BatchReport is the consolidated outcome of a batch, with the entries in
+// file order.
+type BatchReport struct {
+	Entries  []BatchResult  `json:"entries"`
+	Statuses map[string]int `json:"statuses"` // number of entries per status
+	ExitCode int            `json:"exit_code"`
+	Elapsed  string         `json:"elapsed"`
+}
+
+// loadBatch reads the entries of a batch file. The format is taken from the
+// file extension unless given; "-" reads standard input.
+func loadBatch(path, format string) ([]batchEntry, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		case ".csv":
+			format = "csv"
+		case ".jsonl", ".ndjson":
+			format = "jsonl"
+		default:
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "cannot tell the format of %q; use -format %s", path, strings.Join(batchFormats, "|"))
+		}
+	}
+
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "reading batch file: %w", err)
+	}
+
+	var entries []batchEntry
+	switch format {
+	case "yaml":
+		err = yaml.Unmarshal(data, &entries)
+	case "csv":
+		entries, err = parseBatchCSV(data)
+	case "jsonl":
+		entries, err = parseBatchJSONL(data)
+	default:
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "unknown batch format %q", format)
+	}
+	if err != nil {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "parsing batch file: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "batch file %s has no entries", path)
+	}
+	return entries, nil
+}
+
+// parseBatchCSV reads rows of domain,type,match,timeout. A first row naming
+// the columns may reorder or omit them; lines starting with # are skipped.
+func parseBatchCSV(data []byte) ([]batchEntry, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := []string{"domain", "type", "match", "timeout"}
+	isDomain := func(v string) bool { return strings.EqualFold(strings.TrimSpace(v), "domain") }
+	if len(rows) > 0 && slices.ContainsFunc(rows[0], isDomain) {
+		columns = rows[0]
+		rows = rows[1:]
+	}
+	entries := make([]batchEntry, 0, len(rows))
+	for _, row := range rows {
+		var e batchEntry
+		for i, v := range row {
+			if i >= len(columns) {
+				break
+			}
+			v = strings.TrimSpace(v)
+			switch strings.ToLower(strings.TrimSpace(columns[i])) {
+			case "domain":
+				e.Domain = v
+			case "type":
+				e.Type = v
+			case "match":
+				e.Match = v
+			case "timeout":
+				e.Timeout = v
+			default:
+				return nil, fmt.Errorf("unknown column %q", columns[i])
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// parseBatchJSONL reads one JSON entry per line, skipping blank lines.
+func parseBatchJSONL(data []byte) ([]batchEntry, error) {
+	var entries []batchEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var e batchEntry
+		if err := json.Unmarshal(text, &e); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// sharedDiscovery discovers the authoritative servers of each name once for
+// the whole batch; entries checking other record types of the same name, or
+// the same name again, reuse the result. Zone cuts above the names are shared
+// through the delegation cache.
+type sharedDiscovery struct {
+	mu    sync.Mutex
+	names map[string]*discovery
+}
+
+type discovery struct {
+	once    sync.Once
+	servers []*dnspkg.ResolverStatus
+	err     error
+}
+
+func newSharedDiscovery() *sharedDiscovery {
+	return &sharedDiscovery{names: make(map[string]*discovery)}
+}
+
+// discover implements checkOptions.discover. Every caller gets its own copy
+// of the servers, as polling updates them.
+func (d *sharedDiscovery) discover(ctx context.Context, domain string, qtype uint16) ([]*dnspkg.ResolverStatus, error) {
+	// PTR names may be delegated classlessly; see DiscoverAuthoritative
+	key := strings.ToLower(domain)
+	if qtype == dnspkg.ParseRecordType("ptr") {
+		key += " PTR"
+	}
+	d.mu.Lock()
+	found, ok := d.names[key]
+	if !ok {
+		found = &discovery{}
+		d.names[key] = found
+	}
+	d.mu.Unlock()
+
+	found.once.Do(func() {
+		found.servers, found.err = dnspkg.DiscoverAuthoritativeContext(ctx, domain, qtype, config.Roots())
+	})
+	if found.err != nil {
+		return nil, found.err
+	}
+	servers := make([]*dnspkg.ResolverStatus, len(found.servers))
+	for i, s := range found.servers {
+		servers[i] = &dnspkg.ResolverStatus{Name: s.Name, Addr: s.Addr, QName: s.QName}
+	}
+	return servers, nil
+}
+
+// batchOutput keeps the outcome of one entry for the report.
+type batchOutput struct {
+	result *checkResult
+	err    error
+}
+
+func (o *batchOutput) begin(domain string, opts *checkOptions)                 {}
+func (o *batchOutput) discovered(servers []*dnspkg.ResolverStatus)             {}
+func (o *batchOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {}
+func (o *batchOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool)        {}
+func (o *batchOutput) finish(r *checkResult)                                   { o.result = r }
+func (o *batchOutput) fail(err error)                                          { o.err = err }
+
+// runBatch runs the entries with at most parallel checks at a time and
+// returns the report. c holds the defaults for fields an entry leaves empty;
+// done, if set, is called as each entry finishes, one at a time.
+func runBatch(entries []batchEntry, c *checkFlags, parallel int, done func(i int, r *BatchResult)) *BatchReport {
+	start := time.Now()
+	report := &BatchReport{Entries: make([]BatchResult, len(entries)), Statuses: make(map[string]int)}
+	shared := newSharedDiscovery()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, max(parallel, 1))
+	for i, e := range entries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r := runBatchEntry(e, c, shared)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Entries[i] = r
+			report.Statuses[r.Status]++
+			report.ExitCode = max(report.ExitCode, r.ExitCode)
+			if done != nil {
+				done(i, &report.Entries[i])
+			}
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = dnspkg.FormatDuration(time.Since(start))
+	return report
+}
+
+// runBatchEntry checks one entry of a batch.
+func runBatchEntry(e batchEntry, c *checkFlags, shared *sharedDiscovery) BatchResult {
+	ec := *c
+	if e.Type != "" {
+		ec.recordType = e.Type
+	}
+	ec.match = e.Match
+	if e.Timeout != "" {
+		ec.duration = e.Timeout
+	}
+	r := BatchResult{Domain: e.Domain, Type: strings.ToUpper(ec.recordType), Match: e.Match}
+
+	var opts *checkOptions
+	err := dnspkg.Errorf(dnspkg.ErrInvalidInput, "match is required")
+	if e.Match != "" {
+		opts, err = ec.options(e.Domain, dnspkg.CaptureHeader{})
+	}
+	if err != nil {
+		r.Status, r.ExitCode, r.Error = dnspkg.ErrorCode(err), exitCodeFor(err), err.Error()
+		return r
+	}
+	r.Type = strings.ToUpper(opts.recordType)
+	opts.discover = shared.discover
+
+	out := &batchOutput{}
+	r.ExitCode = runCLI(out, opts)
+	r.record(out.result, out.err)
+	return r
+}
+
+// record fills in the outcome of a check that finished with res or failed
+// with err.
+func (r *BatchResult) record(res *checkResult, err error) {
+	switch {
+	case err != nil:
+		r.Status, r.Error = dnspkg.ErrorCode(err), err.Error()
+	case res != nil:
+		r.Status = res.code()
+		if r.Status == "" {
+			r.Status = "propagated"
+		}
+		found, total, _ := res.reached()
+		r.Reached = fmt.Sprintf("%d/%d", found, total)
+		r.Elapsed = dnspkg.FormatDuration(res.elapsed)
+		r.elapsed = res.elapsed
+		r.Response = res.response
+		r.Domain = res.response.Domain
+	}
+}
+
+// printBatchReport writes the report as a table with the errors below it.
+func printBatchReport(w io.Writer, report *BatchReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tDOMAIN\tTYPE\tMATCH\tSERVERS\tTIME")
+	for _, r := range report.Entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Status, r.Domain, r.Type, r.Match, orDash(r.Reached), orDash(r.Elapsed))
+	}
+	tw.Flush()
+
+	var failed []string
+	for _, r := range report.Entries {
+		if r.Error != "" {
+			failed = append(failed, fmt.Sprintf(" - %s: %s", r.Domain, r.Error))
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Fprintf(w, "\nErrors:\n%s\n", strings.Join(failed, "\n"))
+	}
+
+	var counts []string
+	for _, status := range statusOrder(report.Statuses) {
+		counts = append(counts, fmt.Sprintf("%d %s", report.Statuses[status], status))
+	}
+	fmt.Fprintf(w, "\n%d checks in %s: %s\n", len(report.Entries), report.Elapsed, strings.Join(counts, ", "))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// statusOrder returns the statuses with propagated first, then by name.
+func statusOrder(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		switch {
+		case a == b:
+			return 0
+		case a == "propagated":
+			return -1
+		case b == "propagated":
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return keys
+}
+
+func setupBatch(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	g.register(fs)
+	fs.StringVar(&c.recordType, "t", "", "record type for entries without one (default from config or a)")
+	fs.StringVar(&c.retry, "r", "", "retry interval (default from config or 5s)")
+	fs.StringVar(&c.duration, "w", "", "timeout for entries without one (default from config or 1m)")
+	fs.StringVar(&c.output, "o", "text", "output format: text, json, ndjson, junit, tap")
+	parallel := fs.Int("parallel", 8, "maximum number of checks running at once")
+	format := fs.String("format", "", "batch file format: "+strings.Join(batchFormats, ", ")+" (default from the file extension)")
+	return func(args []string) int {
+		switch {
+		case len(args) == 0:
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "batch file is required"))
+		case *parallel < 1:
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "-parallel must be at least 1"))
+		case !slices.Contains([]string{"text", "json", "ndjson", reportJUnit, reportTAP}, c.output):
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "unknown output format %q", c.output))
+		}
+		entries, err := loadBatch(args[0], *format)
+		if err != nil {
+			return fail(err)
+		}
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+		s.primeRoots()
+		return runBatchOutput(os.Stdout, entries, &c, *parallel)
+	}
+}
+
+// runBatchOutput runs a batch, prints it in the -o format and returns the
+// highest exit code of its entries.
+func runBatchOutput(w io.Writer, entries []batchEntry, c *checkFlags, parallel int) int {
+	var done func(int, *BatchResult)
+	switch c.output {
+	case "text":
+		finished := 0
+		fmt.Fprintf(w, "Checking %d entries, %d at a time\n", len(entries), parallel)
+		done = func(i int, r *BatchResult) {
+			finished++
+			fmt.Fprintf(w, "[%d/%d] %s: %s %s %s\n", finished, len(entries), r.Status, r.Domain, r.Type, r.Match)
+		}
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		done = func(i int, r *BatchResult) { enc.Encode(r) }
+	}
+
+	report := runBatch(entries, c, parallel, done)
+	switch c.output {
+	case "text":
+		fmt.Fprintln(w)
+		printBatchReport(w, report)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	case reportJUnit, reportTAP:
+		writeReport(w, c.output, report.Entries)
+	}
+	return report.ExitCode
+}
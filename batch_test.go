@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadBatch(t *testing.T) {
+	want := []batchEntry{
+		{Domain: "www.example.test", Type: "a", Match: "192.0.2.10", Timeout: "2s"},
+		{Domain: "mail.example.test", Type: "mx", Match: "mx1"},
+	}
+	tests := []struct {
+		name, file, format, data string
+	}{
+		{"yaml", "checks.yaml", "", `
+- domain: www.example.test
+  type: a
+  match: 192.0.2.10
+  timeout: 2s
+- {domain: mail.example.test, type: mx, match: mx1}
+`},
+		{"csv", "checks.csv", "", "# migration\nwww.example.test,a,192.0.2.10,2s\nmail.example.test, mx, mx1\n"},
+		{"csv header", "checks.csv", "", "match,domain,type,timeout\n192.0.2.10,www.example.test,a,2s\nmx1,mail.example.test,mx\n"},
+		{"jsonl", "checks.txt", "jsonl", `{"domain":"www.example.test","type":"a","match":"192.0.2.10","timeout":"2s"}
+
+{"domain":"mail.example.test","type":"mx","match":"mx1"}
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			os.WriteFile(path, []byte(tt.data), 0o644)
+			got, err := loadBatch(path, tt.format)
+			if err != nil {
+				t.Fatalf("loadBatch: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadBatchErrors(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"checks.txt":   "www.example.test,a,1",
+		"empty.yaml":   "",
+		"bad.jsonl":    "{\"domain\": ",
+		"columns.csv":  "domain,record\nwww.example.test,a\n",
+		"mapping.yaml": "domain: www.example.test",
+	} {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(data), 0o644)
+		if _, err := loadBatch(path, ""); err == nil || exitCodeFor(err) != exitInvalidInput {
+			t.Errorf("%s: got %v, want an invalid input error", name, err)
+		}
+	}
+}
+
+func TestRunBatch(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	h.Publish(www + " 300 IN TXT \"v=spf1 -all\"")
+
+	entries := []batchEntry{
+		{Domain: "www.example.test", Match: "192.0.2.10"},
+		{Domain: "www.example.test", Type: "txt", Match: "v=spf1"},
+		{Domain: "www.example.nope", Match: "192.0.2.10"},
+		{Domain: "www.example.test", Type: "aaaa", Match: "2001:db8::1", Timeout: "200ms"},
+		{Domain: "www.example.test"},
+	}
+	c := &checkFlags{recordType: "a", retry: "50ms", duration: "2s", output: "json"}
+
+	var buf bytes.Buffer
+	code := runBatchOutput(&buf, entries, c, 2)
+	if code != exitDiscovery {
+		t.Errorf("exit code %d, want %d", code, exitDiscovery)
+	}
+
+	var report BatchReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	var statuses []string
+	for _, r := range report.Entries {
+		statuses = append(statuses, r.Status)
+	}
+	want := "propagated propagated nxdomain authoritative_timeout invalid_input"
+	if got := strings.Join(statuses, " "); got != want {
+		t.Errorf("statuses %q, want %q", got, want)
+	}
+	if r := report.Entries[1]; r.Type != "TXT" || r.Reached != "4/4" || r.Response == nil {
+		t.Errorf("entry %+v", r)
+	}
+	if report.Statuses["propagated"] != 2 || report.ExitCode != code {
+		t.Errorf("report %+v", report)
+	}
+}
+
+func TestBatchText(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	var buf bytes.Buffer
+	c := &checkFlags{recordType: "a", retry: "50ms", duration: "2s", output: "text"}
+	runBatchOutput(&buf, []batchEntry{{Domain: "www.example.test", Match: "192.0.2.10"}}, c, 1)
+
+	out := buf.String()
+	for _, want := range []string{
+		"[1/1] propagated: www.example.test A 192.0.2.10",
+		"propagated  www.example.test  A     192.0.2.10  4/4",
+		"1 checks in",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSharedDiscovery(t *testing.T) {
+	useHierarchy(t)
+	d := newSharedDiscovery()
+
+	a, err := d.discover(t.Context(), www, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := d.discover(t.Context(), www, 16)
+	if len(d.names) != 1 || len(a) != 2 || len(b) != 2 {
+		t.Fatalf("%d discoveries, servers %v and %v", len(d.names), a, b)
+	}
+	// Each entry polls its own copy
+	a[0].Propagated = true
+	if b[0].Propagated || a[0] == b[0] {
+		t.Error("entries share server state")
+	}
+}
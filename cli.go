@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// Exit codes of the CLI. Scripts rely on them; keep the README in sync.
+const (
+	exitPropagated       = 0 // every server has the record
+	exitPartial          = 1 // the authoritative servers have it, some resolvers not yet
+	exitInvalidInput     = 2 // bad flags, domain or record type (as the flag package does)
+	exitNotAuthoritative = 3 // some authoritative servers lacked the record at the timeout
+	exitDiscovery        = 4 // the authoritative servers could not be found
+	exitInternal         = 5 // anything else, e.g. a capture file that cannot be written
+)
+
+// Outcome codes of a check that timed out, as in the "timeout" event
+const (
+	codePartial              = "partial"
+	codeAuthoritativeTimeout = "authoritative_timeout"
+)
+
+// Output formats for -o
+var outputFormats = []string{"text", "json", "ndjson", "quiet", reportJUnit, reportTAP}
+
+// checkOutput renders the progress and outcome of a CLI check. Methods are
+// never called concurrently, and come in the order the check runs.
+type checkOutput interface {
+	begin(domain string, opts *checkOptions)
+	discovered(servers []*dnspkg.ResolverStatus)
+	polling(authServers, resolvers []*dnspkg.ResolverStatus)
+	propagated(s *dnspkg.ResolverStatus, isAuth bool)
+	finish(r *checkResult)
+	fail(err error)
+}
+
+// checkOptions are the parameters of a CLI check.
+type checkOptions struct {
+	domain     string
+	recordType string
+	match      string
+	retry      time.Duration
+	duration   time.Duration
+	// sequential polls resolvers only once every authoritative server has
+	// the record, and counts their times from that point.
+	sequential bool
+	// once queries every server a single time instead of waiting for the
+	// record to appear.
+	once bool
+	// authOnly stops once every authoritative server has the record,
+	// without checking resolvers.
+	authOnly bool
+	// noCache discovers from the root instead of the cached delegations.
+	noCache bool
+	// discover finds the authoritative servers of a name; nil uses
+	// dnspkg.DiscoverAuthoritativeContext from the configured roots.
+	discover func(ctx context.Context, domain string, qtype uint16) ([]*dnspkg.ResolverStatus, error)
+}
+
+// context returns the context the queries of the check start from.
+func (o *checkOptions) context() context.Context {
+	if o.noCache {
+		return dnspkg.WithoutDelegationCache(context.Background())
+	}
+	return context.Background()
+}
+
+// checkResult is the state of a CLI check once polling stopped.
+type checkResult struct {
+	once        bool
+	authOnly    bool
+	response    *dnspkg.CheckResponse
+	authServers []*dnspkg.ResolverStatus
+	resolvers   []*dnspkg.ResolverStatus // nil if only the authoritative servers were polled
+	authDone    bool
+	duration    time.Duration
+	elapsed     time.Duration
+}
+
+// newCheckOutput returns the renderer for an -o format, or nil if the format
+// is unknown.
+func newCheckOutput(format string, w io.Writer) checkOutput {
+	switch format {
+	case "", "text":
+		return &textOutput{w: w}
+	case "json":
+		return &jsonOutput{w: w}
+	case "ndjson":
+		return &ndjsonOutput{enc: json.NewEncoder(w)}
+	case "quiet":
+		return &quietOutput{w: w}
+	case reportJUnit, reportTAP:
+		return &reportOutput{w: w, format: format}
+	}
+	return nil
+}
+
+// code returns the timeout outcome code, or "" if every server propagated.
+func (r *checkResult) code() string {
+	switch {
+	case !r.authDone:
+		return codeAuthoritativeTimeout
+	case !r.response.AllPropagated:
+		return codePartial
+	}
+	return ""
+}
+
+// exitCode returns the CLI exit code for the result.
+func (r *checkResult) exitCode() int {
+	switch r.code() {
+	case codeAuthoritativeTimeout:
+		return exitNotAuthoritative
+	case codePartial:
+		return exitPartial
+	}
+	return exitPropagated
+}
+
+// reached counts the servers that have the record. Until every authoritative
+// server has it only those count, and scope says so.
+func (r *checkResult) reached() (found, total int, scope string) {
+	servers := append(r.response.Authoritative, r.response.Resolvers...)
+	scope = "servers"
+	if !r.authDone || r.authOnly {
+		servers, scope = r.response.Authoritative, "authoritative servers"
+	}
+	for _, s := range servers {
+		if s.Propagated {
+			found++
+		}
+	}
+	return found, len(servers), scope
+}
+
+// runCLI runs a check, renders it to out and returns the exit code. The
+// authoritative servers and resolvers are polled together unless
+// opts.sequential is set.
+func runCLI(out checkOutput, opts *checkOptions) int {
+	recordType, match := opts.recordType, opts.match
+	dnsType := dnspkg.ParseRecordType(recordType)
+	if dnsType == 0 {
+		out.fail(dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type %q", recordType))
+		return exitInvalidInput
+	}
+
+	domain, err := dnspkg.PrepareName(opts.domain, dnsType)
+	if err != nil {
+		out.fail(err)
+		return exitInvalidInput
+	}
+
+	out.begin(domain, opts)
+
+	// Step 1: Find all authoritative nameservers
+	discover := opts.discover
+	if discover == nil {
+		discover = func(ctx context.Context, domain string, qtype uint16) ([]*dnspkg.ResolverStatus, error) {
+			return dnspkg.DiscoverAuthoritativeContext(ctx, domain, qtype, config.Roots())
+		}
+	}
+	authServers, err := discover(opts.context(), domain, dnsType)
+	if err != nil {
+		out.fail(fmt.Errorf("failed to find authoritative servers: %w", err))
+		return exitDiscovery
+	}
+	if len(authServers) == 0 {
+		out.fail(dnspkg.Errorf(dnspkg.ErrLameDelegation, "no authoritative nameservers found"))
+		return exitDiscovery
+	}
+	out.discovered(authServers)
+
+	// Propagation events are rendered as they happen, one at a time
+	var mu sync.Mutex
+	onFound := func(isAuth bool) func(*dnspkg.ResolverStatus) {
+		return func(s *dnspkg.ResolverStatus) { out.propagated(s, isAuth) }
+	}
+
+	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(opts.context(), opts.duration)
+	defer cancel()
+
+	sched := config.Schedule(opts.retry)
+	authProbe := dnspkg.AuthoritativeProbe(domain, dnsType, match, config.Roots())
+	resolverProbe := dnspkg.ResolverProbe(domain, dnsType, match)
+	result := &checkResult{once: opts.once, authOnly: opts.authOnly, authServers: authServers, duration: opts.duration}
+
+	switch {
+	case opts.once:
+		// Step 2: Query every server once
+		result.resolvers = dnspkg.BuildResolvers(config.PublicResolvers)
+		out.polling(authServers, result.resolvers)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			dnspkg.ProbeOnce(ctx, authServers, authProbe, startTime, &mu, onFound(true))
+		}()
+		go func() {
+			defer wg.Done()
+			dnspkg.ProbeOnce(ctx, result.resolvers, resolverProbe, startTime, &mu, onFound(false))
+		}()
+		wg.Wait()
+		mu.Lock()
+		result.authDone = dnspkg.AllPropagated(authServers)
+		mu.Unlock()
+
+	case opts.sequential, opts.authOnly:
+		// Step 2: Check all authoritative nameservers
+		out.polling(authServers, nil)
+		dnspkg.Poll(ctx, authServers, authProbe, sched, startTime, &mu, onFound(true))
+		mu.Lock()
+		result.authDone = dnspkg.AllPropagated(authServers)
+		mu.Unlock()
+
+		// Step 3: Check public resolvers
+		if result.authDone && !opts.authOnly {
+			result.resolvers = dnspkg.BuildResolvers(config.PublicResolvers)
+			out.polling(nil, result.resolvers)
+			dnspkg.Poll(ctx, result.resolvers, resolverProbe, sched, time.Now(), &mu, onFound(false))
+		}
+
+	default:
+		// Step 2: Check authoritative nameservers and public resolvers
+		result.resolvers = dnspkg.BuildResolvers(config.PublicResolvers)
+		out.polling(authServers, result.resolvers)
+		dnspkg.Watch(ctx, authServers, result.resolvers, authProbe, resolverProbe, sched, startTime, &mu, out.propagated)
+		mu.Lock()
+		result.authDone = dnspkg.AllPropagated(authServers)
+		mu.Unlock()
+	}
+
+	mu.Lock()
+	result.response = dnspkg.NewCheckResponse(domain, recordType, match, authServers, result.resolvers)
+	mu.Unlock()
+	result.elapsed = time.Since(startTime)
+
+	out.finish(result)
+	return result.exitCode()
+}
+
+// textOutput prints the check as human-readable prose.
+type textOutput struct {
+	w          io.Writer
+	recordType string
+}
+
+func (o *textOutput) begin(domain string, opts *checkOptions) {
+	o.recordType = strings.ToUpper(opts.recordType)
+	name := dnspkg.FormatName(domain)
+	if opts.once {
+		fmt.Fprintf(o.w, "Auditing %s (%s=%s)\n\n", name, o.recordType, opts.match)
+	} else {
+		fmt.Fprintf(o.w, "Testing DNS propagation for %s (%s=%s)\n", name, o.recordType, opts.match)
+		fmt.Fprintf(o.w, "Retry interval: %s, Max duration: %s\n\n", opts.retry, opts.duration)
+	}
+	fmt.Fprintln(o.w, "=== Discovering authoritative nameservers ===")
+}
+
+func (o *textOutput) discovered(servers []*dnspkg.ResolverStatus) {
+	fmt.Fprintf(o.w, "Found %d authoritative nameservers:\n", len(servers))
+	for _, ns := range servers {
+		if ns.QName != "" {
+			fmt.Fprintf(o.w, "  - %s (%s) for %s\n", ns.Name, strings.TrimSuffix(ns.Addr, ":53"), strings.TrimSuffix(ns.QName, "."))
+		} else {
+			fmt.Fprintf(o.w, "  - %s (%s)\n", ns.Name, strings.TrimSuffix(ns.Addr, ":53"))
+		}
+	}
+	fmt.Fprintln(o.w)
+}
+
+func (o *textOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {
+	switch {
+	case resolvers == nil:
+		fmt.Fprintln(o.w, "=== Checking authoritative nameservers ===")
+	case authServers == nil:
+		fmt.Fprintln(o.w, "\nAll authoritative nameservers have the record!")
+		fmt.Fprintln(o.w, "\n=== Checking public resolvers for propagation ===")
+	default:
+		fmt.Fprintf(o.w, "=== Checking %d authoritative nameservers and %d resolvers ===\n", len(authServers), len(resolvers))
+	}
+}
+
+func (o *textOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool) {
+	if isAuth {
+		fmt.Fprintf(o.w, " - %s authoritative %s has record %s (%s)%s\n",
+			dnspkg.FormatDuration(s.FoundAt), s.Name, o.recordType, s.Record, resultNote(s))
+	} else {
+		fmt.Fprintf(o.w, " - %s resolver %s propagated record %s (%s)%s\n",
+			dnspkg.FormatDuration(s.FoundAt), s.Name, o.recordType, s.Record, resultNote(s))
+	}
+}
+
+func (o *textOutput) finish(r *checkResult) {
+	fmt.Fprintf(o.w, "\n%s\n", verdict(r))
+	switch r.code() {
+	case codeAuthoritativeTimeout:
+		dnspkg.PrintSummary(o.w, r.authServers, "authoritative")
+		if r.resolvers != nil {
+			dnspkg.PrintSummary(o.w, r.resolvers, "resolver")
+		}
+	case codePartial:
+		dnspkg.PrintSummary(o.w, r.resolvers, "resolver")
+	}
+}
+
+// verdict describes the outcome of a check in a sentence.
+func verdict(r *checkResult) string {
+	if r.once {
+		switch r.code() {
+		case codeAuthoritativeTimeout:
+			return "Not all authoritative servers have the record"
+		case codePartial:
+			return "Not all resolvers have the record yet"
+		}
+		return "All servers have the record"
+	}
+	if r.authOnly && r.code() == "" {
+		return "All authoritative servers have the record"
+	}
+	switch r.code() {
+	case codeAuthoritativeTimeout:
+		return "Timeout: not all authoritative servers have the record"
+	case codePartial:
+		return fmt.Sprintf("Timeout reached after %s", r.duration)
+	}
+	return "All resolvers propagated!"
+}
+
+func (o *textOutput) fail(err error) {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+}
+
+// resultNote formats how a match was reached, i.e. the CNAME/DNAME chain and
+// wildcard synthesis, as a suffix for CLI output.
+func resultNote(s *dnspkg.ResolverStatus) string {
+	note := ""
+	if len(s.Chain) > 0 {
+		note = " via " + dnspkg.FormatChain(s.Chain)
+	}
+	if s.Wildcard {
+		note += " [" + dnspkg.WildcardNote + "]"
+	}
+	return note
+}
+
+// jsonOutput prints the final result as the same document GET /check returns,
+// or an ErrorResponse if the check could not run.
+type jsonOutput struct {
+	w io.Writer
+}
+
+func (o *jsonOutput) begin(domain string, opts *checkOptions)                 {}
+func (o *jsonOutput) discovered(servers []*dnspkg.ResolverStatus)             {}
+func (o *jsonOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {}
+func (o *jsonOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool)        {}
+
+func (o *jsonOutput) finish(r *checkResult) {
+	o.encode(r.response)
+}
+
+func (o *jsonOutput) fail(err error) {
+	o.encode(dnspkg.ErrorResponse{Error: err.Error(), Code: dnspkg.ErrorCode(err)})
+}
+
+func (o *jsonOutput) encode(v any) {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// ndjsonOutput prints one StreamEvent per line, as /check/stream sends them.
+type ndjsonOutput struct {
+	enc *json.Encoder
+}
+
+func (o *ndjsonOutput) begin(domain string, opts *checkOptions) {
+	o.enc.Encode(StreamEvent{
+		Type:          "check",
+		Domain:        strings.TrimSuffix(domain, "."),
+		DomainUnicode: dnspkg.DisplayName(domain),
+	})
+}
+
+func (o *ndjsonOutput) discovered(servers []*dnspkg.ResolverStatus) {
+	for _, s := range servers {
+		o.enc.Encode(StreamEvent{Type: "discovered", Server: dnspkg.NewServerStatus(s)})
+	}
+}
+
+func (o *ndjsonOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {
+	for _, r := range resolvers {
+		o.enc.Encode(StreamEvent{Type: "resolver", Server: dnspkg.NewServerStatus(r)})
+	}
+}
+
+func (o *ndjsonOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool) {
+	event := StreamEvent{Type: "resolver_propagated", Server: dnspkg.NewServerStatus(s)}
+	if isAuth {
+		event.Type = "auth_propagated"
+	}
+	o.enc.Encode(event)
+}
+
+func (o *ndjsonOutput) finish(r *checkResult) {
+	if code := r.code(); code != "" {
+		o.enc.Encode(StreamEvent{Type: "timeout", Code: code})
+	} else {
+		o.enc.Encode(StreamEvent{Type: "complete"})
+	}
+}
+
+func (o *ndjsonOutput) fail(err error) {
+	o.enc.Encode(StreamEvent{Type: "error", Error: err.Error(), Code: dnspkg.ErrorCode(err)})
+}
+
+// quietOutput prints a single verdict line once the check is over. The line
+// starts with "propagated", a timeout code or an error code.
+type quietOutput struct {
+	w io.Writer
+}
+
+func (o *quietOutput) begin(domain string, opts *checkOptions)                 {}
+func (o *quietOutput) discovered(servers []*dnspkg.ResolverStatus)             {}
+func (o *quietOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {}
+func (o *quietOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool)        {}
+
+func (o *quietOutput) finish(r *checkResult) {
+	resp := r.response
+	found, total, scope := r.reached()
+	verdict, after := r.code(), "after"
+	if verdict == "" {
+		verdict, after = "propagated", "in"
+	}
+	fmt.Fprintf(o.w, "%s: %s %s %s reached %d/%d %s %s %s\n",
+		verdict, resp.Domain, resp.RecordType, resp.Match, found, total, scope, after, dnspkg.FormatDuration(r.elapsed))
+}
+
+func (o *quietOutput) fail(err error) {
+	fmt.Fprintf(o.w, "%s: %v\n", dnspkg.ErrorCode(err), err)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+)
+
+// runCLIOutput runs a successful CLI check against the fake tree and returns
+// what it printed to out.
+func runCLIOutput(t *testing.T, out checkOutput, sequential bool) {
+	t.Helper()
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	opts := &checkOptions{domain: "www.example.test", recordType: "a", match: "192.0.2.10", retry: 50 * time.Millisecond, duration: 2 * time.Second, sequential: sequential}
+	if code := runCLI(out, opts); code != exitPropagated {
+		t.Fatalf("exit code %d, want %d", code, exitPropagated)
+	}
+}
+
+func TestCLIExitCodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		publish    func(h *dnstest.Hierarchy)
+		domain     string
+		recordType string
+		want       int
+		verdict    string
+	}{
+		{
+			name:    "propagated",
+			publish: func(h *dnstest.Hierarchy) { h.Publish(www + " 300 IN A 192.0.2.10") },
+			want:    exitPropagated,
+			verdict: "propagated",
+		},
+		{
+			name: "resolvers behind",
+			publish: func(h *dnstest.Hierarchy) {
+				for _, ns := range h.NS {
+					ns.Add(www + " 300 IN A 192.0.2.10")
+				}
+			},
+			want:    exitPartial,
+			verdict: "partial",
+		},
+		{
+			name: "authoritative behind",
+			publish: func(h *dnstest.Hierarchy) {
+				h.NS[0].Add(www + " 300 IN A 192.0.2.10")
+			},
+			want:    exitNotAuthoritative,
+			verdict: "authoritative_timeout",
+		},
+		{
+			name:    "no such domain",
+			domain:  "www.example.nope",
+			want:    exitDiscovery,
+			verdict: "nxdomain",
+		},
+		{
+			name:       "unsupported type",
+			recordType: "spf",
+			want:       exitInvalidInput,
+			verdict:    "unsupported_type",
+		},
+		{
+			name:    "invalid domain",
+			domain:  "bad..name",
+			want:    exitInvalidInput,
+			verdict: "invalid_input",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := useHierarchy(t)
+			if tt.publish != nil {
+				tt.publish(h)
+			}
+			domain, recordType := "www.example.test", "a"
+			if tt.domain != "" {
+				domain = tt.domain
+			}
+			if tt.recordType != "" {
+				recordType = tt.recordType
+			}
+
+			var buf bytes.Buffer
+			opts := &checkOptions{domain: domain, recordType: recordType, match: "192.0.2.10", retry: 50 * time.Millisecond, duration: 300 * time.Millisecond}
+			code := runCLI(newCheckOutput("quiet", &buf), opts)
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d", code, tt.want)
+			}
+			if !strings.HasPrefix(buf.String(), tt.verdict+":") {
+				t.Errorf("got %q, want verdict %s", buf.String(), tt.verdict)
+			}
+		})
+	}
+}
+
+func TestCLIOutputJSON(t *testing.T) {
+	var buf bytes.Buffer
+	runCLIOutput(t, newCheckOutput("json", &buf), false)
+
+	var resp dnspkg.CheckResponse
+	if err := json.Unmarshal(buf.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", buf.String(), err)
+	}
+	if !resp.AllPropagated || resp.Domain != "www.example.test" || len(resp.Authoritative) != 2 || len(resp.Resolvers) != 2 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestCLIOutputNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	runCLIOutput(t, newCheckOutput("ndjson", &buf), false)
+
+	types := eventTypes(t, &buf)
+	want := "check discovered discovered resolver resolver"
+	if got := strings.Join(types[:5], " "); got != want {
+		t.Errorf("events before polling:\n got %s\nwant %s", got, want)
+	}
+	counts := make(map[string]int)
+	for _, typ := range types[5:] {
+		counts[typ]++
+	}
+	if counts["auth_propagated"] != 2 || counts["resolver_propagated"] != 2 || types[len(types)-1] != "complete" {
+		t.Errorf("events while polling: %v", types[5:])
+	}
+}
+
+func TestCLISequential(t *testing.T) {
+	var buf bytes.Buffer
+	runCLIOutput(t, newCheckOutput("ndjson", &buf), true)
+
+	want := "check discovered discovered auth_propagated auth_propagated resolver resolver resolver_propagated resolver_propagated complete"
+	if got := strings.Join(eventTypes(t, &buf), " "); got != want {
+		t.Errorf("events:\n got %s\nwant %s", got, want)
+	}
+}
+
+func TestCLITable(t *testing.T) {
+	var buf bytes.Buffer
+	runCLIOutput(t, newTableOutput(&buf), false)
+
+	// Each redraw moves back over the 4 rows of the table
+	got := buf.String()
+	if !strings.Contains(got, "\033[4A") {
+		t.Errorf("table was not redrawn in place:\n%s", got)
+	}
+	last := got[strings.LastIndex(got, "\033[4A"):]
+	if strings.Count(last, "✓") != 4 || !strings.HasSuffix(last, "\nAll resolvers propagated!\n") {
+		t.Errorf("final table:\n%s", last)
+	}
+}
+
+// eventTypes decodes NDJSON output and returns the type of each event.
+func eventTypes(t *testing.T, r io.Reader) []string {
+	t.Helper()
+	var types []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var event StreamEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("bad line %q: %v", scanner.Text(), err)
+		}
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestCLIOutputQuiet(t *testing.T) {
+	var buf bytes.Buffer
+	runCLIOutput(t, newCheckOutput("quiet", &buf), false)
+
+	got := buf.String()
+	if !strings.HasPrefix(got, "propagated: www.example.test A 192.0.2.10 reached 4/4 servers") || strings.Count(got, "\n") != 1 {
+		t.Errorf("got %q, want a single propagated verdict", got)
+	}
+}
+
+func TestCLIOutputFailure(t *testing.T) {
+	err := dnspkg.Errorf(dnspkg.ErrNXDomain, "failed to find authoritative servers: %w", errors.New("no such domain"))
+
+	var buf bytes.Buffer
+	newCheckOutput("json", &buf).fail(err)
+	var resp dnspkg.ErrorResponse
+	if json.Unmarshal(buf.Bytes(), &resp); resp.Code != "nxdomain" || !strings.Contains(resp.Error, "no such domain") {
+		t.Errorf("json: got %+v", resp)
+	}
+
+	buf.Reset()
+	newCheckOutput("ndjson", &buf).fail(err)
+	var event StreamEvent
+	if json.Unmarshal(buf.Bytes(), &event); event.Type != "error" || event.Code != "nxdomain" {
+		t.Errorf("ndjson: got %+v", event)
+	}
+}
+
+func TestUnknownOutputFormat(t *testing.T) {
+	if newCheckOutput("yaml", &bytes.Buffer{}) != nil {
+		t.Error("unknown format accepted")
+	}
+}
@@ -0,0 +1,671 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	dnspkg "ripple/dns"
+	"ripple/tui"
+)
+
+// command is a ripple subcommand. setup registers its flags and returns the
+// function that runs it with the remaining arguments.
+type command struct {
+	name     string
+	args     string // positional arguments, for the usage line
+	summary  string
+	examples []string
+	setup    func(fs *flag.FlagSet) func(args []string) int
+	// badFlags is the exit code for flags that do not parse, if it is not
+	// exitInvalidInput.
+	badFlags int
+	// keepDash passes on a "--" that ended the flags, which the flag package
+	// drops, for commands that tell arguments apart by it.
+	keepDash bool
+}
+
+var commands = []*command{
+	{
+		name:    "check",
+		args:    "<domain>",
+		summary: "Wait until a record has propagated to every authoritative server and resolver",
+		examples: []string{
+			"check -t txt -m _varnish-cdn-verify lab.varnish.cloud",
+			"check -t a -m 1.1.1.1 -w 30s -r 3s one.one.one.one",
+			"check -t ptr -m one.one.one.one 1.1.1.1",
+			"check -o json -t a -m 1.1.1.1 one.one.one.one | jq .all_propagated",
+			"check -record capture.jsonl -t a -m 1.2.3.4 example.com",
+			"check -replay capture.jsonl",
+		},
+		setup: setupCheck,
+	},
+	{
+		name:    "audit",
+		args:    "<domain>",
+		summary: "Query every server once and report which have a record right now",
+		examples: []string{
+			"audit -t a -m 1.1.1.1 one.one.one.one",
+			"audit -q -t txt -m v=spf1 google.com",
+		},
+		setup: setupAudit,
+	},
+	{
+		name:    "watch",
+		args:    "<domain>",
+		summary: "Audit a record on an interval and report every server that gains or loses it",
+		examples: []string{
+			"watch -t a -m 1.1.1.1 one.one.one.one",
+			"watch -every 5m -count 12 -t mx -m mail example.com",
+		},
+		setup: setupWatch,
+	},
+	{
+		name:    "wait",
+		args:    "<domain> -- <command> [arguments]",
+		summary: "Wait until a record has propagated, then run a command and exit with its status",
+		examples: []string{
+			"wait -t a -m 192.0.2.10 -w 10m www.example.com -- kubectl rollout restart deploy/web",
+			"wait -until authoritative -t txt -m token _acme-challenge.example.com -- certbot renew",
+			"wait -on-timeout 'notify-send \"DNS not ready\"' -t a -m 1.2.3.4 example.com -- ./deploy.sh",
+			"wait -replay capture.jsonl -- ./deploy.sh",
+		},
+		setup:    setupWait,
+		keepDash: true,
+	},
+	{
+		name:    "acme",
+		args:    "certbot | lego present|cleanup <fqdn> <value> | wait <domain> <value>",
+		summary: "Wait for an ACME DNS-01 challenge record on every authoritative server, as a certbot, lego or acme.sh hook",
+		examples: []string{
+			"acme certbot   (as certbot --manual-auth-hook)",
+			"acme -exec ./update-dns.sh certbot",
+			"acme -exec ./update-dns.sh lego present _acme-challenge.example.com. token",
+			"acme wait example.com token",
+		},
+		setup: setupACME,
+	},
+	{
+		name:    "plugin",
+		args:    "<domain>",
+		summary: "Query every server once as a Nagios, Icinga or Sensu plugin: a status line with perfdata and an OK/WARNING/CRITICAL exit code",
+		examples: []string{
+			"plugin -t a -m 192.0.2.10 api.example.com",
+			"plugin -resolvers-warning 80% -resolvers-critical 50% -t a -m 192.0.2.10 api.example.com",
+			"plugin -resolvers-warning 0 -t mx -m mail.example.com example.com",
+		},
+		setup:    setupPlugin,
+		badFlags: pluginUnknown,
+	},
+	{
+		name:    "batch",
+		args:    "<file>",
+		summary: "Check many records from a YAML, CSV or JSONL file and report on all of them",
+		examples: []string{
+			"batch migration.yaml",
+			"batch -parallel 20 -w 10m records.csv",
+			"batch -o json -format jsonl - < records.jsonl",
+		},
+		setup: setupBatch,
+	},
+	{
+		name:    "trace",
+		args:    "<domain>",
+		summary: "Show the delegation path from the root to the authoritative answers, like dig +trace",
+		examples: []string{
+			"trace -t a one.one.one.one",
+			"trace -o json -t txt _acme-challenge.example.com",
+		},
+		setup: setupTrace,
+	},
+	{
+		name:    "serve",
+		args:    "[address]",
+		summary: "Start the HTTP API and web UI",
+		examples: []string{
+			"serve :8080",
+			"serve -c config.yaml",
+		},
+		setup: setupServe,
+	},
+	{
+		name:     "tui",
+		summary:  "Launch the interactive terminal UI",
+		examples: []string{"tui", "tui -c config.yaml"},
+		setup:    setupTUI,
+	},
+}
+
+// run dispatches the command line to a subcommand, or to the flat flags of
+// earlier releases if it does not start with one. It returns the exit code.
+func run(args []string) int {
+	if len(args) > 0 {
+		if args[0] == "help" {
+			if len(args) > 1 {
+				if c := findCommand(args[1]); c != nil {
+					c.usage(flag.NewFlagSet(c.name, flag.ContinueOnError))
+					return exitPropagated
+				}
+			}
+			usage(legacyFlags(new(globalOptions), new(checkFlags)))
+			return exitPropagated
+		}
+		if c := findCommand(args[0]); c != nil {
+			return c.main(args[1:])
+		}
+	}
+	return runLegacy(args)
+}
+
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// main parses the command's flags and runs it.
+func (c *command) main(args []string) int {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	run := c.setup(fs)
+	fs.Usage = func() { c.usage(fs) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitPropagated
+		}
+		if c.badFlags != 0 {
+			return c.badFlags
+		}
+		return exitInvalidInput
+	}
+	rest := fs.Args()
+	if c.keepDash && len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+		rest = append([]string{"--"}, rest...)
+	}
+	return run(rest)
+}
+
+// usage prints the help text of the command. Flags are registered on fs if
+// they are not yet.
+func (c *command) usage(fs *flag.FlagSet) {
+	if !hasFlags(fs) {
+		c.setup(fs)
+	}
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: %s\n\n%s.\n\nOptions:\n", strings.TrimSpace(os.Args[0]+" "+c.name+" [options] "+c.args), c.summary)
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nExamples:\n")
+	for _, ex := range c.examples {
+		fmt.Fprintf(w, "  %s %s\n", os.Args[0], ex)
+	}
+}
+
+func hasFlags(fs *flag.FlagSet) bool {
+	found := false
+	fs.VisitAll(func(*flag.Flag) { found = true })
+	return found
+}
+
+// usage prints the top-level help, with the flat flags of fs.
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: %s <command> [options] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-6s  %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the options and examples of a command.\n", os.Args[0])
+	fmt.Fprintf(w, "\nThe flat flags of earlier releases still work:\n")
+	fmt.Fprintf(w, "  %s [options] <domain>      same as check\n", os.Args[0])
+	fmt.Fprintf(w, "  %s -serve :8080            same as serve :8080\n", os.Args[0])
+	fmt.Fprintf(w, "  %s -tui                    same as tui\n\n", os.Args[0])
+	fmt.Fprintf(w, "Options:\n")
+	fs.PrintDefaults()
+	fmt.Fprintf(w, "\nHTTP API Endpoints:\n")
+	fmt.Fprintf(w, "  GET  /health                                    - Health check\n")
+	fmt.Fprintf(w, "  GET  /metrics                                   - Prometheus metrics\n")
+	fmt.Fprintf(w, "  GET  /check?domain=<d>&type=<t>&match=<m>       - One-shot check\n")
+	fmt.Fprintf(w, "  POST /check {domain,type,match,timeout,retry}   - Check with retries\n")
+	fmt.Fprintf(w, "  GET  /trace?domain=<d>&type=<t>                 - Delegation trace\n")
+	fmt.Fprintf(w, "  POST /cache/flush?zone=<z>                      - Forget cached delegations\n")
+	fmt.Fprintf(w, "  GET  /watch                                     - Drift state of the watch list\n")
+	fmt.Fprintf(w, "  POST /watch/check?domain=<d>                    - Check watched records now\n")
+	fmt.Fprintf(w, "\nExit Codes:\n")
+	fmt.Fprintf(w, "  %d  every server has the record\n", exitPropagated)
+	fmt.Fprintf(w, "  %d  authoritative servers have it, some resolvers did not by the timeout\n", exitPartial)
+	fmt.Fprintf(w, "  %d  invalid input (flags, domain, record type, config)\n", exitInvalidInput)
+	fmt.Fprintf(w, "  %d  some authoritative servers did not have it by the timeout\n", exitNotAuthoritative)
+	fmt.Fprintf(w, "  %d  authoritative servers could not be discovered\n", exitDiscovery)
+	fmt.Fprintf(w, "  %d  internal error\n", exitInternal)
+}
+
+// legacyFlags registers the flat flag set of earlier releases.
+func legacyFlags(g *globalOptions, c *checkFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	g.register(fs)
+	c.register(fs, true)
+	fs.String("serve", "", "start HTTP server on address (e.g., :8080)")
+	fs.Bool("tui", false, "launch interactive terminal UI")
+	fs.Usage = func() { usage(fs) }
+	return fs
+}
+
+// runLegacy runs the flat flag forms: -serve, -tui or a check of the domain
+// argument. Without any of them it serves on the listen address from the
+// config file, if there is one.
+func runLegacy(args []string) int {
+	var g globalOptions
+	var c checkFlags
+	fs := legacyFlags(&g, &c)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitPropagated
+		}
+		return exitInvalidInput
+	}
+	serve := fs.Lookup("serve").Value.String()
+	tuiMode := fs.Lookup("tui").Value.String() == "true"
+
+	s, err := g.setup()
+	if err != nil {
+		return fail(err)
+	}
+	defer s.close()
+
+	switch {
+	case tuiMode:
+		return runTUI(s, g.configFile)
+	case serve != "":
+		return runServe(s, serve)
+	case fs.NArg() == 0 && s.replay.Domain == "" && config.Listen != "":
+		return runServe(s, config.Listen)
+	case fs.NArg() == 0 && s.replay.Domain == "":
+		fs.Usage()
+		return exitInvalidInput
+	}
+	return runCheck(s, &c, fs.Arg(0), false)
+}
+
+// globalOptions are the flags every command accepts.
+type globalOptions struct {
+	configFile string
+	recordFile string
+	replayFile string
+}
+
+func (g *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.configFile, "c", "", "config file path (YAML)")
+	fs.StringVar(&g.recordFile, "record", "", "record every DNS query and response to a capture file")
+	fs.StringVar(&g.replayFile, "replay", "", "answer DNS queries from a capture file instead of the network")
+}
+
+// session is the process state set up from the global options.
+type session struct {
+	replay    dnspkg.CaptureHeader // parameters of the replayed check, if any
+	replaying bool
+	recorder  *dnspkg.Recorder
+	capture   *os.File
+}
+
+// setup loads the config file and installs the record or replay transport.
+func (g *globalOptions) setup() (*session, error) {
+	s := &session{}
+	if g.configFile != "" {
+		if err := dnspkg.LoadConfig(&config, g.configFile); err != nil {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "loading config file: %w", err)
+		}
+	}
+	dnspkg.SetLimits(config.Limits)
+
+	// Replay answers every query from a capture; the check parameters default
+	// to the ones it was recorded with.
+	next := dnspkg.DefaultTransport
+	if g.replayFile != "" {
+		replayer, err := dnspkg.LoadReplay(g.replayFile)
+		if err != nil {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "loading capture: %w", err)
+		}
+		dnspkg.SetTransport(replayer)
+		s.replay, s.replaying = replayer.Header(), true
+		next = replayer
+	}
+
+	// Recording a replay copies the exchanges it answers from the capture.
+	if g.recordFile != "" {
+		f, err := os.Create(g.recordFile)
+		if err != nil {
+			return nil, fmt.Errorf("creating capture file: %w", err)
+		}
+		s.capture = f
+		s.recorder = dnspkg.NewRecorder(f, next)
+		dnspkg.SetTransport(s.recorder)
+	}
+	return s, nil
+}
+
+// primeRoots learns the current root servers from the hints (RFC 8109).
+// Commands call it once their arguments are valid, right before discovery.
+// A replay starts from the hints: its answers are all in the capture.
+func (s *session) primeRoots() {
+	if s.replaying {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := config.PrimeRootServers(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v; using root hints\n", err)
+	}
+}
+
+// needDomain fails early, before any query is made, if a command that
+// checks a record has no domain to check.
+func (g *globalOptions) needDomain(args []string) error {
+	if len(args) == 0 && g.replayFile == "" {
+		return dnspkg.Errorf(dnspkg.ErrInvalidInput, "domain is required")
+	}
+	return nil
+}
+
+// writeHeader saves the parameters of the check in the capture file, if one
+// is being recorded, so a replay can default to them.
+func (s *session) writeHeader(opts *checkOptions) {
+	if s.recorder == nil {
+		return
+	}
+	s.recorder.WriteHeader(dnspkg.CaptureHeader{
+		Domain:     opts.domain,
+		RecordType: opts.recordType,
+		Match:      opts.match,
+		Timeout:    opts.duration.String(),
+		Retry:      opts.retry.String(),
+	})
+}
+
+// close closes the capture file, reporting the first error writing it.
+func (s *session) close() {
+	if s.capture == nil {
+		return
+	}
+	err := s.recorder.Err()
+	if cerr := s.capture.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: writing capture file: %v\n", err)
+	}
+}
+
+// fail prints err and returns the exit code for it.
+func fail(err error) int {
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	return exitCodeFor(err)
+}
+
+// exitCodeFor maps a classified error to an exit code.
+func exitCodeFor(err error) int {
+	switch dnspkg.ErrorCode(err) {
+	case "invalid_input", "unsupported_type":
+		return exitInvalidInput
+	case "nxdomain", "lame_delegation", "unreachable", "loop":
+		return exitDiscovery
+	}
+	return exitInternal
+}
+
+// checkFlags are the flags describing the record to look for and how to
+// report on it.
+type checkFlags struct {
+	recordType string
+	match      string
+	retry      string
+	duration   string
+	output     string
+	quiet      bool
+	sequential bool
+	noCache    bool
+}
+
+// register adds the flags to fs; polling adds the ones that only make sense
+// when waiting for a record.
+func (c *checkFlags) register(fs *flag.FlagSet, polling bool) {
+	fs.StringVar(&c.recordType, "t", "", "record type (a, aaaa, txt, cname, mx, ns, ptr)")
+	fs.StringVar(&c.match, "m", "", "match value in record")
+	fs.StringVar(&c.output, "o", "text", "output format: "+strings.Join(outputFormats, ", "))
+	fs.BoolVar(&c.quiet, "q", false, "print only the final verdict (same as -o quiet)")
+	fs.BoolVar(&c.noCache, "no-cache", false, "discover from the root servers, not from cached delegations (to verify an NS change)")
+	if polling {
+		fs.StringVar(&c.retry, "r", "", "retry interval (default from config or 5s)")
+		fs.StringVar(&c.duration, "w", "", "how long to run (default from config or 1m)")
+		fs.BoolVar(&c.sequential, "sequential", false, "poll resolvers only once every authoritative server has the record")
+	}
+}
+
+// options resolves the flags against the config defaults and a replayed
+// capture into the parameters of a check of domain.
+func (c *checkFlags) options(domain string, replay dnspkg.CaptureHeader) (*checkOptions, error) {
+	opts := &checkOptions{
+		domain:     domain,
+		recordType: config.Defaults.RecordType,
+		match:      c.match,
+		sequential: c.sequential,
+		noCache:    c.noCache,
+	}
+	if opts.domain == "" {
+		opts.domain = replay.Domain
+	}
+	if opts.domain == "" {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "domain is required")
+	}
+
+	recordType, retry, duration := c.recordType, c.retry, c.duration
+	if recordType == "" {
+		recordType = replay.RecordType
+	}
+	if opts.match == "" {
+		opts.match = replay.Match
+	}
+	if retry == "" {
+		retry = replay.Retry
+	}
+	if duration == "" {
+		duration = replay.Timeout
+	}
+	if recordType != "" {
+		opts.recordType = recordType
+	}
+	if opts.match == "" {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "-m (match) is required")
+	}
+
+	// Apply defaults from config, override with flags
+	opts.retry, _ = time.ParseDuration(config.Defaults.Retry)
+	if retry != "" {
+		d, err := time.ParseDuration(retry)
+		if err != nil || d <= 0 {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid retry interval %q", retry)
+		}
+		opts.retry = d
+	}
+	opts.duration, _ = time.ParseDuration(config.Defaults.Timeout)
+	if duration != "" {
+		d, err := time.ParseDuration(duration)
+		if err != nil || d <= 0 {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid duration %q", duration)
+		}
+		opts.duration = d
+	}
+	return opts, nil
+}
+
+// newOutput returns the renderer selected by the flags. Text output on a
+// terminal becomes a live table unless the phases run one after the other.
+func (c *checkFlags) newOutput(w *os.File) (checkOutput, error) {
+	format := c.output
+	if c.quiet {
+		format = "quiet"
+	}
+	out := newCheckOutput(format, w)
+	if out == nil {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "unknown output format %q", format)
+	}
+	if format == "text" && !c.sequential && isTerminal(w) {
+		out = newTableOutput(w)
+	}
+	return out, nil
+}
+
+func setupCheck(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	g.register(fs)
+	c.register(fs, true)
+	return func(args []string) int {
+		if err := g.needDomain(args); err != nil {
+			return fail(err)
+		}
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+		return runCheck(s, &c, firstArg(args), false)
+	}
+}
+
+func setupAudit(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	g.register(fs)
+	c.register(fs, false)
+	fs.StringVar(&c.duration, "w", "10s", "how long to wait for answers")
+	return func(args []string) int {
+		if err := g.needDomain(args); err != nil {
+			return fail(err)
+		}
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+		return runCheck(s, &c, firstArg(args), true)
+	}
+}
+
+// runCheck runs a check of domain, or a single round of queries if once is
+// set, and returns the exit code.
+func runCheck(s *session, c *checkFlags, domain string, once bool) int {
+	out, err := c.newOutput(os.Stdout)
+	if err != nil {
+		return fail(err)
+	}
+	opts, err := c.options(domain, s.replay)
+	if err != nil {
+		out.fail(err)
+		return exitCodeFor(err)
+	}
+	opts.once = once
+
+	s.writeHeader(opts)
+	s.primeRoots()
+	return runCLI(out, opts)
+}
+
+func setupWatch(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	g.register(fs)
+	fs.StringVar(&c.recordType, "t", "", "record type (a, aaaa, txt, cname, mx, ns, ptr)")
+	fs.StringVar(&c.match, "m", "", "match value in record")
+	every := fs.Duration("every", 30*time.Second, "how often to query the servers")
+	count := fs.Int("count", 0, "stop after this many rounds (0 = until interrupted)")
+	return func(args []string) int {
+		if *every <= 0 {
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "-every must be positive"))
+		}
+		if err := g.needDomain(args); err != nil {
+			return fail(err)
+		}
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+		opts, err := c.options(firstArg(args), s.replay)
+		if err != nil {
+			return fail(err)
+		}
+		s.primeRoots()
+		ctx, stop := interruptContext()
+		defer stop()
+		return runWatch(ctx, os.Stdout, opts, *every, *count)
+	}
+}
+
+func setupServe(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	g.register(fs)
+	listen := fs.String("listen", "", "address to listen on (default from config or :8080)")
+	return func(args []string) int {
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+
+		addr := firstArg(args)
+		if addr == "" {
+			addr = *listen
+		}
+		if addr == "" {
+			addr = config.Listen
+		}
+		if addr == "" {
+			addr = ":8080"
+		}
+		return runServe(s, addr)
+	}
+}
+
+func setupTUI(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	g.register(fs)
+	return func(args []string) int {
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+		return runTUI(s, g.configFile)
+	}
+}
+
+func runServe(s *session, addr string) int {
+	s.primeRoots()
+	if err := runServer(addr); err != nil {
+		return fail(fmt.Errorf("server failed: %w", err))
+	}
+	return exitPropagated
+}
+
+func runTUI(s *session, configFile string) int {
+	s.primeRoots()
+	p := tea.NewProgram(tui.New(&config, configFile), tea.WithAltScreen())
+	if _, err := p.Run(); err != nil {
+		return fail(fmt.Errorf("running TUI: %w", err))
+	}
+	return exitPropagated
+}
+
+func firstArg(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0]
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+)
+
+// captureStdout runs fn with os.Stdout redirected and returns what it wrote.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	saved := os.Stdout
+	os.Stdout = f
+	defer func() { os.Stdout = saved }()
+	fn()
+
+	f.Seek(0, io.SeekStart)
+	out, _ := io.ReadAll(f)
+	return string(out)
+}
+
+func TestRunCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want int
+		out  string // prefix of stdout
+	}{
+		{"check", []string{"check", "-q", "-r", "50ms", "-w", "2s", "-t", "a", "-m", "192.0.2.10", "www.example.test"}, exitPropagated, "propagated:"},
+		{"check no cache", []string{"check", "-q", "-no-cache", "-r", "50ms", "-w", "2s", "-m", "192.0.2.10", "www.example.test"}, exitPropagated, "propagated:"},
+		{"legacy flags", []string{"-q", "-r", "50ms", "-w", "2s", "-t", "a", "-m", "192.0.2.10", "www.example.test"}, exitPropagated, "propagated:"},
+		{"audit", []string{"audit", "-o", "json", "-m", "192.0.2.10", "www.example.test"}, exitPropagated, "{"},
+		{"audit mismatch", []string{"audit", "-q", "-m", "192.0.2.99", "www.example.test"}, exitNotAuthoritative, "authoritative_timeout:"},
+		{"missing match", []string{"check", "-o", "json", "www.example.test"}, exitInvalidInput, `{`},
+		{"bad duration", []string{"check", "-w", "soon", "-m", "192.0.2.10", "www.example.test"}, exitInvalidInput, ""},
+		{"unknown flag", []string{"check", "-bogus"}, exitInvalidInput, ""},
+		{"unknown output", []string{"audit", "-o", "yaml", "-m", "x", "www.example.test"}, exitInvalidInput, ""},
+		{"trace", []string{"trace", "-t", "a", "www.example.test"}, exitPropagated, "Trace of www.example.test A"},
+		{"trace json", []string{"trace", "-o", "json", "www.example.test"}, exitPropagated, "{"},
+		{"trace nxdomain", []string{"trace", "www.example.nope"}, exitDiscovery, "Trace of www.example.nope A"},
+		{"help", []string{"help", "check"}, exitPropagated, ""},
+		{"command help", []string{"watch", "-h"}, exitPropagated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := useHierarchy(t)
+			h.Publish(www + " 300 IN A 192.0.2.10")
+
+			var code int
+			out := captureStdout(t, func() { code = run(tt.args) })
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d; output:\n%s", code, tt.want, out)
+			}
+			if !strings.HasPrefix(out, tt.out) {
+				t.Errorf("output %q, want prefix %q", out, tt.out)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	h := useHierarchy(t)
+	h.NS[0].Add(www + " 300 IN A 192.0.2.10")
+
+	var buf bytes.Buffer
+	opts := &checkOptions{domain: "www.example.test", recordType: "a", match: "192.0.2.10"}
+	go func() {
+		// Complete the change while the first rounds run
+		time.Sleep(150 * time.Millisecond)
+		h.Publish(www + " 300 IN A 192.0.2.10")
+	}()
+	code := runWatch(context.Background(), &buf, opts, 100*time.Millisecond, 4)
+	if code != exitPropagated {
+		t.Errorf("exit code %d, want %d", code, exitPropagated)
+	}
+
+	out := buf.String()
+	for _, want := range []string{
+		"authoritative 192.0.2.11 has A 192.0.2.10",
+		"authoritative 192.0.2.12 does not have the record",
+		"authoritative 192.0.2.12 has A 192.0.2.10",
+		"resolver local has A 192.0.2.10",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "192.0.2.11"); n != 1 {
+		t.Errorf("unchanged server reported %d times:\n%s", n, out)
+	}
+}
+
+// recordCapture records a check of www that propagates, then takes the
+// record away from every server, so only a replay of the capture propagates.
+// The hierarchy is the transport again when the test ends.
+func recordCapture(t *testing.T, h *dnstest.Hierarchy) string {
+	t.Helper()
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	t.Cleanup(func() { dnspkg.SetTransport(h) })
+
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := dnspkg.NewRecorder(f, h)
+	dnspkg.SetTransport(rec)
+	rec.WriteHeader(dnspkg.CaptureHeader{Domain: "www.example.test", RecordType: "a", Match: "192.0.2.10", Timeout: "2s", Retry: "50ms"})
+	_, err = dnspkg.CheckPropagation(&config, www, "a", "192.0.2.10", dnspkg.ParseRecordType("a"), 2*time.Second, 50*time.Millisecond)
+	dnspkg.SetTransport(h)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*dnstest.Server{h.NS[0], h.NS[1], h.Resolver, h.SystemResolver} {
+		s.Remove(www, dnspkg.ParseRecordType("a"))
+	}
+	return path
+}
+
+func TestRecordReplay(t *testing.T) {
+	h := useHierarchy(t)
+	first := recordCapture(t, h)
+	second := filepath.Join(t.TempDir(), "second.jsonl")
+
+	// Recording a replay copies the capture
+	for _, args := range [][]string{
+		{"check", "-q", "-replay", first, "-record", second},
+		{"check", "-q", "-replay", second},
+	} {
+		dnspkg.SetTransport(h)
+		var code int
+		out := captureStdout(t, func() { code = run(args) })
+		if code != exitPropagated {
+			t.Errorf("%q: exit code %d, output %q", args, code, out)
+		}
+	}
+}
+
+func TestPrimeRoots(t *testing.T) {
+	h := useHierarchy(t)
+	config.RootServers = []string{"192.0.2.250:53", h.Root.Addr}
+
+	// A replay keeps the hints
+	(&session{replaying: true}).primeRoots()
+	if got := config.Roots(); len(got) != 2 {
+		t.Errorf("roots primed during a replay: %q", got)
+	}
+	(&session{}).primeRoots()
+	if got := config.Roots(); len(got) != 1 || got[0] != h.Root.Addr {
+		t.Errorf("Roots() = %q, want the primed root %s", got, h.Root.Addr)
+	}
+}
@@ -0,0 +1,140 @@
+package dns
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// maxDelegations bounds the number of zone cuts kept in the cache.
+const maxDelegations = 4096
+
+// CacheStats is a snapshot of the delegation cache.
+type CacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits_total"`
+	Misses  uint64 `json:"misses_total"`
+}
+
+// delegationCache remembers the nameserver addresses of zone cuts learned
+// from referrals, so discovery can start at the closest known cut instead of
+// the root. It is shared by every check in the process.
+type delegationCache struct {
+	mu   sync.Mutex
+	cuts map[string]delegation
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type delegation struct {
+	servers []string
+	expires time.Time
+}
+
+var delegations = &delegationCache{cuts: make(map[string]delegation)}
+
+// closest returns the deepest unexpired cut at or above name and its servers,
+// or nil if discovery has to start at the root.
+func (c *delegationCache) closest(name string) (string, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for cut := strings.ToLower(mdns.Fqdn(name)); cut != "."; {
+		if d, ok := c.cuts[cut]; ok {
+			if now.Before(d.expires) {
+				c.hits.Add(1)
+				return cut, d.servers
+			}
+			delete(c.cuts, cut)
+		}
+		off, end := mdns.NextLabel(cut, 0)
+		if end {
+			break
+		}
+		cut = cut[off:]
+	}
+	c.misses.Add(1)
+	return "", nil
+}
+
+// store caches the servers of a zone cut for ttl.
+func (c *delegationCache) store(cut string, servers []string, ttl time.Duration) {
+	if ttl <= 0 || len(servers) == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.cuts) >= maxDelegations {
+		c.prune(time.Now())
+	}
+	c.cuts[strings.ToLower(cut)] = delegation{servers: servers, expires: time.Now().Add(ttl)}
+}
+
+// prune drops expired cuts, or everything if none have expired. Callers must
+// hold c.mu.
+func (c *delegationCache) prune(now time.Time) {
+	for cut, d := range c.cuts {
+		if !now.Before(d.expires) {
+			delete(c.cuts, cut)
+		}
+	}
+	if len(c.cuts) >= maxDelegations {
+		clear(c.cuts)
+	}
+}
+
+// drop forgets a single cut, e.g. after its servers stopped answering.
+func (c *delegationCache) drop(cut string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.cuts, cut)
+}
+
+// noCacheKey marks a context whose discoveries do not use the delegation cache.
+type noCacheKey struct{}
+
+// WithoutDelegationCache returns a context whose discoveries walk from the
+// root instead of the closest cached zone cut, for a check that verifies a
+// delegation change. What they learn is still cached for other checks.
+func WithoutDelegationCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// cacheBypassed reports whether ctx came from WithoutDelegationCache.
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(noCacheKey{}).(bool)
+	return bypass
+}
+
+// FlushDelegations removes the cached zone cuts for name and all of its
+// ancestors, so the next discovery for name walks from the root and sees
+// the current delegation. An empty name flushes the whole cache.
+func FlushDelegations(name string) {
+	c := delegations
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name == "" {
+		clear(c.cuts)
+		return
+	}
+	for cut := range c.cuts {
+		if mdns.IsSubDomain(cut, strings.ToLower(mdns.Fqdn(name))) {
+			delete(c.cuts, cut)
+		}
+	}
+}
+
+// DelegationCacheStats returns the current state of the delegation cache.
+func DelegationCacheStats() CacheStats {
+	c := delegations
+	c.mu.Lock()
+	n := len(c.cuts)
+	c.mu.Unlock()
+	return CacheStats{Entries: n, Hits: c.hits.Load(), Misses: c.misses.Load()}
+}
@@ -0,0 +1,97 @@
+package dns_test
+
+import (
+	"testing"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestDelegationCache(t *testing.T) {
+	h := dnstest.New(t)
+	roots := h.Config().RootServers
+
+	if _, err := dnspkg.FindAuthoritativeServers(www, roots); err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+	rootQueries, tldQueries := h.Root.Queries(), h.TLD.Queries()
+
+	servers, err := dnspkg.FindAuthoritativeServers("mail."+dnstest.Zone, roots)
+	if err != nil || len(servers) != 2 {
+		t.Fatalf("FindAuthoritativeServers: %v, %v", servers, err)
+	}
+	if h.Root.Queries() != rootQueries || h.TLD.Queries() != tldQueries {
+		t.Error("a cached delegation should skip the root and TLD")
+	}
+	if stats := dnspkg.DelegationCacheStats(); stats.Hits == 0 || stats.Entries != 2 {
+		t.Errorf("stats %+v, want hits and 2 entries", stats)
+	}
+
+	dnspkg.FlushDelegations(www)
+	if _, err := dnspkg.FindAuthoritativeServers(www, roots); err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+	if h.Root.Queries() == rootQueries {
+		t.Error("discovery after a flush should start at the root")
+	}
+}
+
+func TestDelegationCacheDeadServers(t *testing.T) {
+	h := dnstest.New(t)
+	roots := h.Config().RootServers
+	if _, err := dnspkg.FindAuthoritativeServers(www, roots); err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+
+	// A new server is added to the delegation and the cached ones stop
+	// answering.
+	moved := h.AddZone(t, h.TLD, dnstest.Zone)
+	moved.Add(www + " 300 IN A 192.0.2.10")
+	for _, ns := range h.NS {
+		ns.SetRcode(mdns.RcodeRefused)
+	}
+
+	servers, err := dnspkg.FindAuthoritativeServers(www, roots)
+	if err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+	found := false
+	for _, s := range servers {
+		found = found || s.Addr == moved.Addr
+	}
+	if !found {
+		t.Errorf("new server %s missing; the stale cached delegation was used", moved.Addr)
+	}
+}
+
+func TestWithoutDelegationCache(t *testing.T) {
+	h := dnstest.New(t)
+	roots := h.Config().RootServers
+	if _, err := dnspkg.FindAuthoritativeServers(www, roots); err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+	rootQueries := h.Root.Queries()
+	before := dnspkg.DelegationCacheStats()
+
+	ctx := dnspkg.WithoutDelegationCache(t.Context())
+	if _, err := dnspkg.DiscoverAuthoritativeContext(ctx, www, mdns.TypeA, roots); err != nil {
+		t.Fatalf("DiscoverAuthoritativeContext: %v", err)
+	}
+	if h.Root.Queries() == rootQueries {
+		t.Error("discovery without the cache should start at the root")
+	}
+
+	// Other checks keep the cache
+	if stats := dnspkg.DelegationCacheStats(); stats.Entries != before.Entries || stats.Hits != before.Hits {
+		t.Errorf("stats %+v, want %+v", stats, before)
+	}
+	rootQueries = h.Root.Queries()
+	if _, err := dnspkg.FindAuthoritativeServers(www, roots); err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+	if h.Root.Queries() != rootQueries {
+		t.Error("a check with the cache walked from the root")
+	}
+}
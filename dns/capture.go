@@ -0,0 +1,213 @@
+package dns
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// CaptureHeader describes the check a capture was recorded for. It is the
+// first line of a capture file written by the CLI.
+type CaptureHeader struct {
+	Domain         string `json:"domain"`
+	RecordType     string `json:"record_type"`
+	Match          string `json:"match"`
+	Timeout        string `json:"timeout"`
+	Retry          string `json:"retry"`
+	SystemResolver string `json:"system_resolver"`
+}
+
+// CapturedExchange is one query and its outcome as seen on the wire.
+type CapturedExchange struct {
+	Time     time.Time `json:"time"`
+	Server   string    `json:"server"`
+	Question string    `json:"question"`
+	Recurse  bool      `json:"rd,omitempty"`
+	DNSSEC   bool      `json:"do,omitempty"`
+	RTT      string    `json:"rtt"`
+	Response []byte    `json:"response,omitempty"` // wire format
+	Error    string    `json:"error,omitempty"`
+}
+
+// captureLine is a single JSON line of a capture file.
+type captureLine struct {
+	Check    *CaptureHeader    `json:"check,omitempty"`
+	Exchange *CapturedExchange `json:"exchange,omitempty"`
+}
+
+// Recorder is a Transport that writes every exchange made through the
+// wrapped transport to a capture file.
+type Recorder struct {
+	next Transport
+
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error // first write error
+}
+
+// NewRecorder returns a Recorder writing JSON lines to w.
+func NewRecorder(w io.Writer, next Transport) *Recorder {
+	return &Recorder{next: next, enc: json.NewEncoder(w)}
+}
+
+// WriteHeader records the parameters of the check being captured.
+func (r *Recorder) WriteHeader(h CaptureHeader) error {
+	if h.SystemResolver == "" {
+		h.SystemResolver = systemResolver()
+	}
+	return r.write(captureLine{Check: &h})
+}
+
+// Err returns the first error writing the capture, if any. A capture that
+// got one lacks exchanges from then on.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) write(line captureLine) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.enc.Encode(line)
+	if err != nil && r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+// Exchange implements Transport.
+func (r *Recorder) Exchange(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+	start := time.Now()
+	resp, err := r.next.Exchange(ctx, m, server)
+
+	ex := &CapturedExchange{
+		Time:     start.UTC(),
+		Server:   server,
+		Question: questionString(m),
+		Recurse:  m.RecursionDesired,
+		DNSSEC:   dnssecOK(m),
+		RTT:      time.Since(start).String(),
+	}
+	if err != nil {
+		ex.Error = err.Error()
+	} else if wire, perr := resp.Pack(); perr == nil {
+		ex.Response = wire
+	}
+
+	// A failed write shows in Err; the query itself went through
+	r.write(captureLine{Exchange: ex})
+	return resp, err
+}
+
+// Replayer is a Transport that answers queries from a capture file without
+// touching the network. Repeated queries get the recorded answers in order;
+// once they run out, the last one is repeated.
+type Replayer struct {
+	header CaptureHeader
+
+	mu      sync.Mutex
+	answers map[replayKey][]*CapturedExchange
+	used    map[replayKey]int
+}
+
+type replayKey struct {
+	server   string
+	question string
+	recurse  bool
+	dnssec   bool
+}
+
+// LoadReplay reads a capture file for replay.
+func LoadReplay(path string) (*Replayer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &Replayer{
+		answers: make(map[replayKey][]*CapturedExchange),
+		used:    make(map[replayKey]int),
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var line captureLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		if line.Check != nil {
+			r.header = *line.Check
+		}
+		if ex := line.Exchange; ex != nil {
+			k := replayKey{ex.Server, ex.Question, ex.Recurse, ex.DNSSEC}
+			r.answers[k] = append(r.answers[k], ex)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Header returns the check parameters stored in the capture, if any.
+func (r *Replayer) Header() CaptureHeader {
+	return r.header
+}
+
+// Exchange implements Transport.
+func (r *Replayer) Exchange(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+	// The system resolver of the recording host stands in for ours.
+	if server == systemResolver() && r.header.SystemResolver != "" {
+		server = r.header.SystemResolver
+	}
+	k := replayKey{server, questionString(m), m.RecursionDesired, dnssecOK(m)}
+
+	r.mu.Lock()
+	answers := r.answers[k]
+	i := r.used[k]
+	if i < len(answers)-1 {
+		r.used[k]++
+	}
+	r.mu.Unlock()
+
+	if len(answers) == 0 {
+		return nil, Errorf(ErrUnreachable, "replay: no recorded exchange with %s for %s", server, k.question)
+	}
+	ex := answers[min(i, len(answers)-1)]
+	if ex.Error != "" {
+		return nil, errors.New(ex.Error)
+	}
+
+	resp := new(mdns.Msg)
+	if err := resp.Unpack(ex.Response); err != nil {
+		return nil, fmt.Errorf("replay: %w", err)
+	}
+	resp.Id = m.Id
+	return resp, nil
+}
+
+// questionString formats the first question of m, e.g. "www.example.com. IN A".
+func questionString(m *mdns.Msg) string {
+	if len(m.Question) == 0 {
+		return ""
+	}
+	q := m.Question[0]
+	return strings.ToLower(q.Name) + " " + mdns.ClassToString[q.Qclass] + " " + mdns.TypeToString[q.Qtype]
+}
+
+// dnssecOK reports whether m asks for DNSSEC records (the EDNS0 DO bit).
+func dnssecOK(m *mdns.Msg) bool {
+	opt := m.IsEdns0()
+	return opt != nil && opt.Do()
+}
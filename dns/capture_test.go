@@ -0,0 +1,95 @@
+package dns_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Record a check where ns2 only gets the record after a while.
+	h := dnstest.New(t)
+	cfg := h.Config()
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	h.NS[1].Remove(www, mdns.TypeA)
+	time.AfterFunc(200*time.Millisecond, func() { h.NS[1].Add(www + " 300 IN A 192.0.2.10") })
+
+	rec := dnspkg.NewRecorder(f, h)
+	prev := dnspkg.SetTransport(rec)
+	rec.WriteHeader(dnspkg.CaptureHeader{Domain: www, RecordType: "a", Match: "192.0.2.10"})
+	recorded, err := dnspkg.CheckPropagation(cfg, www, "a", "192.0.2.10", mdns.TypeA, 2*time.Second, 50*time.Millisecond)
+	dnspkg.SetTransport(prev)
+	f.Close()
+	if err != nil || !recorded.AllPropagated {
+		t.Fatalf("recording: %v %+v", err, recorded)
+	}
+
+	// Replay it with every fake server down.
+	h.NS[0].SetRcode(mdns.RcodeRefused)
+	h.NS[1].SetRcode(mdns.RcodeRefused)
+	h.Root.SetRcode(mdns.RcodeRefused)
+
+	replayer, err := dnspkg.LoadReplay(path)
+	if err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+	if got := replayer.Header(); got.Domain != www || got.Match != "192.0.2.10" {
+		t.Errorf("header %+v", got)
+	}
+	dnspkg.SetTransport(replayer)
+	defer dnspkg.SetTransport(prev)
+
+	replayed, err := dnspkg.CheckPropagation(cfg, www, "a", "192.0.2.10", mdns.TypeA, 2*time.Second, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if !replayed.AllPropagated || len(replayed.Authoritative) != len(recorded.Authoritative) {
+		t.Fatalf("replayed %+v, recorded %+v", replayed, recorded)
+	}
+}
+
+func TestReplayUnknownQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.jsonl")
+	os.WriteFile(path, nil, 0644)
+
+	replayer, err := dnspkg.LoadReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := new(mdns.Msg)
+	m.SetQuestion(www, mdns.TypeA)
+	if _, err := replayer.Exchange(t.Context(), m, "192.0.2.1:53"); !errors.Is(err, dnspkg.ErrUnreachable) {
+		t.Fatalf("query that was never recorded: %v", err)
+	}
+}
+
+// failingWriter fails every write, like a capture file on a full disk.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("no space left on device") }
+
+func TestRecorderWriteError(t *testing.T) {
+	h := dnstest.New(t)
+	rec := dnspkg.NewRecorder(failingWriter{}, h)
+	m := new(mdns.Msg)
+	m.SetQuestion(www, mdns.TypeA)
+	if _, err := rec.Exchange(t.Context(), m, h.NS[0].Addr); err != nil {
+		t.Fatalf("query failed with the capture: %v", err)
+	}
+	if err := rec.Err(); err == nil || err.Error() != "no space left on device" {
+		t.Errorf("Err() = %v", err)
+	}
+}
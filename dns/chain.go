@@ -0,0 +1,192 @@
+package dns
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	mdns "github.com/miekg/dns"
+)
+
+// maxChainLength bounds the number of CNAME/DNAME hops followed for one answer.
+const maxChainLength = 8
+
+// chainFollower follows CNAME and DNAME chains from authoritative answers into
+// other zones. Servers discovered for each target are shared by every probe
+// of a check.
+type chainFollower struct {
+	rootServers []string
+
+	mu      sync.Mutex
+	servers map[string][]*ResolverStatus
+}
+
+func newChainFollower(rootServers []string) *chainFollower {
+	return &chainFollower{
+		rootServers: rootServers,
+		servers:     make(map[string][]*ResolverStatus),
+	}
+}
+
+// serversFor returns the authoritative servers for name, discovering them on
+// first use. Failed discoveries are not kept, so the next poll tries again:
+// the target zone may not be delegated or reachable yet.
+func (f *chainFollower) serversFor(ctx context.Context, name string) []*ResolverStatus {
+	f.mu.Lock()
+	servers, ok := f.servers[name]
+	f.mu.Unlock()
+	if ok {
+		return servers
+	}
+
+	servers, err := findAuthoritative(ctx, name, f.rootServers)
+	if err != nil {
+		return nil
+	}
+	f.mu.Lock()
+	f.servers[name] = servers
+	f.mu.Unlock()
+	return servers
+}
+
+// resolve queries name at server and follows any CNAME/DNAME chain through
+// the authoritative servers of each target until the record matches or the
+// chain ends.
+func (f *chainFollower) resolve(ctx context.Context, server, name string, qtype uint16, match string) ProbeResult {
+	var chain []string
+	seen := map[string]bool{}
+	servers := []string{server}
+
+	for hop := 0; ; hop++ {
+		key := strings.ToLower(name)
+		if seen[key] {
+			return ProbeResult{Chain: append(chain, "loop detected at "+name)}
+		}
+		seen[key] = true
+
+		var response *mdns.Msg
+		var from string
+		for _, addr := range servers {
+			r, err := queryDNS(ctx, addr, name, qtype, false)
+			if err == nil && usable(r) {
+				response, from = r, addr
+				break
+			}
+		}
+		if response == nil {
+			return ProbeResult{Chain: chain}
+		}
+
+		// Answers may carry several hops at once when the targets are in-zone,
+		// and the record itself at the last of them.
+		var next string
+		if qtype != mdns.TypeCNAME {
+			var links []string
+			links, next = chainLinks(response.Answer, name)
+			chain = append(chain, links...)
+		}
+		if record := MatchRecord(response.Answer, qtype, match); record != "" {
+			target := name
+			if next != "" {
+				target = next
+			}
+			return ProbeResult{
+				Record:   record,
+				Chain:    chain,
+				Wildcard: detectWildcard(ctx, from, target, qtype, false, record, match),
+			}
+		}
+		if next == "" {
+			return ProbeResult{Chain: chain}
+		}
+		if hop >= maxChainLength {
+			return ProbeResult{Chain: append(chain, "chain too long")}
+		}
+
+		name = next
+		servers = servers[:0]
+		for _, s := range f.serversFor(ctx, name) {
+			servers = append(servers, s.Addr)
+		}
+	}
+}
+
+// ResolveAlias follows the CNAME and DNAME records at name through the
+// authoritative servers of each zone on the way, and returns the name the
+// records of qtype end up at with the hops taken. A name that is not an
+// alias, or does not exist yet, is its own target.
+func ResolveAlias(ctx context.Context, name string, qtype uint16, rootServers []string) (string, []string, error) {
+	var chain []string
+	seen := map[string]bool{}
+	for range maxChainLength {
+		key := strings.ToLower(name)
+		if seen[key] {
+			return "", chain, Errorf(ErrLoop, "alias loop at %s", name)
+		}
+		seen[key] = true
+
+		servers, err := findAuthoritative(ctx, name, rootServers)
+		if err != nil {
+			return "", chain, err
+		}
+		var response *mdns.Msg
+		for _, s := range servers {
+			r, err := queryDNS(ctx, s.Addr, name, qtype, false)
+			if err == nil && usable(r) {
+				response = r
+				break
+			}
+		}
+		if response == nil {
+			return "", chain, Errorf(ErrLameDelegation, "no usable answer for %s", name)
+		}
+
+		links, next := chainLinks(response.Answer, name)
+		if next == "" {
+			return name, chain, nil
+		}
+		chain = append(chain, links...)
+		name = next
+	}
+	return "", chain, Errorf(ErrLoop, "more than %d aliases", maxChainLength)
+}
+
+// chainLinks walks the CNAME and DNAME records in an answer starting at name.
+// It returns a description of each hop and the final target, or an empty
+// target if name is not aliased.
+func chainLinks(answer []mdns.RR, name string) ([]string, string) {
+	var links []string
+	target := ""
+	for range maxChainLength {
+		next := ""
+		for _, rr := range answer {
+			switch rr := rr.(type) {
+			case *mdns.DNAME:
+				if mdns.IsSubDomain(rr.Hdr.Name, name) && !strings.EqualFold(rr.Hdr.Name, name) {
+					prefix := name[:len(name)-len(rr.Hdr.Name)]
+					next = prefix + rr.Target
+					links = append(links, "DNAME "+rr.Hdr.Name+" → "+rr.Target)
+				}
+			case *mdns.CNAME:
+				if next == "" && strings.EqualFold(rr.Hdr.Name, name) {
+					next = rr.Target
+					links = append(links, "CNAME "+rr.Target)
+				}
+			}
+			if next != "" {
+				break
+			}
+		}
+		if next == "" {
+			break
+		}
+		target, name = next, next
+	}
+	return links, target
+}
+
+// FormatChain renders a chain for display, e.g.
+// "CNAME edge.cdn.example. → CNAME a1.cdn.example.".
+func FormatChain(chain []string) string {
+	return strings.Join(chain, " → ")
+}
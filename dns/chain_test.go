@@ -0,0 +1,147 @@
+package dns_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestCheckPropagationFollowsCNAMEAcrossZones(t *testing.T) {
+	h := dnstest.New(t)
+	cdn := h.AddZone(t, h.TLD, "cdn.test.")
+	const edge = "edge.cdn.test."
+
+	for _, ns := range h.NS {
+		ns.Add(www + " 300 IN CNAME " + edge)
+	}
+	cdn.Add(edge + " 300 IN A 192.0.2.10")
+	for _, r := range []*dnstest.Server{h.Resolver, h.SystemResolver} {
+		r.Add(www+" 300 IN CNAME "+edge, edge+" 300 IN A 192.0.2.10")
+	}
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "a", "192.0.2.10", mdns.TypeA, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	for _, s := range append(resp.Authoritative, resp.Resolvers...) {
+		if len(s.Chain) != 1 || s.Chain[0] != "CNAME "+edge {
+			t.Errorf("%s: chain %q", s.Name, s.Chain)
+		}
+	}
+	if cdn.Queries() == 0 {
+		t.Error("target zone server was never queried")
+	}
+}
+
+func TestCheckPropagationFollowsDNAME(t *testing.T) {
+	h := dnstest.New(t)
+	other := h.AddZone(t, h.TLD, "other.test.")
+
+	for _, ns := range h.NS {
+		ns.Add("old." + dnstest.Zone + " 300 IN DNAME other.test.")
+	}
+	other.Add("www.other.test. 300 IN A 192.0.2.10")
+	for _, r := range []*dnstest.Server{h.Resolver, h.SystemResolver} {
+		r.Add("www.old."+dnstest.Zone+" 300 IN CNAME www.other.test.", "www.other.test. 300 IN A 192.0.2.10")
+	}
+
+	name := "www.old." + dnstest.Zone
+	resp, err := dnspkg.CheckPropagation(h.Config(), name, "a", "192.0.2.10", mdns.TypeA, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	if chain := resp.Authoritative[0].Chain; len(chain) != 1 || !strings.HasPrefix(chain[0], "DNAME ") {
+		t.Errorf("chain %q", chain)
+	}
+}
+
+func TestCheckPropagationInZoneCNAME(t *testing.T) {
+	h := dnstest.New(t)
+	target := "a." + dnstest.Zone
+	for _, ns := range h.NS {
+		ns.Add(www+" 300 IN CNAME "+target, target+" 300 IN A 192.0.2.10")
+	}
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "a", "192.0.2.10", mdns.TypeA, 300*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	for _, s := range resp.Authoritative {
+		if !s.Propagated || len(s.Chain) != 1 || s.Chain[0] != "CNAME "+target || s.Wildcard {
+			t.Errorf("%s: propagated %v, chain %q, wildcard %v", s.Name, s.Propagated, s.Chain, s.Wildcard)
+		}
+	}
+}
+
+func TestCheckPropagationCNAMELoop(t *testing.T) {
+	h := dnstest.New(t)
+	loop := "loop." + dnstest.Zone
+	for _, ns := range h.NS {
+		ns.Add(www+" 300 IN CNAME "+loop, loop+" 300 IN CNAME "+www)
+	}
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "a", "192.0.2.10", mdns.TypeA, 300*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if resp.Authoritative[0].Propagated {
+		t.Fatal("a CNAME loop must not propagate")
+	}
+	chain := resp.Authoritative[0].Chain
+	if len(chain) == 0 || !strings.HasPrefix(chain[len(chain)-1], "loop detected") {
+		t.Errorf("chain %q", chain)
+	}
+}
+
+func TestResolveAlias(t *testing.T) {
+	h := dnstest.New(t)
+	h.AddZone(t, h.TLD, "acme.test.")
+	const challenge = "_acme-challenge." + www
+	for _, ns := range h.NS {
+		ns.Add(challenge + " 300 IN CNAME abc.acme.test.")
+	}
+
+	target, chain, err := dnspkg.ResolveAlias(context.Background(), challenge, mdns.TypeTXT, h.Config().RootServers)
+	if err != nil || target != "abc.acme.test." || len(chain) != 1 {
+		t.Errorf("got %q %q %v, want abc.acme.test.", target, chain, err)
+	}
+
+	// A record that does not exist yet is its own target
+	other := "_acme-challenge.mail." + dnstest.Zone
+	if target, _, err := dnspkg.ResolveAlias(context.Background(), other, mdns.TypeTXT, h.Config().RootServers); err != nil || target != other {
+		t.Errorf("got %q %v, want %s", target, err, other)
+	}
+}
+
+func TestCNAMETargetZoneDelegatedLater(t *testing.T) {
+	h := dnstest.New(t)
+	const target = "edge.later.test."
+	for _, ns := range h.NS {
+		ns.Add(www + " 300 IN CNAME " + target)
+	}
+
+	probe := dnspkg.AuthoritativeProbe(www, mdns.TypeA, "192.0.2.10", h.Config().RootServers)
+	s := &dnspkg.ResolverStatus{Name: h.NS[0].Name, Addr: h.NS[0].Addr}
+	if r := probe(t.Context(), s); r.Record != "" {
+		t.Fatalf("matched %q before the target zone exists", r.Record)
+	}
+
+	// A failed discovery of the target zone is retried on the next poll
+	later := h.AddZone(t, h.TLD, "later.test.")
+	later.Add(target + " 300 IN A 192.0.2.10")
+	if r := probe(t.Context(), s); r.Record == "" || len(r.Chain) != 1 {
+		t.Errorf("after the delegation: %+v", r)
+	}
+}
@@ -3,6 +3,8 @@ package dns
 import (
 	"context"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
 	"strings"
@@ -10,6 +12,7 @@ import (
 	"time"
 
 	mdns "github.com/miekg/dns"
+	"golang.org/x/net/publicsuffix"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,7 +21,15 @@ type Config struct {
 	Listen          string         `yaml:"listen"`
 	PublicResolvers []string       `yaml:"public_resolvers"`
 	RootServers     []string       `yaml:"root_servers"`
+	RootHints       string         `yaml:"root_hints"` // named.root file, replaces RootServers
 	Defaults        DefaultsConfig `yaml:"defaults"`
+	Polling         PollingConfig  `yaml:"polling"`
+	Limits          LimitsConfig   `yaml:"limits"`
+	Watch           WatchConfig    `yaml:"watch"`
+	Webhooks        WebhooksConfig `yaml:"webhooks"`
+	Tracing         TracingConfig  `yaml:"tracing"`
+
+	roots []string // root servers from root_hints or priming; see Roots
 }
 
 type DefaultsConfig struct {
@@ -27,6 +38,66 @@ type DefaultsConfig struct {
 	RecordType string `yaml:"record_type"`
 }
 
+// WatchConfig is the watch list server mode checks for drift.
+type WatchConfig struct {
+	Interval string        `yaml:"interval"` // how often each record is checked
+	Misses   int           `yaml:"misses"`   // consecutive checks a server must miss the record before it drifts
+	Records  []WatchRecord `yaml:"records"`
+}
+
+// WatchRecord is a record expected to stay in place.
+type WatchRecord struct {
+	Domain    string   `yaml:"domain"`
+	Type      string   `yaml:"type"`
+	Match     string   `yaml:"match"`
+	Interval  string   `yaml:"interval,omitempty"`  // overrides the list's interval
+	Resolvers []string `yaml:"resolvers,omitempty"` // resolvers that must have it too, by name or address, or "all"
+}
+
+// WebhooksConfig is where server mode sends notifications of checks and
+// drift, and how.
+type WebhooksConfig struct {
+	Attempts int             `yaml:"attempts"` // tries per delivery
+	Backoff  string          `yaml:"backoff"`  // delay before the first retry, doubled for each next one
+	Timeout  string          `yaml:"timeout"`  // per attempt
+	Secret   string          `yaml:"secret"`   // HMAC key for targets without their own
+	Targets  []WebhookTarget `yaml:"targets"`
+
+	// Callback URLs of checks are refused unless their host is listed, as a
+	// name or "*.domain"; they are signed with CallbackSecret, never Secret.
+	// They only reach public addresses unless AllowPrivateCallbacks is set,
+	// e.g. for a CI runner on the same network.
+	CallbackHosts         []string `yaml:"callback_hosts,omitempty"`
+	CallbackSecret        string   `yaml:"callback_secret,omitempty"`
+	AllowPrivateCallbacks bool     `yaml:"allow_private_callbacks,omitempty"`
+}
+
+// WebhookTarget is a URL notified of some or all events.
+type WebhookTarget struct {
+	URL    string   `yaml:"url"`
+	Format string   `yaml:"format,omitempty"` // json (default), slack or teams
+	Events []string `yaml:"events,omitempty"` // complete, timeout, error, drift; all if empty
+	Secret string   `yaml:"secret,omitempty"`
+}
+
+// TracingConfig is where server mode exports OpenTelemetry traces of its
+// checks, over OTLP/HTTP.
+type TracingConfig struct {
+	Endpoint    string            `yaml:"endpoint"` // collector base URL, e.g. http://localhost:4318; empty disables tracing
+	ServiceName string            `yaml:"service_name"`
+	Headers     map[string]string `yaml:"headers,omitempty"` // sent with every export, e.g. for authentication
+	SampleRatio float64           `yaml:"sample_ratio"`      // of the traces ripple starts; incoming trace context decides for the others
+	Timeout     string            `yaml:"timeout"`           // per export
+}
+
+// PollingConfig controls how each server is re-polled between attempts.
+type PollingConfig struct {
+	MaxInterval string  `yaml:"max_interval"`
+	Backoff     float64 `yaml:"backoff"`
+	Jitter      float64 `yaml:"jitter"`
+	HonorTTL    *bool   `yaml:"honor_ttl,omitempty"`
+}
+
 // DefaultConfig provides sensible defaults for all configuration options.
 var DefaultConfig = Config{
 	PublicResolvers: []string{
@@ -37,17 +108,51 @@ var DefaultConfig = Config{
 		"86.54.11.100:53",   // DNS4EU
 		"76.76.2.0:53",      // ControlD
 	},
+	// Hints for the root priming query; see PrimeRootServers.
 	RootServers: []string{
-		"198.41.0.4:53",   // a.root-servers.net
-		"199.9.14.201:53", // b.root-servers.net
-		"192.33.4.12:53",  // c.root-servers.net
-		"199.7.91.13:53",  // d.root-servers.net
+		"198.41.0.4:53", "[2001:503:ba3e::2:30]:53", // a.root-servers.net
+		"170.247.170.2:53", "[2801:1b8:10::b]:53", // b.root-servers.net
+		"192.33.4.12:53", "[2001:500:2::c]:53", // c.root-servers.net
+		"199.7.91.13:53", "[2001:500:2d::d]:53", // d.root-servers.net
+		"192.203.230.10:53", "[2001:500:a8::e]:53", // e.root-servers.net
+		"192.5.5.241:53", "[2001:500:2f::f]:53", // f.root-servers.net
+		"192.112.36.4:53", "[2001:500:12::d0d]:53", // g.root-servers.net
+		"198.97.190.53:53", "[2001:500:1::53]:53", // h.root-servers.net
+		"192.36.148.17:53", "[2001:7fe::53]:53", // i.root-servers.net
+		"192.58.128.30:53", "[2001:503:c27::2:30]:53", // j.root-servers.net
+		"193.0.14.129:53", "[2001:7fd::1]:53", // k.root-servers.net
+		"199.7.83.42:53", "[2001:500:9f::42]:53", // l.root-servers.net
+		"202.12.27.33:53", "[2001:dc3::35]:53", // m.root-servers.net
 	},
 	Defaults: DefaultsConfig{
 		Timeout:    "1m",
 		Retry:      "5s",
 		RecordType: "a",
 	},
+	Polling: PollingConfig{
+		MaxInterval: "30s",
+		Backoff:     1,
+		Jitter:      0.1,
+	},
+	Limits: LimitsConfig{
+		QueriesPerSecond: 20,
+		Burst:            20,
+		MaxInFlight:      256,
+	},
+	Watch: WatchConfig{
+		Interval: "5m",
+		Misses:   2,
+	},
+	Webhooks: WebhooksConfig{
+		Attempts: 4,
+		Backoff:  "1s",
+		Timeout:  "10s",
+	},
+	Tracing: TracingConfig{
+		ServiceName: "ripple",
+		SampleRatio: 1,
+		Timeout:     "10s",
+	},
 }
 
 // ResolverStatus tracks the propagation state of a single DNS server.
@@ -57,20 +162,27 @@ type ResolverStatus struct {
 	Propagated bool
 	FoundAt    time.Duration
 	Record     string
+	QName      string   // name queried at this server when it differs from the checked one
+	Chain      []string // CNAME/DNAME hops seen in the last answer
+	Wildcard   bool     // the match was synthesized from a wildcard
 }
 
 // ServerStatus is the JSON response type for the HTTP API.
 type ServerStatus struct {
-	Name       string `json:"name"`
-	Address    string `json:"address"`
-	Propagated bool   `json:"propagated"`
-	FoundAfter string `json:"found_after,omitempty"`
-	Record     string `json:"record,omitempty"`
+	Name       string   `json:"name"`
+	Address    string   `json:"address"`
+	Propagated bool     `json:"propagated"`
+	FoundAfter string   `json:"found_after,omitempty"`
+	Record     string   `json:"record,omitempty"`
+	Query      string   `json:"query,omitempty"`
+	Chain      []string `json:"chain,omitempty"`
+	Wildcard   bool     `json:"wildcard,omitempty"` // matched via wildcard
 }
 
 // CheckResponse is the JSON response for a completed DNS propagation check.
 type CheckResponse struct {
 	Domain        string         `json:"domain"`
+	DomainUnicode string         `json:"domain_unicode,omitempty"` // set for internationalized names
 	RecordType    string         `json:"record_type"`
 	Match         string         `json:"match"`
 	Authoritative []ServerStatus `json:"authoritative"`
@@ -82,6 +194,7 @@ type CheckResponse struct {
 // ErrorResponse is the JSON error response for the HTTP API.
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"` // see ErrorCode
 }
 
 // SaveConfig writes the config to a YAML file.
@@ -100,7 +213,12 @@ func LoadConfig(cfg *Config, path string) error {
 		return err
 	}
 
-	var fileConfig Config
+	// Limits, jitter and the trace sample ratio are decoded on top of the
+	// current ones, since 0 is a setting of its own there: unlimited, no
+	// jitter, or no traces sampled.
+	fileConfig := Config{Limits: cfg.Limits}
+	fileConfig.Polling.Jitter = cfg.Polling.Jitter
+	fileConfig.Tracing.SampleRatio = cfg.Tracing.SampleRatio
 	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
 		return err
 	}
@@ -115,6 +233,14 @@ func LoadConfig(cfg *Config, path string) error {
 	if len(fileConfig.RootServers) > 0 {
 		cfg.RootServers = fileConfig.RootServers
 	}
+	if fileConfig.RootHints != "" {
+		cfg.RootHints = fileConfig.RootHints
+		roots, err := LoadRootHints(cfg.RootHints)
+		if err != nil {
+			return err
+		}
+		cfg.roots = roots
+	}
 	if fileConfig.Defaults.Timeout != "" {
 		cfg.Defaults.Timeout = fileConfig.Defaults.Timeout
 	}
@@ -124,6 +250,63 @@ func LoadConfig(cfg *Config, path string) error {
 	if fileConfig.Defaults.RecordType != "" {
 		cfg.Defaults.RecordType = fileConfig.Defaults.RecordType
 	}
+	if fileConfig.Polling.MaxInterval != "" {
+		cfg.Polling.MaxInterval = fileConfig.Polling.MaxInterval
+	}
+	if fileConfig.Polling.Backoff != 0 {
+		cfg.Polling.Backoff = fileConfig.Polling.Backoff
+	}
+	cfg.Polling.Jitter = fileConfig.Polling.Jitter
+	if fileConfig.Polling.HonorTTL != nil {
+		cfg.Polling.HonorTTL = fileConfig.Polling.HonorTTL
+	}
+	cfg.Limits = fileConfig.Limits
+	if fileConfig.Watch.Interval != "" {
+		cfg.Watch.Interval = fileConfig.Watch.Interval
+	}
+	if fileConfig.Watch.Misses != 0 {
+		cfg.Watch.Misses = fileConfig.Watch.Misses
+	}
+	if len(fileConfig.Watch.Records) > 0 {
+		cfg.Watch.Records = fileConfig.Watch.Records
+	}
+	if fileConfig.Webhooks.Attempts != 0 {
+		cfg.Webhooks.Attempts = fileConfig.Webhooks.Attempts
+	}
+	if fileConfig.Webhooks.Backoff != "" {
+		cfg.Webhooks.Backoff = fileConfig.Webhooks.Backoff
+	}
+	if fileConfig.Webhooks.Timeout != "" {
+		cfg.Webhooks.Timeout = fileConfig.Webhooks.Timeout
+	}
+	if fileConfig.Webhooks.Secret != "" {
+		cfg.Webhooks.Secret = fileConfig.Webhooks.Secret
+	}
+	if len(fileConfig.Webhooks.Targets) > 0 {
+		cfg.Webhooks.Targets = fileConfig.Webhooks.Targets
+	}
+	if len(fileConfig.Webhooks.CallbackHosts) > 0 {
+		cfg.Webhooks.CallbackHosts = fileConfig.Webhooks.CallbackHosts
+	}
+	if fileConfig.Webhooks.CallbackSecret != "" {
+		cfg.Webhooks.CallbackSecret = fileConfig.Webhooks.CallbackSecret
+	}
+	if fileConfig.Webhooks.AllowPrivateCallbacks {
+		cfg.Webhooks.AllowPrivateCallbacks = true
+	}
+	if fileConfig.Tracing.Endpoint != "" {
+		cfg.Tracing.Endpoint = fileConfig.Tracing.Endpoint
+	}
+	if fileConfig.Tracing.ServiceName != "" {
+		cfg.Tracing.ServiceName = fileConfig.Tracing.ServiceName
+	}
+	if len(fileConfig.Tracing.Headers) > 0 {
+		cfg.Tracing.Headers = fileConfig.Tracing.Headers
+	}
+	cfg.Tracing.SampleRatio = fileConfig.Tracing.SampleRatio
+	if fileConfig.Tracing.Timeout != "" {
+		cfg.Tracing.Timeout = fileConfig.Tracing.Timeout
+	}
 
 	return nil
 }
@@ -143,87 +326,165 @@ func ParseRecordType(t string) uint16 {
 		return mdns.TypeMX
 	case "ns":
 		return mdns.TypeNS
+	case "ptr":
+		return mdns.TypePTR
 	default:
 		return 0
 	}
 }
 
 // FindAuthoritativeServers traverses the DNS tree to find all authoritative nameservers for a domain.
+// The walk starts at the closest zone cut in the delegation cache, if any,
+// and every referral on the way is cached for its NS and glue TTL.
 func FindAuthoritativeServers(domain string, rootServers []string) ([]*ResolverStatus, error) {
-	nsServers := rootServers
+	return findAuthoritative(context.Background(), domain, rootServers)
+}
+
+// findAuthoritative walks the delegations from the root, or from the closest
+// cached zone cut unless ctx carries a trace, which records every step. Each
+// zone cut queried gets a span.
+func findAuthoritative(ctx context.Context, domain string, rootServers []string) (_ []*ResolverStatus, err error) {
+	tr := tracerFrom(ctx)
+	nsServers := orderByRTT(rootServers)
+	zone := "."
+	cut, cached := "", []string(nil)
+	if tr == nil && !cacheBypassed(ctx) {
+		cut, cached = delegations.closest(domain)
+	}
+	if cached != nil {
+		nsServers = orderByRTT(cached)
+		zone = cut
+	}
 	maxDepth := 10
+	endStep := func(error) {}
+	defer func() { endStep(err) }()
 
 	for depth := 0; depth < maxDepth; depth++ {
 		var response *mdns.Msg
 		var err error
+		answered := false
+		tr.step(zone)
+		endStep(nil)
+		var stepCtx context.Context
+		stepCtx, endStep = startSpan(ctx, "zone_cut", map[string]string{"dns.zone": zone, "dns.question.name": domain})
 
 		for _, ns := range nsServers {
-			response, err = QueryDNS(ns, domain, mdns.TypeA)
-			if err == nil && response != nil {
+			response, err = queryDNS(stepCtx, ns, domain, mdns.TypeA, false)
+			if err == nil && usable(response) {
 				break
 			}
+			answered = answered || err == nil
+			response = nil
 		}
 
+		if response == nil && cached != nil && depth == 0 {
+			// The cached servers are gone; start over from the root.
+			delegations.drop(cut)
+			cached = nil
+			nsServers = orderByRTT(rootServers)
+			zone = "."
+			depth--
+			continue
+		}
+		if response == nil && answered {
+			return nil, Errorf(ErrLameDelegation, "no usable answer from the nameservers for %s", zone)
+		}
 		if response == nil {
-			return nil, fmt.Errorf("no response from nameservers at depth %d", depth)
+			return nil, Errorf(ErrUnreachable, "no response from nameservers at depth %d", depth)
+		}
+
+		if response.Rcode == mdns.RcodeNameError && nxdomainAtParent(response, domain) {
+			return nil, Errorf(ErrNXDomain, "%s does not exist according to the %s zone", strings.TrimSuffix(domain, "."), zone)
 		}
 
 		// Check if we got an authoritative answer - we found the authoritative servers
 		if response.Authoritative {
 			// Query for NS records to get all authoritative nameservers
-			return getAuthoritativeNS(domain, nsServers)
+			return getAuthoritativeNS(stepCtx, domain, nsServers)
 		}
 
 		// Look for NS records in the authority section (referral)
 		var newNS []string
 		var nsNames []string
+		var referral string
+		ttl := uint32(math.MaxUint32)
 
 		for _, rr := range response.Ns {
 			if ns, ok := rr.(*mdns.NS); ok {
 				nsNames = append(nsNames, ns.Ns)
+				referral = ns.Hdr.Name
+				ttl = min(ttl, ns.Hdr.Ttl)
 			}
 		}
 
 		// Try to find glue records (A records in additional section)
-		glue := make(map[string]string)
+		glue := make(map[string]*mdns.A)
 		for _, rr := range response.Extra {
 			if a, ok := rr.(*mdns.A); ok {
-				glue[a.Hdr.Name] = a.A.String()
+				glue[a.Hdr.Name] = a
 			}
 		}
 
 		// Build list of nameserver IPs
+		var addrs []string
 		for _, nsName := range nsNames {
-			if ip, ok := glue[nsName]; ok {
-				newNS = append(newNS, ip+":53")
+			if a, ok := glue[nsName]; ok {
+				newNS = append(newNS, a.A.String()+":53")
+				addrs = append(addrs, nsName+" "+a.A.String())
+				ttl = min(ttl, a.Hdr.Ttl)
 			} else {
-				ips, err := net.LookupIP(strings.TrimSuffix(nsName, "."))
-				if err == nil && len(ips) > 0 {
-					newNS = append(newNS, ips[0].String()+":53")
+				if ip, ok := lookupHost(stepCtx, nsName); ok {
+					newNS = append(newNS, ip+":53")
+					addrs = append(addrs, nsName+" "+ip+" (no glue)")
 				}
 			}
 		}
+		tr.referral(referral, nsNames, addrs, ttl)
 
 		if len(newNS) == 0 {
-			return nil, fmt.Errorf("no more referrals at depth %d", depth)
+			return nil, Errorf(ErrLameDelegation, "no more referrals at depth %d", depth)
+		}
+		if !mdns.IsSubDomain(zone, referral) || strings.EqualFold(zone, referral) {
+			return nil, Errorf(ErrLoop, "%s refers back to %s", zone, referral)
 		}
 
-		nsServers = newNS
+		delegations.store(referral, newNS, time.Duration(ttl)*time.Second)
+		nsServers = orderByRTT(newNS)
+		zone = referral
 	}
 
-	return nil, fmt.Errorf("max depth exceeded")
+	return nil, Errorf(ErrLoop, "max depth exceeded")
+}
+
+// nxdomainAtParent reports whether an NXDOMAIN answer for domain comes from
+// above its own zone, i.e. the domain is not registered, rather than from the
+// zone itself for a name that has not been created yet. Answers from a public
+// suffix such as com. or co.uk., or from above it, or without authority, are
+// taken as the parent.
+func nxdomainAtParent(m *mdns.Msg, domain string) bool {
+	if !m.Authoritative {
+		return true
+	}
+	suffix, _ := publicsuffix.PublicSuffix(strings.ToLower(strings.TrimSuffix(domain, ".")))
+	for _, rr := range m.Ns {
+		if soa, ok := rr.(*mdns.SOA); ok {
+			return mdns.CountLabel(soa.Hdr.Name) <= mdns.CountLabel(mdns.Fqdn(suffix))
+		}
+	}
+	return false
 }
 
 // getAuthoritativeNS queries for NS records and resolves them to IPs.
-func getAuthoritativeNS(domain string, currentNS []string) ([]*ResolverStatus, error) {
+func getAuthoritativeNS(ctx context.Context, domain string, currentNS []string) ([]*ResolverStatus, error) {
 	var response *mdns.Msg
 	var err error
 
 	for _, ns := range currentNS {
-		response, err = QueryDNS(ns, domain, mdns.TypeNS)
-		if err == nil && response != nil {
+		response, err = queryDNS(ctx, ns, domain, mdns.TypeNS, false)
+		if err == nil && usable(response) {
 			break
 		}
+		response = nil
 	}
 
 	if response == nil {
@@ -270,11 +531,10 @@ func getAuthoritativeNS(domain string, currentNS []string) ([]*ResolverStatus, e
 		if glueIP, ok := glue[nsName]; ok {
 			ip = glueIP
 		} else {
-			ips, err := net.LookupIP(strings.TrimSuffix(nsName, "."))
-			if err != nil || len(ips) == 0 {
+			var ok bool
+			if ip, ok = lookupHost(ctx, nsName); !ok {
 				continue
 			}
-			ip = ips[0].String()
 		}
 
 		result = append(result, &ResolverStatus{
@@ -296,21 +556,117 @@ func getAuthoritativeNS(domain string, currentNS []string) ([]*ResolverStatus, e
 	return result, nil
 }
 
+// usable reports whether a response can be trusted for delegation data;
+// SERVFAIL and REFUSED answers mean the next server should be tried.
+func usable(r *mdns.Msg) bool {
+	return r != nil && (r.Rcode == mdns.RcodeSuccess || r.Rcode == mdns.RcodeNameError)
+}
+
 // QueryDNS sends a DNS query to a specific server.
 func QueryDNS(server, domain string, qtype uint16) (*mdns.Msg, error) {
-	c := new(mdns.Client)
-	c.Timeout = 5 * time.Second
+	return queryDNS(context.Background(), server, domain, qtype, false)
+}
 
+// queryDNS sends a query with the given recursion-desired bit through the
+// current transport. Queries are subject to the process-wide limits set with
+// SetLimits.
+func queryDNS(ctx context.Context, server, domain string, qtype uint16, recurse bool) (*mdns.Msg, error) {
 	m := new(mdns.Msg)
 	m.SetQuestion(domain, qtype)
-	m.RecursionDesired = false
+	m.RecursionDesired = recurse
+	return exchange(ctx, server, m)
+}
 
-	r, _, err := c.Exchange(m, server)
+// exchange sends a prepared query to server within the query limits.
+func exchange(ctx context.Context, server string, m *mdns.Msg) (*mdns.Msg, error) {
+	release, err := acquireQuery(ctx, server)
 	if err != nil {
 		return nil, err
 	}
+	defer release()
 
-	return r, nil
+	var protocol string
+	start := time.Now()
+	r, err := currentTransport().Exchange(context.WithValue(ctx, protocolKey{}, &protocol), m, server)
+	rtt := time.Since(start)
+	if ctx.Err() == nil {
+		serverRTT.observe(server, rtt, err != nil)
+	}
+	tracerFrom(ctx).exchange(server, m, r, err, rtt)
+	observeQuery(ctx, server, m, r, err, rtt, protocol)
+	return r, err
+}
+
+// answerTTL returns how long a response may be cached: the lowest answer TTL,
+// or the negative caching TTL from the SOA for NXDOMAIN/NODATA.
+func answerTTL(m *mdns.Msg) time.Duration {
+	var ttl uint32
+	found := false
+	for _, rr := range m.Answer {
+		if !found || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+			found = true
+		}
+	}
+	if !found {
+		for _, rr := range m.Ns {
+			if soa, ok := rr.(*mdns.SOA); ok {
+				ttl = min(soa.Hdr.Ttl, soa.Minttl)
+				found = true
+				break
+			}
+		}
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// lookupHost resolves a glueless nameserver name to an IPv4 address with the
+// host's resolver, so /etc/hosts and the like apply. When a transport other
+// than the network is set, to record, replay or fake the queries, it always
+// asks the system resolver through that transport instead, so a replay never
+// reaches the network.
+func lookupHost(ctx context.Context, name string) (string, bool) {
+	ctx, end := startSpan(ctx, "ns_lookup", map[string]string{"dns.ns": name})
+	if _, direct := currentTransport().(NetTransport); direct {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", strings.TrimSuffix(name, "."))
+		if err != nil {
+			end(fmt.Errorf("no address for %s: %v", name, err))
+			return "", false
+		}
+		end(nil)
+		return ips[0].String(), true
+	}
+	response, err := queryDNS(ctx, systemResolver(), mdns.Fqdn(name), mdns.TypeA, true)
+	if err != nil || response == nil {
+		end(fmt.Errorf("no address for %s: %v", name, err))
+		return "", false
+	}
+	for _, rr := range response.Answer {
+		if a, ok := rr.(*mdns.A); ok {
+			end(nil)
+			return a.A.String(), true
+		}
+	}
+	end(fmt.Errorf("no address for %s", name))
+	return "", false
+}
+
+var (
+	systemResolverOnce sync.Once
+	systemResolverAddr string
+)
+
+// systemResolver returns the first nameserver from /etc/resolv.conf, or an
+// empty string if it cannot be read.
+func systemResolver() string {
+	systemResolverOnce.Do(func() {
+		cc, err := mdns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil || len(cc.Servers) == 0 {
+			return
+		}
+		systemResolverAddr = net.JoinHostPort(cc.Servers[0], cc.Port)
+	})
+	return systemResolverAddr
 }
 
 // QueryAuthoritativeRecord checks a single authoritative server for a matching record.
@@ -358,12 +714,25 @@ func MatchRecord(answers []mdns.RR, qtype uint16, match string) string {
 					return fmt.Sprintf("MX %s (pref %d)", mx.Mx, mx.Preference)
 				}
 			}
+		case mdns.TypeNS:
+			if ns, ok := rr.(*mdns.NS); ok {
+				if strings.Contains(ns.Ns, match) {
+					return fmt.Sprintf("NS %s", ns.Ns)
+				}
+			}
+		case mdns.TypePTR:
+			if ptr, ok := rr.(*mdns.PTR); ok {
+				if strings.EqualFold(ptr.Ptr, mdns.Fqdn(match)) {
+					return fmt.Sprintf("PTR %s", ptr.Ptr)
+				}
+			}
 		}
 	}
 	return ""
 }
 
-// CheckResolver checks a single resolver for a matching record.
+// CheckResolver checks a single resolver for a matching record. Records are
+// formatted as by MatchRecord.
 func CheckResolver(addr, domain, recordType, match string) (string, bool) {
 	var resolver *net.Resolver
 	if addr == "" {
@@ -384,12 +753,18 @@ func CheckResolver(addr, domain, recordType, match string) (string, bool) {
 	switch strings.ToLower(recordType) {
 	case "a":
 		return checkA(ctx, resolver, domain, match)
+	case "aaaa":
+		return checkAAAA(ctx, resolver, domain, match)
 	case "txt":
 		return checkTXT(ctx, resolver, domain, match)
 	case "cname":
 		return checkCNAME(ctx, resolver, domain, match)
 	case "mx":
 		return checkMX(ctx, resolver, domain, match)
+	case "ns":
+		return checkNS(ctx, resolver, domain, match)
+	case "ptr":
+		return checkPTR(ctx, resolver, domain, match)
 	default:
 		return "", false
 	}
@@ -408,6 +783,19 @@ func checkA(ctx context.Context, resolver *net.Resolver, domain, match string) (
 	return "", false
 }
 
+func checkAAAA(ctx context.Context, resolver *net.Resolver, domain, match string) (string, bool) {
+	ips, err := resolver.LookupIP(ctx, "ip6", domain)
+	if err != nil {
+		return "", false
+	}
+	for _, ip := range ips {
+		if ip.String() == match {
+			return fmt.Sprintf("AAAA %s", ip.String()), true
+		}
+	}
+	return "", false
+}
+
 func checkTXT(ctx context.Context, resolver *net.Resolver, domain, match string) (string, bool) {
 	records, err := resolver.LookupTXT(ctx, domain)
 	if err != nil {
@@ -445,6 +833,41 @@ func checkMX(ctx context.Context, resolver *net.Resolver, domain, match string)
 	return "", false
 }
 
+func checkNS(ctx context.Context, resolver *net.Resolver, domain, match string) (string, bool) {
+	nss, err := resolver.LookupNS(ctx, domain)
+	if err != nil {
+		return "", false
+	}
+	for _, ns := range nss {
+		if strings.Contains(ns.Host, match) {
+			return fmt.Sprintf("NS %s", ns.Host), true
+		}
+	}
+	return "", false
+}
+
+// checkPTR looks up the address of a reverse name, or domain itself if it is
+// an address. Classless (RFC 2317) names have no address to look up.
+func checkPTR(ctx context.Context, resolver *net.Resolver, domain, match string) (string, bool) {
+	addr := domain
+	if net.ParseIP(addr) == nil {
+		addr = reverseAddr(domain)
+	}
+	if addr == "" {
+		return "", false
+	}
+	names, err := resolver.LookupAddr(ctx, addr)
+	if err != nil {
+		return "", false
+	}
+	for _, name := range names {
+		if strings.EqualFold(mdns.Fqdn(name), mdns.Fqdn(match)) {
+			return fmt.Sprintf("PTR %s", mdns.Fqdn(name)), true
+		}
+	}
+	return "", false
+}
+
 // FormatDuration formats a duration for display.
 func FormatDuration(d time.Duration) string {
 	if d < time.Minute {
@@ -460,254 +883,91 @@ func FormatDuration(d time.Duration) string {
 
 // CheckPropagation runs a full DNS propagation check and returns the result.
 func CheckPropagation(cfg *Config, domain, recordType, match string, dnsType uint16, timeout, retry time.Duration) (*CheckResponse, error) {
+	return CheckPropagationContext(context.Background(), cfg, domain, recordType, match, dnsType, timeout, retry)
+}
+
+// CheckPropagationContext is CheckPropagation with a context, which ends the
+// check early when done and carries the spans of its queries.
+func CheckPropagationContext(ctx context.Context, cfg *Config, domain, recordType, match string, dnsType uint16, timeout, retry time.Duration) (*CheckResponse, error) {
 	// Find authoritative nameservers
-	authServers, err := FindAuthoritativeServers(domain, cfg.RootServers)
+	authServers, err := DiscoverAuthoritativeContext(ctx, domain, dnsType, cfg.Roots())
 	if err != nil {
 		return nil, fmt.Errorf("failed to find authoritative servers: %w", err)
 	}
 
-	// Build public resolver list
-	resolvers := make([]*ResolverStatus, 0, len(cfg.PublicResolvers)+1)
-	for _, addr := range cfg.PublicResolvers {
-		resolvers = append(resolvers, &ResolverStatus{
-			Name: strings.Split(addr, ":")[0],
-			Addr: addr,
-		})
-	}
-	resolvers = append(resolvers, &ResolverStatus{
-		Name: "local",
-		Addr: "",
-	})
+	resolvers := BuildResolvers(cfg.PublicResolvers)
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	var mu sync.Mutex
-	startTime := time.Now()
-
-	// Check with retries until timeout
-	ticker := time.NewTicker(retry)
-	defer ticker.Stop()
-
-	for {
-		// Check authoritative servers
-		CheckAuthoritativeAllSilent(authServers, domain, dnsType, match, startTime, &mu)
+	Watch(ctx, authServers, resolvers,
+		AuthoritativeProbe(domain, dnsType, match, cfg.Roots()), ResolverProbe(domain, dnsType, match),
+		cfg.Schedule(retry), time.Now(), &mu, nil)
 
-		// Check resolvers
-		CheckResolverAllSilent(resolvers, domain, recordType, match, startTime, &mu)
-
-		// Check if all propagated
-		allDone := true
-		mu.Lock()
-		for _, s := range authServers {
-			if !s.Propagated {
-				allDone = false
-				break
-			}
-		}
-		if allDone {
-			for _, r := range resolvers {
-				if !r.Propagated {
-					allDone = false
-					break
-				}
-			}
-		}
-		mu.Unlock()
-
-		if allDone {
-			break
-		}
-
-		select {
-		case <-ctx.Done():
-			// Timeout - return current state
-			goto buildResponse
-		case <-ticker.C:
-			continue
-		}
-	}
+	mu.Lock()
+	defer mu.Unlock()
+	return NewCheckResponse(domain, recordType, match, authServers, resolvers), nil
+}
 
-buildResponse:
-	// Build response
+// NewCheckResponse builds the API representation of a check from the state
+// of its servers. Callers must hold the lock guarding the statuses.
+func NewCheckResponse(domain, recordType, match string, authServers, resolvers []*ResolverStatus) *CheckResponse {
 	response := &CheckResponse{
 		Domain:        strings.TrimSuffix(domain, "."),
+		DomainUnicode: UnicodeName(domain),
 		RecordType:    strings.ToUpper(recordType),
 		Match:         match,
 		Authoritative: make([]ServerStatus, 0, len(authServers)),
 		Resolvers:     make([]ServerStatus, 0, len(resolvers)),
-		AllPropagated: true,
 		CheckedAt:     time.Now().UTC().Format(time.RFC3339),
 	}
-
-	mu.Lock()
 	for _, s := range authServers {
-		status := ServerStatus{
-			Name:       s.Name,
-			Address:    strings.TrimSuffix(s.Addr, ":53"),
-			Propagated: s.Propagated,
-		}
-		if s.Propagated {
-			status.FoundAfter = FormatDuration(s.FoundAt)
-			status.Record = s.Record
-		} else {
-			response.AllPropagated = false
-		}
-		response.Authoritative = append(response.Authoritative, status)
+		response.Authoritative = append(response.Authoritative, NewServerStatus(s))
 	}
-
 	for _, r := range resolvers {
-		status := ServerStatus{
-			Name:       r.Name,
-			Address:    r.Addr,
-			Propagated: r.Propagated,
-		}
-		if r.Addr == "" {
-			status.Address = "system"
-		} else {
-			status.Address = strings.TrimSuffix(r.Addr, ":53")
-		}
-		if r.Propagated {
-			status.FoundAfter = FormatDuration(r.FoundAt)
-			status.Record = r.Record
-		} else {
-			response.AllPropagated = false
-		}
-		response.Resolvers = append(response.Resolvers, status)
+		response.Resolvers = append(response.Resolvers, NewServerStatus(r))
 	}
-	mu.Unlock()
-
-	return response, nil
+	response.AllPropagated = AllPropagated(authServers) && AllPropagated(resolvers)
+	return response
 }
 
-// CheckAuthoritativeAllSilent checks all authoritative servers without printing output.
-func CheckAuthoritativeAllSilent(servers []*ResolverStatus, domain string, qtype uint16, match string, startTime time.Time, mu *sync.Mutex) {
-	var wg sync.WaitGroup
-	for _, s := range servers {
-		mu.Lock()
-		if s.Propagated {
-			mu.Unlock()
-			continue
-		}
-		mu.Unlock()
-
-		wg.Add(1)
-		go func(s *ResolverStatus) {
-			defer wg.Done()
-			record := QueryAuthoritativeRecord(s.Addr, domain, qtype, match)
-			if record != "" {
-				mu.Lock()
-				if !s.Propagated {
-					s.Propagated = true
-					s.FoundAt = time.Since(startTime)
-					s.Record = record
-				}
-				mu.Unlock()
-			}
-		}(s)
-	}
-	wg.Wait()
+// NewServerStatus converts a server's propagation state to its API
+// representation.
+func NewServerStatus(s *ResolverStatus) ServerStatus {
+	status := ServerStatus{
+		Name:       s.Name,
+		Address:    strings.TrimSuffix(s.Addr, ":53"),
+		Propagated: s.Propagated,
+		Query:      strings.TrimSuffix(s.QName, "."),
+		Chain:      s.Chain,
+		Wildcard:   s.Wildcard,
+	}
+	if s.Addr == "" {
+		status.Address = "system"
+	}
+	if s.Propagated {
+		status.FoundAfter = FormatDuration(s.FoundAt)
+		status.Record = s.Record
+	}
+	return status
 }
 
-// CheckResolverAllSilent checks all resolvers without printing output.
-func CheckResolverAllSilent(resolvers []*ResolverStatus, domain, recordType, match string, startTime time.Time, mu *sync.Mutex) {
-	var wg sync.WaitGroup
-	for _, r := range resolvers {
-		mu.Lock()
-		if r.Propagated {
-			mu.Unlock()
-			continue
-		}
-		mu.Unlock()
-
-		wg.Add(1)
-		go func(r *ResolverStatus) {
-			defer wg.Done()
-			record, found := CheckResolver(r.Addr, strings.TrimSuffix(domain, "."), recordType, match)
-			if found {
-				mu.Lock()
-				if !r.Propagated {
-					r.Propagated = true
-					r.FoundAt = time.Since(startTime)
-					r.Record = record
-				}
-				mu.Unlock()
-			}
-		}(r)
-	}
-	wg.Wait()
-}
-
-// CheckAuthoritativeAllVerbose checks authoritative servers with printed output (for CLI mode).
-func CheckAuthoritativeAllVerbose(servers []*ResolverStatus, domain string, qtype uint16, match, recordType string, startTime time.Time, mu *sync.Mutex) {
-	var wg sync.WaitGroup
+// PrintSummary writes a summary of server propagation status to w.
+func PrintSummary(w io.Writer, servers []*ResolverStatus, serverType string) {
+	fmt.Fprintf(w, "\nSummary (%s):\n", serverType)
 	for _, s := range servers {
-		mu.Lock()
-		if s.Propagated {
-			mu.Unlock()
-			continue
+		via := ""
+		if len(s.Chain) > 0 {
+			via = " via " + FormatChain(s.Chain)
 		}
-		mu.Unlock()
-
-		wg.Add(1)
-		go func(s *ResolverStatus) {
-			defer wg.Done()
-			record := QueryAuthoritativeRecord(s.Addr, domain, qtype, match)
-			if record != "" {
-				mu.Lock()
-				if !s.Propagated {
-					s.Propagated = true
-					s.FoundAt = time.Since(startTime)
-					s.Record = record
-					fmt.Printf(" - %s authoritative %s has record %s (%s)\n",
-						FormatDuration(s.FoundAt), s.Name, strings.ToUpper(recordType), record)
-				}
-				mu.Unlock()
-			}
-		}(s)
-	}
-	wg.Wait()
-}
-
-// CheckResolverAllVerbose checks resolvers with printed output (for CLI mode).
-func CheckResolverAllVerbose(resolvers []*ResolverStatus, domain, recordType, match string, startTime time.Time, mu *sync.Mutex) {
-	var wg sync.WaitGroup
-	for _, r := range resolvers {
-		mu.Lock()
-		if r.Propagated {
-			mu.Unlock()
-			continue
+		if s.Wildcard {
+			via += " [" + WildcardNote + "]"
 		}
-		mu.Unlock()
-
-		wg.Add(1)
-		go func(r *ResolverStatus) {
-			defer wg.Done()
-			record, found := CheckResolver(r.Addr, strings.TrimSuffix(domain, "."), recordType, match)
-			if found {
-				mu.Lock()
-				if !r.Propagated {
-					r.Propagated = true
-					r.FoundAt = time.Since(startTime)
-					r.Record = record
-					fmt.Printf(" - %s resolver %s propagated record %s (%s)\n",
-						FormatDuration(r.FoundAt), r.Name, strings.ToUpper(recordType), record)
-				}
-				mu.Unlock()
-			}
-		}(r)
-	}
-	wg.Wait()
-}
-
-// PrintSummary prints a summary of server propagation status.
-func PrintSummary(servers []*ResolverStatus, serverType string) {
-	fmt.Printf("\nSummary (%s):\n", serverType)
-	for _, s := range servers {
 		if s.Propagated {
-			fmt.Printf(" - %s: propagated at %s (%s)\n", s.Name, FormatDuration(s.FoundAt), s.Record)
+			fmt.Fprintf(w, " - %s: propagated at %s (%s)%s\n", s.Name, FormatDuration(s.FoundAt), s.Record, via)
 		} else {
-			fmt.Printf(" - %s: NOT propagated\n", s.Name)
+			fmt.Fprintf(w, " - %s: NOT propagated%s\n", s.Name, via)
 		}
 	}
 }
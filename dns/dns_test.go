@@ -0,0 +1,161 @@
+package dns_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+const www = "www." + dnstest.Zone
+
+func TestFindAuthoritativeServers(t *testing.T) {
+	h := dnstest.New(t)
+
+	servers, err := dnspkg.FindAuthoritativeServers(dnstest.Zone, h.Config().RootServers)
+	if err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+
+	got := make(map[string]string)
+	for _, s := range servers {
+		got[s.Name] = s.Addr
+	}
+	for _, ns := range h.NS {
+		if got[ns.Name] != ns.Addr {
+			t.Errorf("server %s: got addr %q, want %q", ns.Name, got[ns.Name], ns.Addr)
+		}
+	}
+	if len(servers) != len(h.NS) {
+		t.Errorf("got %d servers, want %d", len(servers), len(h.NS))
+	}
+}
+
+func TestFindAuthoritativeServersRootFailure(t *testing.T) {
+	h := dnstest.New(t)
+	h.Root.SetRcode(mdns.RcodeServerFailure)
+
+	if _, err := dnspkg.FindAuthoritativeServers(www, h.Config().RootServers); err == nil {
+		t.Fatal("expected an error when the root only returns SERVFAIL")
+	}
+}
+
+func TestCheckPropagation(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "a", "192.0.2.10", mdns.TypeA, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	if len(resp.Authoritative) != 2 || len(resp.Resolvers) != 2 {
+		t.Fatalf("got %d authoritative and %d resolvers, want 2 and 2", len(resp.Authoritative), len(resp.Resolvers))
+	}
+	for _, s := range append(resp.Authoritative, resp.Resolvers...) {
+		if s.Record != "A 192.0.2.10" {
+			t.Errorf("%s: record %q", s.Name, s.Record)
+		}
+	}
+}
+
+func TestCheckPropagationNS(t *testing.T) {
+	h := dnstest.New(t)
+	h.Resolver.Add(dnstest.Zone + " 3600 IN NS ns1.example.test.")
+	h.SystemResolver.Add(dnstest.Zone + " 3600 IN NS ns1.example.test.")
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), dnstest.Zone, "ns", "ns1.example.test", mdns.TypeNS, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	for _, s := range append(resp.Authoritative, resp.Resolvers...) {
+		if s.Record != "NS ns1.example.test." {
+			t.Errorf("%s: record %q", s.Name, s.Record)
+		}
+	}
+}
+
+func TestCheckPropagationRecordAppearsLater(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN TXT \"v=spf1 -all\"")
+	h.NS[1].Remove(www, mdns.TypeTXT)
+	time.AfterFunc(300*time.Millisecond, func() {
+		h.NS[1].Add(www + " 300 IN TXT \"v=spf1 -all\"")
+	})
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "txt", "v=spf1", mdns.TypeTXT, 3*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	if h.NS[1].Queries() < 3 {
+		t.Errorf("expected ns2 to be polled repeatedly, got %d queries", h.NS[1].Queries())
+	}
+}
+
+func TestCheckPropagationTimeout(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	h.NS[0].SetRcode(mdns.RcodeServerFailure)
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "a", "192.0.2.10", mdns.TypeA, 500*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if resp.AllPropagated {
+		t.Fatal("expected the check to time out")
+	}
+	for _, s := range resp.Authoritative {
+		if want := s.Address+":53" != h.NS[0].Addr; s.Propagated != want {
+			t.Errorf("%s: propagated=%v, want %v", s.Name, s.Propagated, want)
+		}
+	}
+}
+
+func TestTruncatedAnswerRetriesOverTCP(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN TXT \"" + strings.Repeat("x", 200) + "\"")
+	h.NS[0].SetTruncate(true)
+
+	record := dnspkg.QueryAuthoritativeRecord(h.NS[0].Addr, www, mdns.TypeTXT, "xxx")
+	if record == "" {
+		t.Fatal("expected the record to be found over TCP")
+	}
+}
+
+func TestSlowServerDoesNotDelayOthers(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	h.NS[1].SetLatency(time.Second)
+
+	authServers, err := dnspkg.FindAuthoritativeServers(www, h.Config().RootServers)
+	if err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+	resolvers := dnspkg.BuildResolvers(h.Config().PublicResolvers)
+
+	var mu sync.Mutex
+	ok := dnspkg.Watch(t.Context(), authServers, resolvers,
+		dnspkg.AuthoritativeProbe(www, mdns.TypeA, "192.0.2.10", h.Config().RootServers), dnspkg.ResolverProbe(www, mdns.TypeA, "192.0.2.10"),
+		h.Config().Schedule(50*time.Millisecond), time.Now(), &mu, nil)
+	if !ok {
+		t.Fatal("expected all servers to propagate")
+	}
+	for _, r := range resolvers {
+		if r.FoundAt > 500*time.Millisecond {
+			t.Errorf("resolver %s found after %s, held up by the slow server", r.Name, r.FoundAt)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Package dnstest builds a fake DNS hierarchy for tests: a root server, a
+// TLD server, two authoritative servers for a zone and two recursive
+// resolver stand-ins, all running on localhost.
+//
+// New installs a transport in the dns package that routes queries for the
+// servers' virtual addresses to their listeners, so FindAuthoritativeServers,
+// CheckPropagation and everything built on them run without network access.
+package dnstest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Zone is the zone served by the fake authoritative servers.
+const Zone = "example.test."
+
+// Hierarchy is a running fake DNS tree.
+type Hierarchy struct {
+	Root *Server   // authoritative for "."
+	TLD  *Server   // authoritative for "test."
+	NS   []*Server // authoritative for Zone
+
+	// Resolver is listed in Config().PublicResolvers; SystemResolver answers
+	// queries sent to the "local" resolver and any unknown address.
+	Resolver       *Server
+	SystemResolver *Server
+
+	mu      sync.Mutex
+	servers map[string]*Server
+	extra   []*Server
+	net     dnspkg.NetTransport
+}
+
+// New starts the hierarchy and installs its transport, with query limits
+// lifted and an empty delegation cache, for the duration of the test. Tests using it must not run in
+// parallel with each other.
+func New(t testing.TB) *Hierarchy {
+	t.Helper()
+
+	h := &Hierarchy{
+		Root:           newServer("a.root-servers.test", "192.0.2.1:53", ".", false),
+		TLD:            newServer("ns.nic.test", "192.0.2.2:53", "test.", false),
+		NS:             []*Server{newServer("ns1.example.test", "192.0.2.11:53", Zone, false), newServer("ns2.example.test", "192.0.2.12:53", Zone, false)},
+		Resolver:       newServer("resolver", "192.0.2.53:53", ".", true),
+		SystemResolver: newServer("system", "192.0.2.54:53", ".", true),
+		servers:        make(map[string]*Server),
+		net:            dnspkg.NetTransport{Timeout: 2 * time.Second},
+	}
+	h.Root.Add(". 518400 IN NS a.root-servers.test.", "a.root-servers.test. 518400 IN A 192.0.2.1")
+	h.Root.Delegate("test.", h.TLD)
+	h.TLD.Delegate(Zone, h.NS...)
+	for _, ns := range h.NS {
+		for _, other := range h.NS {
+			host, _, _ := net.SplitHostPort(other.Addr)
+			ns.Add(Zone+" 3600 IN NS "+mdns.Fqdn(other.Name), mdns.Fqdn(other.Name)+" 3600 IN A "+host)
+		}
+	}
+
+	for _, s := range h.all() {
+		if err := s.start(); err != nil {
+			t.Fatalf("dnstest: starting %s: %v", s.Name, err)
+		}
+		h.servers[s.Addr] = s
+	}
+
+	// Tests poll the same few fake servers hard; the default per-destination
+	// rate would otherwise carry over from one test to the next.
+	dnspkg.SetLimits(dnspkg.LimitsConfig{})
+	dnspkg.FlushDelegations("")
+	prev := dnspkg.SetTransport(h)
+	t.Cleanup(func() {
+		dnspkg.SetTransport(prev)
+		dnspkg.SetLimits(dnspkg.DefaultConfig.Limits)
+		dnspkg.FlushDelegations("")
+		for _, s := range h.all() {
+			s.shutdown()
+		}
+	})
+	return h
+}
+
+func (h *Hierarchy) all() []*Server {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	servers := append([]*Server{h.Root, h.TLD, h.Resolver, h.SystemResolver}, h.NS...)
+	return append(servers, h.extra...)
+}
+
+// AddZone starts another authoritative server for zone and delegates the
+// zone to it from parent.
+func (h *Hierarchy) AddZone(t testing.TB, parent *Server, zone string) *Server {
+	t.Helper()
+
+	h.mu.Lock()
+	n := len(h.extra) + 1
+	h.mu.Unlock()
+
+	s := newServer(fmt.Sprintf("ns%d.zones.test", n), fmt.Sprintf("192.0.2.%d:53", 100+n), zone, false)
+	if err := s.start(); err != nil {
+		t.Fatalf("dnstest: starting %s: %v", s.Name, err)
+	}
+	parent.Delegate(zone, s)
+
+	h.mu.Lock()
+	h.extra = append(h.extra, s)
+	h.servers[s.Addr] = s
+	h.mu.Unlock()
+	return s
+}
+
+// Exchange implements dns.Transport by sending the query to the listener
+// behind the virtual address. Unknown addresses go to SystemResolver.
+func (h *Hierarchy) Exchange(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+	h.mu.Lock()
+	s, ok := h.servers[server]
+	h.mu.Unlock()
+	if !ok {
+		s = h.SystemResolver
+	}
+	return h.net.Exchange(ctx, m, s.listen)
+}
+
+// Config returns a copy of the default config pointed at the hierarchy.
+func (h *Hierarchy) Config() *dnspkg.Config {
+	cfg := dnspkg.DefaultConfig
+	cfg.RootServers = []string{h.Root.Addr}
+	cfg.PublicResolvers = []string{h.Resolver.Addr}
+	cfg.Polling.Jitter = 0
+	return &cfg
+}
+
+// Publish adds records to every authoritative server and both resolvers,
+// as if the change had fully propagated.
+func (h *Hierarchy) Publish(records ...string) {
+	for _, s := range append([]*Server{h.Resolver, h.SystemResolver}, h.NS...) {
+		s.Add(records...)
+	}
+}
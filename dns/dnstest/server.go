@@ -0,0 +1,362 @@
+package dnstest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// rrKey indexes records by owner name and type.
+type rrKey struct {
+	name  string
+	qtype uint16
+}
+
+// Server is a fake DNS server listening on localhost. Tests refer to it by
+// its virtual Addr, which the hierarchy's transport maps to the listener.
+type Server struct {
+	Name string // host name, e.g. "ns1.example.test"
+	Addr string // virtual address used in config and glue, e.g. "192.0.2.11:53"
+
+	listen string // real listener address
+
+	mu        sync.Mutex
+	zone      string               // origin the server is authoritative for
+	recursive bool                 // answer like a recursive resolver
+	records   map[rrKey][]mdns.RR  // zone data or resolver cache
+	cuts      map[string][]mdns.RR // delegated child zone -> NS records
+	glue      []mdns.RR            // address records for delegated nameservers
+	latency   time.Duration
+	rcode     int
+	truncate  bool
+	queries   int
+
+	udp *mdns.Server
+	tcp *mdns.Server
+}
+
+func newServer(name, addr, zone string, recursive bool) *Server {
+	s := &Server{
+		Name:      name,
+		Addr:      addr,
+		zone:      mdns.Fqdn(zone),
+		recursive: recursive,
+		records:   make(map[rrKey][]mdns.RR),
+		cuts:      make(map[string][]mdns.RR),
+	}
+	if !recursive {
+		s.Add(fmt.Sprintf("%s 300 IN SOA %s %s 1 3600 600 86400 60", s.zone, mdns.Fqdn(name), mdns.Fqdn("hostmaster."+name)))
+	}
+	return s
+}
+
+// start listens on a random localhost port with both UDP and TCP.
+func (s *Server) start() error {
+	for attempt := 0; attempt < 10; attempt++ {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		l, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err != nil {
+			pc.Close()
+			continue
+		}
+
+		s.listen = pc.LocalAddr().String()
+		s.udp = &mdns.Server{PacketConn: pc, Handler: s}
+		s.tcp = &mdns.Server{Listener: l, Handler: s}
+
+		started := make(chan struct{}, 2)
+		s.udp.NotifyStartedFunc = func() { started <- struct{}{} }
+		s.tcp.NotifyStartedFunc = func() { started <- struct{}{} }
+		go s.udp.ActivateAndServe()
+		go s.tcp.ActivateAndServe()
+		<-started
+		<-started
+		return nil
+	}
+	return fmt.Errorf("no free port for %s", s.Name)
+}
+
+func (s *Server) shutdown() {
+	if s.udp != nil {
+		s.udp.Shutdown()
+	}
+	if s.tcp != nil {
+		s.tcp.Shutdown()
+	}
+}
+
+// Add adds records in zone file format, e.g. "www.example.test. 300 IN A 192.0.2.10".
+// It panics on records that do not parse, like regexp.MustCompile.
+func (s *Server) Add(records ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, text := range records {
+		rr, err := mdns.NewRR(text)
+		if err != nil {
+			panic(fmt.Sprintf("dnstest: bad record %q: %v", text, err))
+		}
+		k := rrKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		s.records[k] = append(s.records[k], rr)
+	}
+}
+
+// Remove deletes all records with the given owner name and type.
+func (s *Server) Remove(name string, qtype uint16) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, rrKey{strings.ToLower(mdns.Fqdn(name)), qtype})
+}
+
+// Delegate makes child a zone cut served by the given nameservers.
+func (s *Server) Delegate(child string, servers ...*Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	child = strings.ToLower(mdns.Fqdn(child))
+	for _, ns := range servers {
+		host, _, _ := net.SplitHostPort(ns.Addr)
+		s.cuts[child] = append(s.cuts[child], &mdns.NS{
+			Hdr: mdns.RR_Header{Name: child, Rrtype: mdns.TypeNS, Class: mdns.ClassINET, Ttl: 3600},
+			Ns:  mdns.Fqdn(ns.Name),
+		})
+		s.glue = append(s.glue, &mdns.A{
+			Hdr: mdns.RR_Header{Name: mdns.Fqdn(ns.Name), Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 3600},
+			A:   net.ParseIP(host),
+		})
+	}
+}
+
+// SetLatency delays every answer by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetRcode makes the server answer every query with rcode, e.g.
+// mdns.RcodeServerFailure. Zero restores normal answers.
+func (s *Server) SetRcode(rcode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcode = rcode
+}
+
+// SetTruncate makes UDP answers come back empty with the TC bit set, so
+// clients have to retry over TCP.
+func (s *Server) SetTruncate(truncate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncate = truncate
+}
+
+// Queries returns how many queries the server has received.
+func (s *Server) Queries() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+// ServeDNS implements mdns.Handler.
+func (s *Server) ServeDNS(w mdns.ResponseWriter, req *mdns.Msg) {
+	s.mu.Lock()
+	s.queries++
+	latency, rcode, truncate := s.latency, s.rcode, s.truncate
+	reply := s.answer(req)
+	s.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+
+	switch {
+	case rcode != mdns.RcodeSuccess:
+		reply = new(mdns.Msg)
+		reply.SetRcode(req, rcode)
+	case truncate && w.LocalAddr().Network() == "udp":
+		reply = new(mdns.Msg)
+		reply.SetReply(req)
+		reply.Truncated = true
+	}
+	w.WriteMsg(reply)
+}
+
+// answer builds the response to req. Callers must hold s.mu.
+func (s *Server) answer(req *mdns.Msg) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetReply(req)
+	if len(req.Question) == 0 {
+		m.Rcode = mdns.RcodeFormatError
+		return m
+	}
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+
+	opt := req.IsEdns0()
+	dnssec := opt != nil && opt.Do()
+
+	if s.recursive {
+		m.RecursionAvailable = true
+		m.Answer = s.sign(s.lookup(name, q.Qtype), dnssec)
+		if len(m.Answer) == 0 && !s.exists(name) {
+			m.Rcode = mdns.RcodeNameError
+		}
+		return m
+	}
+
+	// Referral to the closest delegated child zone
+	cut := ""
+	for child := range s.cuts {
+		if mdns.IsSubDomain(child, name) && len(child) > len(cut) {
+			cut = child
+		}
+	}
+	if cut != "" {
+		m.Ns = append(m.Ns, s.cuts[cut]...)
+		for _, rr := range s.cuts[cut] {
+			for _, g := range s.glue {
+				if g.Header().Name == rr.(*mdns.NS).Ns {
+					m.Extra = append(m.Extra, g)
+				}
+			}
+		}
+		return m
+	}
+
+	if !mdns.IsSubDomain(s.zone, name) {
+		m.Rcode = mdns.RcodeRefused
+		return m
+	}
+
+	m.Authoritative = true
+	m.Answer = s.sign(s.lookup(name, q.Qtype), dnssec)
+	for _, rr := range m.Answer {
+		if ns, ok := rr.(*mdns.NS); ok {
+			m.Extra = append(m.Extra, s.records[rrKey{strings.ToLower(ns.Ns), mdns.TypeA}]...)
+		}
+	}
+	if len(m.Answer) == 0 {
+		if !s.exists(name) {
+			m.Rcode = mdns.RcodeNameError
+		}
+		m.Ns = s.records[rrKey{s.zone, mdns.TypeSOA}]
+	}
+	return m
+}
+
+// lookup returns the records for name and type, following a CNAME at name
+// within the server's data. An authoritative server only follows it to a
+// target in its zone, like a real one (RFC 1034 4.3.2). A DNAME above name
+// yields the DNAME and a synthesized CNAME (RFC 6672). Callers must hold s.mu.
+func (s *Server) lookup(name string, qtype uint16) []mdns.RR {
+	if rrs := s.records[rrKey{name, qtype}]; len(rrs) > 0 {
+		return rrs
+	}
+	cname := s.records[rrKey{name, mdns.TypeCNAME}]
+	if len(cname) == 0 {
+		cname = s.synthesize(name)
+	}
+	if len(cname) == 0 {
+		return s.expand(name, qtype)
+	}
+	target := strings.ToLower(cname[len(cname)-1].(*mdns.CNAME).Target)
+	if !s.recursive && !mdns.IsSubDomain(s.zone, target) {
+		return cname
+	}
+	return append(append([]mdns.RR{}, cname...), s.records[rrKey{target, qtype}]...)
+}
+
+// synthesize returns a DNAME owned by an ancestor of name and the CNAME it
+// implies, or nil. Callers must hold s.mu.
+func (s *Server) synthesize(name string) []mdns.RR {
+	labels := mdns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		owner := mdns.Fqdn(strings.Join(labels[i:], "."))
+		rrs := s.records[rrKey{owner, mdns.TypeDNAME}]
+		if len(rrs) == 0 {
+			continue
+		}
+		dname := rrs[0].(*mdns.DNAME)
+		return []mdns.RR{dname, &mdns.CNAME{
+			Hdr:    mdns.RR_Header{Name: name, Rrtype: mdns.TypeCNAME, Class: mdns.ClassINET, Ttl: dname.Hdr.Ttl},
+			Target: mdns.Fqdn(strings.Join(labels[:i], ".")) + dname.Target,
+		}}
+	}
+	return nil
+}
+
+// expand answers name from a wildcard one label up the tree, copying the
+// records and their signatures to name (RFC 4592). Callers must hold s.mu.
+func (s *Server) expand(name string, qtype uint16) []mdns.RR {
+	if s.exists(name) {
+		return nil
+	}
+	labels := mdns.SplitDomainName(name)
+	for i := 1; i < len(labels); i++ {
+		wildcard := "*." + mdns.Fqdn(strings.Join(labels[i:], "."))
+		if !s.exists(wildcard) {
+			continue
+		}
+		rrs := append([]mdns.RR{}, s.records[rrKey{wildcard, qtype}]...)
+		for _, rr := range s.records[rrKey{wildcard, mdns.TypeRRSIG}] {
+			if rr.(*mdns.RRSIG).TypeCovered == qtype {
+				rrs = append(rrs, rr)
+			}
+		}
+		out := make([]mdns.RR, len(rrs))
+		for j, rr := range rrs {
+			out[j] = mdns.Copy(rr)
+			out[j].Header().Name = name
+		}
+		return out
+	}
+	return nil
+}
+
+// sign adds the RRSIGs covering each RRset of answer when the query set the
+// DO bit, and strips them otherwise. Callers must hold s.mu.
+func (s *Server) sign(answer []mdns.RR, dnssec bool) []mdns.RR {
+	var out []mdns.RR
+	signed := make(map[rrKey]bool)
+	for _, rr := range answer {
+		if sig, ok := rr.(*mdns.RRSIG); ok {
+			if dnssec {
+				out = append(out, rr)
+				signed[rrKey{strings.ToLower(sig.Hdr.Name), sig.TypeCovered}] = true
+			}
+			continue
+		}
+		out = append(out, rr)
+	}
+	if !dnssec {
+		return out
+	}
+	for _, rr := range answer {
+		k := rrKey{strings.ToLower(rr.Header().Name), rr.Header().Rrtype}
+		if k.qtype == mdns.TypeRRSIG || signed[k] {
+			continue
+		}
+		signed[k] = true
+		for _, sig := range s.records[rrKey{k.name, mdns.TypeRRSIG}] {
+			if sig.(*mdns.RRSIG).TypeCovered == k.qtype {
+				out = append(out, sig)
+			}
+		}
+	}
+	return out
+}
+
+// exists reports whether any record is owned by name. Callers must hold s.mu.
+func (s *Server) exists(name string) bool {
+	for k := range s.records {
+		if k.name == name {
+			return true
+		}
+	}
+	return false
+}
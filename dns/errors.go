@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Failure classes. Errors returned by this package can be matched against
+// them with errors.Is; their messages keep the specific details.
+var (
+	// ErrNXDomain means a parent zone says the domain does not exist, e.g.
+	// an unregistered name.
+	ErrNXDomain = errors.New("domain does not exist")
+	// ErrLameDelegation means the delegated nameservers refuse or fail to
+	// answer for the zone, or cannot be resolved.
+	ErrLameDelegation = errors.New("lame delegation")
+	// ErrUnreachable means none of the nameservers asked could be reached.
+	ErrUnreachable = errors.New("all nameservers unreachable")
+	// ErrLoop means the referrals go in a circle or never reach the zone.
+	ErrLoop = errors.New("delegation loop detected")
+	// ErrUnsupportedType means the record type is not one ripple checks.
+	ErrUnsupportedType = errors.New("unsupported record type")
+	// ErrInvalidInput means a domain, address or parameter is malformed.
+	ErrInvalidInput = errors.New("invalid input")
+)
+
+// errorCodes are the machine-readable names of the failure classes.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrNXDomain, "nxdomain"},
+	{ErrLameDelegation, "lame_delegation"},
+	{ErrUnreachable, "unreachable"},
+	{ErrLoop, "loop"},
+	{ErrUnsupportedType, "unsupported_type"},
+	{ErrInvalidInput, "invalid_input"},
+}
+
+// ErrorCode returns the machine-readable code for err's failure class, e.g.
+// "lame_delegation", or "internal" for anything else.
+func ErrorCode(err error) string {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return "internal"
+}
+
+// classified is an error of a known class whose message is the detail.
+type classified struct {
+	class error
+	msg   string
+	err   error
+}
+
+func (e *classified) Error() string   { return e.msg }
+func (e *classified) Unwrap() []error { return []error{e.class, e.err} }
+
+// Errorf formats an error of the given class, such as ErrInvalidInput. As
+// with fmt.Errorf, a %w verb wraps its operand.
+func Errorf(class error, format string, args ...any) error {
+	err := fmt.Errorf(format, args...)
+	return &classified{class: class, msg: err.Error(), err: errors.Unwrap(err)}
+}
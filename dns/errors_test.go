@@ -0,0 +1,70 @@
+package dns_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestDiscoveryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		setup  func(t *testing.T, h *dnstest.Hierarchy)
+		want   error
+		code   string
+	}{
+		{"nxdomain at parent", "www.missing.test.", func(t *testing.T, h *dnstest.Hierarchy) {}, dnspkg.ErrNXDomain, "nxdomain"},
+		{"nxdomain at a two-label suffix", "www.missing.co.uk.", func(t *testing.T, h *dnstest.Hierarchy) {
+			h.AddZone(t, h.Root, "co.uk.")
+		}, dnspkg.ErrNXDomain, "nxdomain"},
+		{"lame delegation", www, func(t *testing.T, h *dnstest.Hierarchy) {
+			for _, ns := range h.NS {
+				ns.SetRcode(mdns.RcodeRefused)
+			}
+		}, dnspkg.ErrLameDelegation, "lame_delegation"},
+		{"upward referral", www, func(t *testing.T, h *dnstest.Hierarchy) {
+			for _, ns := range h.NS {
+				ns.Delegate("test.", h.TLD)
+			}
+		}, dnspkg.ErrLoop, "loop"},
+		{"unreachable", www, func(t *testing.T, h *dnstest.Hierarchy) {
+			prev := dnspkg.SetTransport(dnspkg.TransportFunc(func(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+				return nil, errors.New("network is unreachable")
+			}))
+			t.Cleanup(func() { dnspkg.SetTransport(prev) })
+		}, dnspkg.ErrUnreachable, "unreachable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := dnstest.New(t)
+			tt.setup(t, h)
+
+			_, err := dnspkg.FindAuthoritativeServers(tt.domain, h.Config().RootServers)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if code := dnspkg.ErrorCode(err); code != tt.code {
+				t.Errorf("ErrorCode = %q, want %q", code, tt.code)
+			}
+		})
+	}
+}
+
+func TestInputErrors(t *testing.T) {
+	_, err := dnspkg.NormalizeName("ex ample.com")
+	if !errors.Is(err, dnspkg.ErrInvalidInput) || dnspkg.ErrorCode(err) != "invalid_input" {
+		t.Errorf("NormalizeName: %v (%s)", err, dnspkg.ErrorCode(err))
+	}
+	if _, err := dnspkg.ReverseName("not-an-ip"); !errors.Is(err, dnspkg.ErrInvalidInput) {
+		t.Errorf("ReverseName: %v", err)
+	}
+	if code := dnspkg.ErrorCode(errors.New("boom")); code != "internal" {
+		t.Errorf("ErrorCode of an unclassified error = %q", code)
+	}
+}
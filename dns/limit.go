@@ -0,0 +1,171 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LimitsConfig caps the query load ripple puts on upstream servers. The
+// limits are shared by every check running in the process.
+type LimitsConfig struct {
+	QueriesPerSecond float64 `yaml:"queries_per_second"` // per destination IP (0 = unlimited)
+	Burst            int     `yaml:"burst"`              // queries allowed back-to-back per destination
+	MaxInFlight      int     `yaml:"max_in_flight"`      // outstanding queries across all destinations (0 = unlimited)
+}
+
+// LimiterStats is a snapshot of the query limiter state.
+type LimiterStats struct {
+	InFlight    int    `json:"in_flight"`
+	MaxInFlight int    `json:"max_in_flight"`
+	Waiting     int    `json:"waiting"`
+	Queries     uint64 `json:"queries_total"`
+	Delayed     uint64 `json:"delayed_total"`
+}
+
+// limiter combines a token bucket per destination IP with a global
+// semaphore on in-flight queries.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	slots   chan struct{}
+
+	inFlight atomic.Int64
+	waiting  atomic.Int64
+	queries  atomic.Uint64
+	delayed  atomic.Uint64
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var queryLimiter atomic.Pointer[limiter]
+
+func init() {
+	SetLimits(DefaultConfig.Limits)
+}
+
+// SetLimits replaces the process-wide query limits. Queries already waiting
+// keep the limits they started with.
+func SetLimits(cfg LimitsConfig) {
+	l := &limiter{
+		rate:    cfg.QueriesPerSecond,
+		burst:   float64(max(cfg.Burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+	if cfg.MaxInFlight > 0 {
+		l.slots = make(chan struct{}, cfg.MaxInFlight)
+	}
+	queryLimiter.Store(l)
+}
+
+// QueryLimiterStats returns the current state of the process-wide query limiter.
+func QueryLimiterStats() LimiterStats {
+	l := queryLimiter.Load()
+	return LimiterStats{
+		InFlight:    int(l.inFlight.Load()),
+		MaxInFlight: cap(l.slots),
+		Waiting:     int(l.waiting.Load()),
+		Queries:     l.queries.Load(),
+		Delayed:     l.delayed.Load(),
+	}
+}
+
+// acquireQuery blocks until a query to server is allowed by the limits. The
+// returned function must be called once the query is finished.
+func acquireQuery(ctx context.Context, server string) (func(), error) {
+	l := queryLimiter.Load()
+	l.queries.Add(1)
+	l.waiting.Add(1)
+	defer l.waiting.Add(-1)
+
+	if wait := l.reserve(hostOf(server)); wait > 0 {
+		l.delayed.Add(1)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			l.unreserve(hostOf(server))
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	if l.slots != nil {
+		select {
+		case l.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	l.inFlight.Add(1)
+	return func() {
+		l.inFlight.Add(-1)
+		if l.slots != nil {
+			<-l.slots
+		}
+	}, nil
+}
+
+// reserve takes a token from the destination's bucket and returns how long
+// the caller has to wait for it.
+func (l *limiter) reserve(host string) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[host]
+	if !ok {
+		if len(l.buckets) >= 4096 {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[host] = b
+	}
+
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / l.rate * float64(time.Second))
+}
+
+// unreserve returns a token taken by a query that was abandoned while waiting.
+func (l *limiter) unreserve(host string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if b, ok := l.buckets[host]; ok {
+		b.tokens = min(l.burst, b.tokens+1)
+	}
+}
+
+// prune drops buckets that have refilled completely. Callers must hold l.mu.
+func (l *limiter) prune(now time.Time) {
+	for host, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, host)
+		}
+	}
+}
+
+// hostOf strips the port from a server address.
+func hostOf(server string) string {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return server
+	}
+	return host
+}
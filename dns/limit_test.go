@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useLimits installs cfg for one test.
+func useLimits(t *testing.T, cfg LimitsConfig) *limiter {
+	t.Helper()
+	SetLimits(cfg)
+	t.Cleanup(func() { SetLimits(DefaultConfig.Limits) })
+	return queryLimiter.Load()
+}
+
+func TestLimiterSpacing(t *testing.T) {
+	l := useLimits(t, LimitsConfig{QueriesPerSecond: 10, Burst: 2})
+
+	// The burst goes out at once, then one token every 100ms
+	for i, want := range []time.Duration{0, 0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := l.reserve("192.0.2.11"); got < want-10*time.Millisecond || got > want {
+			t.Errorf("query %d waits %s, want %s", i+1, got, want)
+		}
+	}
+	// Other destinations have buckets of their own
+	if got := l.reserve("192.0.2.12"); got != 0 {
+		t.Errorf("other destination waits %s", got)
+	}
+
+	start := time.Now()
+	release, err := acquireQuery(context.Background(), "192.0.2.12:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	release, err = acquireQuery(context.Background(), "192.0.2.12:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("third query to a destination after %s, want 100ms", elapsed)
+	}
+	if s := QueryLimiterStats(); s.Queries != 2 || s.Delayed != 1 || s.InFlight != 0 || s.Waiting != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	l := useLimits(t, LimitsConfig{})
+	for range 100 {
+		if wait := l.reserve("192.0.2.11"); wait != 0 {
+			t.Fatalf("unlimited query waits %s", wait)
+		}
+	}
+	if len(l.buckets) != 0 || l.slots != nil || QueryLimiterStats().MaxInFlight != 0 {
+		t.Errorf("unlimited limiter keeps state: %d buckets, %d slots", len(l.buckets), cap(l.slots))
+	}
+}
+
+func TestLimiterInFlight(t *testing.T) {
+	useLimits(t, LimitsConfig{MaxInFlight: 1})
+
+	release, err := acquireQuery(context.Background(), "192.0.2.11:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan func())
+	go func() {
+		r, err := acquireQuery(context.Background(), "192.0.2.12:53")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- r
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second query ran past the cap")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if s := QueryLimiterStats(); s.InFlight != 1 || s.MaxInFlight != 1 || s.Waiting != 1 {
+		t.Errorf("stats while blocked %+v", s)
+	}
+
+	release()
+	select {
+	case r := <-acquired:
+		r()
+	case <-time.After(time.Second):
+		t.Fatal("second query still blocked after the first finished")
+	}
+	if s := QueryLimiterStats(); s.InFlight != 0 || s.Waiting != 0 {
+		t.Errorf("stats after release %+v", s)
+	}
+}
+
+func TestLimiterCancel(t *testing.T) {
+	l := useLimits(t, LimitsConfig{QueriesPerSecond: 1, Burst: 1, MaxInFlight: 1})
+
+	release, err := acquireQuery(context.Background(), "192.0.2.11:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting for a token: the token goes back to the bucket
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireQuery(ctx, "192.0.2.11:53"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for a token: %v", err)
+	}
+	if wait := l.reserve("192.0.2.11"); wait > time.Second {
+		t.Errorf("abandoned token not returned: next query waits %s", wait)
+	}
+
+	// Waiting for a slot
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := acquireQuery(ctx, "192.0.2.12:53"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for a slot: %v", err)
+	}
+	if s := QueryLimiterStats(); s.InFlight != 1 || s.Waiting != 0 {
+		t.Errorf("stats after cancellation %+v", s)
+	}
+	release()
+}
+
+func TestLimiterPrune(t *testing.T) {
+	l := useLimits(t, LimitsConfig{QueriesPerSecond: 10, Burst: 1})
+	now := time.Now()
+	l.buckets["192.0.2.11"] = &bucket{tokens: 0, last: now.Add(-time.Second)}
+	l.buckets["192.0.2.12"] = &bucket{tokens: 0, last: now}
+	l.prune(now)
+	if _, ok := l.buckets["192.0.2.11"]; ok {
+		t.Error("refilled bucket kept")
+	}
+	if _, ok := l.buckets["192.0.2.12"]; !ok {
+		t.Error("empty bucket dropped")
+	}
+}
+
+func TestLoadConfigLimits(t *testing.T) {
+	for _, tt := range []struct {
+		yaml string
+		want LimitsConfig
+	}{
+		{"listen: :8053\n", DefaultConfig.Limits},
+		{"limits:\n  queries_per_second: 0\n  max_in_flight: 0\n", LimitsConfig{Burst: DefaultConfig.Limits.Burst}},
+		{"limits:\n  burst: 10\n", LimitsConfig{QueriesPerSecond: DefaultConfig.Limits.QueriesPerSecond, Burst: 10, MaxInFlight: DefaultConfig.Limits.MaxInFlight}},
+	} {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		os.WriteFile(path, []byte(tt.yaml), 0o644)
+		cfg := DefaultConfig
+		if err := LoadConfig(&cfg, path); err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Limits != tt.want {
+			t.Errorf("%q: limits %+v, want %+v", tt.yaml, cfg.Limits, tt.want)
+		}
+	}
+}
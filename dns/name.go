@@ -0,0 +1,117 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts names for lookup under IDNA2008 (UTS #46
+// non-transitional mapping). STD3 rules are relaxed so that service labels
+// like _acme-challenge and wildcard labels pass; labels are checked by
+// validateLabels instead.
+var idnaProfile = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.BidiRule(),
+	idna.StrictDomainName(false),
+	idna.VerifyDNSLength(true),
+)
+
+// NormalizeName cleans up a domain name as typed or pasted by a user and
+// returns its fully qualified ASCII form. A URL scheme, user info, port,
+// path, query and fragment are stripped, case is folded and Unicode labels
+// are converted to punycode.
+func NormalizeName(input string) (string, error) {
+	name := hostPart(input)
+	if name == "" {
+		return "", Errorf(ErrInvalidInput, "domain is required")
+	}
+	if name == "." {
+		return name, nil
+	}
+
+	ascii, err := idnaProfile.ToASCII(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return "", Errorf(ErrInvalidInput, "invalid domain %q: %w", input, err)
+	}
+	ascii = strings.ToLower(ascii)
+	if err := validateLabels(ascii); err != nil {
+		return "", Errorf(ErrInvalidInput, "invalid domain %q: %w", input, err)
+	}
+	return mdns.Fqdn(ascii), nil
+}
+
+// DisplayName returns the Unicode form of an ASCII domain name, without the
+// trailing dot. Names that do not decode are returned as they are.
+func DisplayName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	if u, err := idna.Display.ToUnicode(name); err == nil {
+		return u
+	}
+	return name
+}
+
+// FormatName returns name as ripple shows it to people: the Unicode form
+// followed by the ASCII one in parentheses for internationalized names, e.g.
+// "bücher.example (xn--bcher-kva.example)", and the name alone otherwise.
+// The web UI formats domain_unicode the same way.
+func FormatName(name string) string {
+	ascii := strings.TrimSuffix(name, ".")
+	if u := DisplayName(name); u != ascii {
+		return u + " (" + ascii + ")"
+	}
+	return ascii
+}
+
+// UnicodeName returns the Unicode form of name if it differs from the ASCII
+// one, or an empty string. It fills the domain_unicode fields of the API.
+func UnicodeName(name string) string {
+	if u := DisplayName(name); u != strings.TrimSuffix(name, ".") {
+		return u
+	}
+	return ""
+}
+
+// hostPart strips everything but the host name from a URL-like input.
+func hostPart(input string) string {
+	s := strings.TrimSpace(input)
+	if i := strings.Index(s, "://"); i >= 0 {
+		s = s[i+3:]
+	}
+	if i := strings.IndexAny(s, "/?#"); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		s = s[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return strings.Trim(s, "[]")
+}
+
+// validateLabels checks the LDH rule on each label of an ASCII name, also
+// allowing underscores and a leading "*" wildcard label.
+func validateLabels(name string) error {
+	for i, label := range strings.Split(name, ".") {
+		switch {
+		case label == "":
+			return errors.New("empty label")
+		case label == "*" && i == 0:
+			continue
+		case strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-"):
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+				return fmt.Errorf("label %q contains %q", label, c)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package dns_test
+
+import (
+	"testing"
+
+	dnspkg "ripple/dns"
+)
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"example.com", "example.com."},
+		{"  WWW.Example.COM. ", "www.example.com."},
+		{"https://user@example.com:8443/path?q=1#frag", "example.com."},
+		{"bücher.example", "xn--bcher-kva.example."},
+		{"Straße.de", "xn--strae-oqa.de."},
+		{"_acme-challenge.example.com", "_acme-challenge.example.com."},
+		{"*.example.com", "*.example.com."},
+	}
+	for _, tt := range tests {
+		got, err := dnspkg.NormalizeName(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeName(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "https://", "ex ample.com", "-bad.com", "a..b", "www.*.com"} {
+		if got, err := dnspkg.NormalizeName(bad); err == nil {
+			t.Errorf("NormalizeName(%q) = %q, want an error", bad, got)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	if got := dnspkg.DisplayName("xn--bcher-kva.example."); got != "bücher.example" {
+		t.Errorf("DisplayName = %q", got)
+	}
+	if got := dnspkg.DisplayName("example.com."); got != "example.com" {
+		t.Errorf("DisplayName = %q", got)
+	}
+}
+
+func TestFormatName(t *testing.T) {
+	if got := dnspkg.FormatName("xn--bcher-kva.example."); got != "bücher.example (xn--bcher-kva.example)" {
+		t.Errorf("FormatName = %q", got)
+	}
+	if got := dnspkg.FormatName("example.com."); got != "example.com" {
+		t.Errorf("FormatName = %q", got)
+	}
+}
+
+func TestUnicodeName(t *testing.T) {
+	if got := dnspkg.UnicodeName("xn--bcher-kva.example."); got != "bücher.example" {
+		t.Errorf("UnicodeName = %q", got)
+	}
+	if got := dnspkg.UnicodeName("example.com."); got != "" {
+		t.Errorf("UnicodeName = %q", got)
+	}
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// QueryEvent describes a query once its exchange is done.
+type QueryEvent struct {
+	Server    string // address queried, host:port
+	Name      string
+	Type      uint16
+	Rcode     string // response code, "" when no response came back
+	Transport string // udp or tcp, "" when the transport does not say
+	RTT       time.Duration
+	Err       error // why no response came back
+}
+
+// TypeName returns the query type as in zone files, e.g. "AAAA".
+func (q QueryEvent) TypeName() string {
+	return mdns.TypeToString[q.Type]
+}
+
+// QueryObserver is told about every query sent, with the context of the
+// query. Queries cut short because their context ended are reported too.
+type QueryObserver func(ctx context.Context, q QueryEvent)
+
+type observerHolder struct{ QueryObserver }
+
+var queryObserver atomic.Pointer[observerHolder]
+
+// SetQueryObserver installs f to be called after every query, for metrics
+// and tracing, and returns the previous observer. f is called from many
+// goroutines at once; nil removes the observer.
+func SetQueryObserver(f QueryObserver) QueryObserver {
+	prev := queryObserver.Swap(&observerHolder{f})
+	if prev == nil {
+		return nil
+	}
+	return prev.QueryObserver
+}
+
+// observeQuery reports an exchange to the query observer, if any.
+func observeQuery(ctx context.Context, server string, m, r *mdns.Msg, err error, rtt time.Duration, protocol string) {
+	h := queryObserver.Load()
+	if h == nil || h.QueryObserver == nil {
+		return
+	}
+	q := QueryEvent{Server: server, Transport: protocol, RTT: rtt, Err: err}
+	if len(m.Question) > 0 {
+		q.Name, q.Type = m.Question[0].Name, m.Question[0].Qtype
+	}
+	if err == nil {
+		q.Rcode = mdns.RcodeToString[r.Rcode]
+	}
+	h.QueryObserver(ctx, q)
+}
+
+// SpanFunc starts a span named name under the span in ctx, for a stage of
+// discovery: "discover" for the whole of it, "zone_cut" for the servers of
+// each zone cut and "ns_lookup" for the address of a nameserver without
+// glue. It returns the context for the work inside the span and a func that
+// ends the span with the outcome of that work.
+type SpanFunc func(ctx context.Context, name string, attrs map[string]string) (context.Context, func(err error))
+
+type spanHolder struct{ SpanFunc }
+
+var spanFunc atomic.Pointer[spanHolder]
+
+// SetSpanFunc installs f to start the spans of discovery and returns the
+// previous one; nil starts none.
+func SetSpanFunc(f SpanFunc) SpanFunc {
+	prev := spanFunc.Swap(&spanHolder{f})
+	if prev == nil {
+		return nil
+	}
+	return prev.SpanFunc
+}
+
+func startSpan(ctx context.Context, name string, attrs map[string]string) (context.Context, func(error)) {
+	h := spanFunc.Load()
+	if h == nil || h.SpanFunc == nil {
+		return ctx, func(error) {}
+	}
+	return h.SpanFunc(ctx, name, attrs)
+}
@@ -0,0 +1,85 @@
+package dns_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestQueryObserver(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	var mu sync.Mutex
+	var seen []dnspkg.QueryEvent
+	dnspkg.SetQueryObserver(func(ctx context.Context, q dnspkg.QueryEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, q)
+	})
+	t.Cleanup(func() { dnspkg.SetQueryObserver(nil) })
+
+	if _, err := dnspkg.QueryDNS(h.NS[0].Addr, www, mdns.TypeA); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dnspkg.QueryDNS(h.NS[0].Addr, "nope.example.test.", mdns.TypeAAAA); err != nil {
+		t.Fatal(err)
+	}
+	down := errors.New("network is down")
+	prev := dnspkg.SetTransport(dnspkg.TransportFunc(func(context.Context, *mdns.Msg, string) (*mdns.Msg, error) {
+		return nil, down
+	}))
+	dnspkg.QueryDNS(h.NS[0].Addr, www, mdns.TypeTXT)
+	dnspkg.SetTransport(prev)
+
+	if len(seen) != 3 {
+		t.Fatalf("observed %+v", seen)
+	}
+	for i, want := range []dnspkg.QueryEvent{
+		{Server: h.NS[0].Addr, Name: www, Type: mdns.TypeA, Rcode: "NOERROR", Transport: "udp"},
+		{Server: h.NS[0].Addr, Name: "nope.example.test.", Type: mdns.TypeAAAA, Rcode: "NXDOMAIN", Transport: "udp"},
+		{Server: h.NS[0].Addr, Name: www, Type: mdns.TypeTXT, Err: down},
+	} {
+		q := seen[i]
+		if q.Server != want.Server || q.Name != want.Name || q.Type != want.Type || q.Rcode != want.Rcode || q.Transport != want.Transport || q.Err != want.Err || q.RTT <= 0 {
+			t.Errorf("query %d: %+v, want %+v", i, q, want)
+		}
+	}
+}
+
+func TestSpanFunc(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	// Each span records its name and the name of its parent
+	type spanKey struct{}
+	var mu sync.Mutex
+	var spans []string
+	dnspkg.SetSpanFunc(func(ctx context.Context, name string, attrs map[string]string) (context.Context, func(error)) {
+		if zone := attrs["dns.zone"]; zone != "" {
+			name += " " + zone
+		}
+		parent, _ := ctx.Value(spanKey{}).(string)
+		return context.WithValue(ctx, spanKey{}, name), func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			spans = append(spans, parent+" > "+name)
+		}
+	})
+	t.Cleanup(func() { dnspkg.SetSpanFunc(nil) })
+
+	if _, err := dnspkg.DiscoverAuthoritativeContext(context.Background(), www, mdns.TypeA, h.Config().RootServers); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"discover > zone_cut .", "discover > zone_cut test.", "discover > zone_cut example.test.", " > discover"}
+	if strings.Join(spans, ", ") != strings.Join(want, ", ") {
+		t.Errorf("spans %q, want %q", spans, want)
+	}
+}
@@ -0,0 +1,261 @@
+package dns
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Schedule controls how often a single server is polled.
+type Schedule struct {
+	Interval    time.Duration // delay after the first miss
+	MaxInterval time.Duration // upper bound for backoff and TTL-based delays (0 = none)
+	Backoff     float64       // multiplier applied per miss; <= 1 keeps a fixed interval
+	Jitter      float64       // random +/- fraction applied to each delay (0..1)
+	HonorTTL    bool          // wait at least the TTL of a cached answer
+}
+
+// Schedule builds the polling schedule for a check with the given retry interval.
+func (c *Config) Schedule(retry time.Duration) Schedule {
+	s := Schedule{
+		Interval: retry,
+		Backoff:  c.Polling.Backoff,
+		Jitter:   c.Polling.Jitter,
+		HonorTTL: c.Polling.HonorTTL == nil || *c.Polling.HonorTTL,
+	}
+	if d, err := time.ParseDuration(c.Polling.MaxInterval); err == nil {
+		s.MaxInterval = d
+	}
+	return s
+}
+
+// Delay returns how long to wait after the given (zero-based) missed attempt.
+// ttl is how long the last answer may be cached for, or zero if unknown.
+func (s Schedule) Delay(attempt int, ttl time.Duration) time.Duration {
+	d := float64(s.Interval)
+	if s.Backoff > 1 {
+		d *= math.Pow(s.Backoff, float64(attempt))
+	}
+	if s.HonorTTL && float64(ttl) > d {
+		d = float64(ttl)
+	}
+	if s.Jitter > 0 {
+		d += d * s.Jitter * (2*rand.Float64() - 1)
+	}
+	// Clamp after the jitter, so MaxInterval holds
+	if s.MaxInterval > 0 && d > float64(s.MaxInterval) {
+		d = float64(s.MaxInterval)
+	}
+	if d < float64(time.Millisecond) {
+		d = float64(time.Millisecond)
+	}
+	return time.Duration(d)
+}
+
+// ProbeResult is the outcome of querying a server once.
+type ProbeResult struct {
+	Record string        // matching record, empty if none
+	TTL    time.Duration // how long the answer may be cached (zero if unknown)
+	Chain  []string      // CNAME/DNAME hops followed to reach the answer
+	// Wildcard is set when the match was synthesized from a wildcard.
+	Wildcard bool
+}
+
+// ProbeFunc queries a server once.
+type ProbeFunc func(ctx context.Context, s *ResolverStatus) ProbeResult
+
+// AuthoritativeProbe returns a ProbeFunc that queries authoritative servers
+// directly, for the server's QName if set. CNAME and DNAME answers are
+// followed through the authoritative servers of each target zone, found from
+// rootServers. TTLs are not reported since an authoritative answer can
+// change at any time.
+func AuthoritativeProbe(domain string, qtype uint16, match string, rootServers []string) ProbeFunc {
+	follower := newChainFollower(rootServers)
+	return func(ctx context.Context, s *ResolverStatus) ProbeResult {
+		name := domain
+		if s.QName != "" {
+			name = s.QName
+		}
+		return follower.resolve(ctx, s.Addr, name, qtype, match)
+	}
+}
+
+// ResolverProbe returns a ProbeFunc that queries recursive resolvers and
+// reports the TTL of the cached answer. Without a usable /etc/resolv.conf,
+// the system resolver is asked through the Go resolver, which knows no TTLs;
+// only over the network, so a replay never leaves its capture.
+func ResolverProbe(domain string, qtype uint16, match string) ProbeFunc {
+	return func(ctx context.Context, s *ResolverStatus) ProbeResult {
+		addr := s.Addr
+		if addr == "" {
+			addr = systemResolver()
+		}
+		if _, direct := currentTransport().(NetTransport); addr == "" && direct {
+			record, _ := CheckResolver("", strings.TrimSuffix(domain, "."), mdns.TypeToString[qtype], match)
+			return ProbeResult{Record: record}
+		}
+		response, err := queryDNS(ctx, addr, domain, qtype, true)
+		if err != nil || response == nil {
+			return ProbeResult{}
+		}
+		result := ProbeResult{
+			Record: MatchRecord(response.Answer, qtype, match),
+			TTL:    answerTTL(response),
+		}
+		if qtype != mdns.TypeCNAME {
+			result.Chain, _ = chainLinks(response.Answer, domain)
+		}
+		if result.Record != "" {
+			result.Wildcard = detectWildcard(ctx, addr, domain, qtype, true, result.Record, match)
+		}
+		return result
+	}
+}
+
+// Poll polls every server on its own schedule until it has the record or ctx
+// is done, so one slow server never delays the others. onFound is called with
+// mu held the first time a server propagates.
+func Poll(ctx context.Context, servers []*ResolverStatus, probe ProbeFunc, sched Schedule, startTime time.Time, mu *sync.Mutex, onFound func(*ResolverStatus)) {
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		mu.Lock()
+		if s.Propagated {
+			mu.Unlock()
+			continue
+		}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func(s *ResolverStatus) {
+			defer wg.Done()
+			pollServer(ctx, s, probe, sched, startTime, mu, onFound)
+		}(s)
+	}
+	wg.Wait()
+}
+
+func pollServer(ctx context.Context, s *ResolverStatus, probe ProbeFunc, sched Schedule, startTime time.Time, mu *sync.Mutex, onFound func(*ResolverStatus)) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		result := probe(ctx, s)
+		if result.Record == "" && ctx.Err() != nil {
+			return // an interrupted probe says nothing about the server
+		}
+		mu.Lock()
+		found := update(s, result, startTime, onFound)
+		mu.Unlock()
+		if found {
+			return
+		}
+
+		timer.Reset(sched.Delay(attempt, result.TTL))
+	}
+}
+
+// update records a probe result and reports whether the server has the
+// record. onFound is called the first time it does. Callers must hold the
+// lock guarding s.
+func update(s *ResolverStatus, result ProbeResult, startTime time.Time, onFound func(*ResolverStatus)) bool {
+	s.Chain = result.Chain
+	if result.Record == "" {
+		return false
+	}
+	if !s.Propagated {
+		s.Propagated = true
+		s.FoundAt = time.Since(startTime)
+		s.Record = result.Record
+		s.Wildcard = result.Wildcard
+		if onFound != nil {
+			onFound(s)
+		}
+	}
+	return true
+}
+
+// ProbeOnce queries every server once, concurrently, and records which have
+// the record, without retrying the others. onFound is called with mu held
+// for each server that has it.
+func ProbeOnce(ctx context.Context, servers []*ResolverStatus, probe ProbeFunc, startTime time.Time, mu *sync.Mutex, onFound func(*ResolverStatus)) {
+	var wg sync.WaitGroup
+	for _, s := range servers {
+		wg.Add(1)
+		go func(s *ResolverStatus) {
+			defer wg.Done()
+			result := probe(ctx, s)
+			mu.Lock()
+			update(s, result, startTime, onFound)
+			mu.Unlock()
+		}(s)
+	}
+	wg.Wait()
+}
+
+// Watch polls authoritative servers and resolvers concurrently until every
+// server has the record or ctx is done. It reports whether all propagated.
+// onFound is called with mu held when a server propagates.
+func Watch(ctx context.Context, authServers, resolvers []*ResolverStatus, authProbe, resolverProbe ProbeFunc, sched Schedule, startTime time.Time, mu *sync.Mutex, onFound func(s *ResolverStatus, isAuth bool)) bool {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		Poll(ctx, authServers, authProbe, sched, startTime, mu, func(s *ResolverStatus) {
+			if onFound != nil {
+				onFound(s, true)
+			}
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		Poll(ctx, resolvers, resolverProbe, sched, startTime, mu, func(s *ResolverStatus) {
+			if onFound != nil {
+				onFound(s, false)
+			}
+		})
+	}()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return AllPropagated(authServers) && AllPropagated(resolvers)
+}
+
+// AllPropagated reports whether every server has the record. Callers must
+// hold the lock guarding the statuses.
+func AllPropagated(servers []*ResolverStatus) bool {
+	for _, s := range servers {
+		if !s.Propagated {
+			return false
+		}
+	}
+	return true
+}
+
+// BuildResolvers creates status entries for the given public resolvers plus
+// the system resolver.
+func BuildResolvers(addrs []string) []*ResolverStatus {
+	resolvers := make([]*ResolverStatus, 0, len(addrs)+1)
+	for _, addr := range addrs {
+		resolvers = append(resolvers, &ResolverStatus{
+			Name: strings.Split(addr, ":")[0],
+			Addr: addr,
+		})
+	}
+	resolvers = append(resolvers, &ResolverStatus{
+		Name: "local",
+		Addr: "", // empty means use system resolver
+	})
+	return resolvers
+}
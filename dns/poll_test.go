@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestScheduleDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		sched   Schedule
+		attempt int
+		ttl     time.Duration
+		want    time.Duration
+	}{
+		{"fixed", Schedule{Interval: time.Second}, 5, 0, time.Second},
+		{"backoff", Schedule{Interval: time.Second, Backoff: 2}, 3, 0, 8 * time.Second},
+		{"backoff capped", Schedule{Interval: time.Second, Backoff: 2, MaxInterval: 5 * time.Second}, 10, 0, 5 * time.Second},
+		{"ttl honoured", Schedule{Interval: time.Second, HonorTTL: true}, 0, 20 * time.Second, 20 * time.Second},
+		{"ttl capped", Schedule{Interval: time.Second, HonorTTL: true, MaxInterval: 10 * time.Second}, 0, time.Hour, 10 * time.Second},
+		{"ttl ignored", Schedule{Interval: time.Second}, 0, time.Hour, time.Second},
+		{"ttl below interval", Schedule{Interval: 5 * time.Second, HonorTTL: true}, 0, time.Second, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.sched.Delay(tt.attempt, tt.ttl); got != tt.want {
+				t.Errorf("Delay(%d, %s) = %s, want %s", tt.attempt, tt.ttl, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleDelayJitter(t *testing.T) {
+	s := Schedule{Interval: 10 * time.Second, Jitter: 0.2}
+	for i := 0; i < 100; i++ {
+		d := s.Delay(0, 0)
+		if d < 8*time.Second || d > 12*time.Second {
+			t.Fatalf("Delay with 20%% jitter = %s, want within 8s..12s", d)
+		}
+	}
+}
+
+func TestScheduleDelayJitterCapped(t *testing.T) {
+	s := Schedule{Interval: 4 * time.Second, MaxInterval: 5 * time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := s.Delay(0, 0)
+		if d < 2*time.Second || d > 5*time.Second {
+			t.Fatalf("capped Delay with 50%% jitter = %s, want within 2s..5s", d)
+		}
+	}
+}
+
+func TestLoadConfigJitter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("polling:\n  jitter: 0\n"), 0o644)
+	cfg := DefaultConfig
+	if err := LoadConfig(&cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Polling.Jitter != 0 || cfg.Polling.Backoff != DefaultConfig.Polling.Backoff {
+		t.Errorf("polling %+v", cfg.Polling)
+	}
+}
+
+func TestLoadConfigSampleRatio(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(path, []byte("tracing:\n  sample_ratio: 0\n"), 0o644)
+	cfg := DefaultConfig
+	if err := LoadConfig(&cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tracing.SampleRatio != 0 {
+		t.Errorf("sample_ratio %v, want 0", cfg.Tracing.SampleRatio)
+	}
+
+	// Unset, it keeps the default
+	os.WriteFile(path, []byte("tracing:\n  service_name: edge\n"), 0o644)
+	cfg = DefaultConfig
+	if err := LoadConfig(&cfg, path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Tracing.SampleRatio != DefaultConfig.Tracing.SampleRatio {
+		t.Errorf("sample_ratio %v, want the default %v", cfg.Tracing.SampleRatio, DefaultConfig.Tracing.SampleRatio)
+	}
+}
+
+func TestResolverProbeWithoutResolvConf(t *testing.T) {
+	prev := systemResolver()
+	systemResolverAddr = ""
+	t.Cleanup(func() { systemResolverAddr = prev })
+
+	// The Go resolver answers localhost from /etc/hosts
+	tests := []struct {
+		domain string
+		qtype  uint16
+		match  string
+		want   string
+	}{
+		{"localhost.", mdns.TypeA, "127.0.0.1", "A 127.0.0.1"},
+		{"1.0.0.127.in-addr.arpa.", mdns.TypePTR, "localhost", "PTR localhost."},
+	}
+	for _, tt := range tests {
+		probe := ResolverProbe(tt.domain, tt.qtype, tt.match)
+		if r := probe(t.Context(), &ResolverStatus{Name: "local"}); r.Record != tt.want {
+			t.Errorf("%s %s without resolv.conf: %+v, want %q", tt.domain, mdns.TypeToString[tt.qtype], r, tt.want)
+		}
+	}
+}
+
+func TestReverseAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"10.2.0.192.in-addr.arpa.", "192.0.2.10"},
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.B.D.0.1.0.0.2.ip6.arpa.", "2001:db8::1"},
+		{"10.0/26.2.0.192.in-addr.arpa.", ""},
+		{"2.0.192.in-addr.arpa.", ""},
+		{"www.example.test.", ""},
+	}
+	for _, tt := range tests {
+		if got := reverseAddr(tt.name); got != tt.want {
+			t.Errorf("reverseAddr(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolverProbeWithoutResolvConfReplay(t *testing.T) {
+	prevAddr := systemResolver()
+	systemResolverAddr = ""
+	t.Cleanup(func() { systemResolverAddr = prevAddr })
+
+	// Neither the probe nor the NS lookup may fall back to the network
+	var asked []string
+	prev := SetTransport(TransportFunc(func(_ context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+		asked = append(asked, m.Question[0].Name)
+		return nil, Errorf(ErrUnreachable, "replay: no recorded exchange with %q", server)
+	}))
+	t.Cleanup(func() { SetTransport(prev) })
+
+	probe := ResolverProbe("localhost.", mdns.TypeA, "127.0.0.1")
+	if r := probe(t.Context(), &ResolverStatus{Name: "local"}); r.Record != "" {
+		t.Errorf("probe answered outside the transport: %+v", r)
+	}
+	if addr, ok := lookupHost(t.Context(), "localhost."); ok {
+		t.Errorf("lookupHost answered outside the transport: %s", addr)
+	}
+	if len(asked) != 2 {
+		t.Errorf("transport asked for %q", asked)
+	}
+}
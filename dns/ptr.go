@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// ReverseName returns the in-addr.arpa or ip6.arpa name for an IP address.
+func ReverseName(addr string) (string, error) {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil {
+		return "", Errorf(ErrInvalidInput, "invalid IP address: %s", addr)
+	}
+	return mdns.ReverseAddr(ip.String())
+}
+
+// reverseAddr returns the IP address of an in-addr.arpa or ip6.arpa name,
+// or "" if name is not the reverse name of a full address.
+func reverseAddr(name string) string {
+	labels := mdns.SplitDomainName(strings.ToLower(name))
+	n := len(labels)
+	switch {
+	case n == 6 && labels[4] == "in-addr" && labels[5] == "arpa":
+		if ip := net.ParseIP(labels[3] + "." + labels[2] + "." + labels[1] + "." + labels[0]); ip != nil {
+			return ip.String()
+		}
+	case n == 34 && labels[32] == "ip6" && labels[33] == "arpa":
+		var b strings.Builder
+		for i := 31; i >= 0; i-- {
+			if len(labels[i]) != 1 {
+				return ""
+			}
+			b.WriteString(labels[i])
+			if i%4 == 0 && i > 0 {
+				b.WriteByte(':')
+			}
+		}
+		if ip := net.ParseIP(b.String()); ip != nil {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// PrepareName turns user input into the fully qualified name to query (see
+// NormalizeName). For PTR checks an IP address is converted to its reverse
+// name.
+func PrepareName(domain string, qtype uint16) (string, error) {
+	if host := hostPart(domain); qtype == mdns.TypePTR && net.ParseIP(host) != nil {
+		return ReverseName(host)
+	}
+	return NormalizeName(domain)
+}
+
+// DiscoverAuthoritative finds the servers to poll for a record. For PTR
+// records it follows RFC 2317 classless delegation: when the reverse zone
+// answers with a CNAME, the servers of the target zone are returned with
+// QName set to the CNAME target.
+func DiscoverAuthoritative(domain string, qtype uint16, rootServers []string) ([]*ResolverStatus, error) {
+	return DiscoverAuthoritativeContext(context.Background(), domain, qtype, rootServers)
+}
+
+// DiscoverAuthoritativeContext is DiscoverAuthoritative with a context, which
+// ends the discovery early when done and carries the spans of its steps.
+func DiscoverAuthoritativeContext(ctx context.Context, domain string, qtype uint16, rootServers []string) (_ []*ResolverStatus, err error) {
+	ctx, end := startSpan(ctx, "discover", map[string]string{"dns.question.name": domain, "dns.question.type": mdns.TypeToString[qtype]})
+	defer func() { end(err) }()
+
+	servers, err := findAuthoritative(ctx, domain, rootServers)
+	if err != nil || qtype != mdns.TypePTR {
+		return servers, err
+	}
+
+	target := ""
+	for _, s := range servers {
+		response, err := queryDNS(ctx, s.Addr, domain, mdns.TypePTR, false)
+		if err != nil || !usable(response) {
+			continue
+		}
+		for _, rr := range response.Answer {
+			if cname, ok := rr.(*mdns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, domain) {
+				target = cname.Target
+			}
+		}
+		break
+	}
+	if target == "" {
+		return servers, nil
+	}
+
+	targetServers, err := findAuthoritative(ctx, target, rootServers)
+	if err != nil {
+		return nil, fmt.Errorf("classless delegation to %s: %w", target, err)
+	}
+	for _, s := range targetServers {
+		s.QName = target
+	}
+	return targetServers, nil
+}
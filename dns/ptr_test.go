@@ -0,0 +1,79 @@
+package dns_test
+
+import (
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestPrepareName(t *testing.T) {
+	tests := []struct {
+		in    string
+		qtype uint16
+		want  string
+	}{
+		{"192.0.2.10", mdns.TypePTR, "10.2.0.192.in-addr.arpa."},
+		{"2001:db8::1", mdns.TypePTR, "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa."},
+		{"10.2.0.192.in-addr.arpa", mdns.TypePTR, "10.2.0.192.in-addr.arpa."},
+		{"example.com", mdns.TypeA, "example.com."},
+	}
+	for _, tt := range tests {
+		got, err := dnspkg.PrepareName(tt.in, tt.qtype)
+		if err != nil || got != tt.want {
+			t.Errorf("PrepareName(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckPropagationPTR(t *testing.T) {
+	h := dnstest.New(t)
+	arpa := h.AddZone(t, h.Root, "2.0.192.in-addr.arpa.")
+	const name = "10.2.0.192.in-addr.arpa."
+	arpa.Add(name + " 300 IN PTR host.example.test.")
+	h.Resolver.Add(name + " 300 IN PTR host.example.test.")
+	h.SystemResolver.Add(name + " 300 IN PTR host.example.test.")
+
+	domain, _ := dnspkg.PrepareName("192.0.2.10", mdns.TypePTR)
+	resp, err := dnspkg.CheckPropagation(h.Config(), domain, "ptr", "host.example.test", mdns.TypePTR, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	if rec := resp.Authoritative[0].Record; rec != "PTR host.example.test." {
+		t.Errorf("record %q", rec)
+	}
+}
+
+func TestCheckPropagationClasslessPTR(t *testing.T) {
+	h := dnstest.New(t)
+	parent := h.AddZone(t, h.Root, "2.0.192.in-addr.arpa.")
+	child := h.AddZone(t, parent, "0-25.2.0.192.in-addr.arpa.")
+
+	const name = "10.2.0.192.in-addr.arpa."
+	const target = "10.0-25.2.0.192.in-addr.arpa."
+	parent.Add(name + " 300 IN CNAME " + target)
+	child.Add(target + " 300 IN PTR host.example.test.")
+	for _, r := range []*dnstest.Server{h.Resolver, h.SystemResolver} {
+		r.Add(name+" 300 IN CNAME "+target, target+" 300 IN PTR host.example.test.")
+	}
+
+	resp, err := dnspkg.CheckPropagation(h.Config(), name, "ptr", "host.example.test.", mdns.TypePTR, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	if len(resp.Authoritative) != 1 || resp.Authoritative[0].Address+":53" != child.Addr {
+		t.Fatalf("expected the classless child zone server, got %+v", resp.Authoritative)
+	}
+	if q := resp.Authoritative[0].Query; q != "10.0-25.2.0.192.in-addr.arpa" {
+		t.Errorf("query %q", q)
+	}
+}
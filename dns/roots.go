@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// LoadRootHints reads a root hints file in the format of IANA's named.root
+// and returns the addresses of the root servers listed in it, IPv4 and IPv6.
+func LoadRootHints(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rrs []mdns.RR
+	zp := mdns.NewZoneParser(f, ".", path)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+	if err := zp.Err(); err != nil {
+		return nil, err
+	}
+
+	servers := rootAddrs(rrs, rrs)
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%s: no root server addresses", path)
+	}
+	return servers, nil
+}
+
+// PrimeRoots sends a priming query (RFC 8109) for the root NS set to the
+// given hint addresses, trying the fastest known server first, and returns
+// the root server addresses from the response.
+func PrimeRoots(ctx context.Context, hints []string) ([]string, error) {
+	var lastErr error = errors.New("no root hints")
+	for _, server := range orderByRTT(hints) {
+		m := new(mdns.Msg)
+		m.SetQuestion(".", mdns.TypeNS)
+		m.SetEdns0(1232, false)
+
+		r, err := exchange(ctx, server, m)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if r.Rcode != mdns.RcodeSuccess {
+			lastErr = fmt.Errorf("priming query to %s: %s", server, mdns.RcodeToString[r.Rcode])
+			continue
+		}
+		if servers := rootAddrs(r.Answer, r.Extra); len(servers) > 0 {
+			return servers, nil
+		}
+		lastErr = fmt.Errorf("priming response from %s has no root server addresses", server)
+	}
+	return nil, lastErr
+}
+
+// Roots returns the root servers to start discovery from: the primed set if
+// PrimeRootServers succeeded, otherwise the hints from root_hints or
+// root_servers.
+func (c *Config) Roots() []string {
+	if len(c.roots) > 0 {
+		return c.roots
+	}
+	return c.RootServers
+}
+
+// PrimeRootServers sends a priming query to the configured hints and uses
+// the root servers it returns from then on. The hints are kept when priming
+// fails. RootServers itself is not changed, so saving the config does not
+// persist the primed set.
+func (c *Config) PrimeRootServers(ctx context.Context) error {
+	servers, err := PrimeRoots(ctx, c.Roots())
+	if err != nil {
+		return fmt.Errorf("priming root servers: %w", err)
+	}
+	c.roots = servers
+	return nil
+}
+
+// rootAddrs returns the addresses of the root NS set in ns, looked up in the
+// address records of addrs, ordered by server name.
+func rootAddrs(ns, addrs []mdns.RR) []string {
+	names := make(map[string]bool)
+	for _, rr := range ns {
+		if n, ok := rr.(*mdns.NS); ok && n.Hdr.Name == "." {
+			names[strings.ToLower(n.Ns)] = true
+		}
+	}
+
+	type addr struct{ name, hostport string }
+	var found []addr
+	for _, rr := range addrs {
+		name := strings.ToLower(rr.Header().Name)
+		if !names[name] {
+			continue
+		}
+		switch rr := rr.(type) {
+		case *mdns.A:
+			found = append(found, addr{name, net.JoinHostPort(rr.A.String(), "53")})
+		case *mdns.AAAA:
+			found = append(found, addr{name, net.JoinHostPort(rr.AAAA.String(), "53")})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool { return found[i].name < found[j].name })
+
+	servers := make([]string, len(found))
+	for i, a := range found {
+		servers[i] = a.hostport
+	}
+	return servers
+}
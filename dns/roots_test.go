@@ -0,0 +1,66 @@
+package dns_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+)
+
+const namedRoot = `;       This file holds the information on root name servers needed to
+;       initialize cache of Internet domain name servers
+.                        3600000      NS    A.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+A.ROOT-SERVERS.NET.      3600000      AAAA  2001:503:ba3e::2:30
+;
+.                        3600000      NS    B.ROOT-SERVERS.NET.
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+B.ROOT-SERVERS.NET.      3600000      AAAA  2801:1b8:10::b
+; End of file
+`
+
+func TestLoadRootHints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "named.root")
+	if err := os.WriteFile(path, []byte(namedRoot), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dnspkg.LoadRootHints(path)
+	if err != nil {
+		t.Fatalf("LoadRootHints: %v", err)
+	}
+	want := []string{"198.41.0.4:53", "[2001:503:ba3e::2:30]:53", "170.247.170.2:53", "[2801:1b8:10::b]:53"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	os.WriteFile(config, []byte("root_hints: "+path+"\n"), 0o644)
+	cfg := dnspkg.DefaultConfig
+	if err := dnspkg.LoadConfig(&cfg, config); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if !slices.Equal(cfg.Roots(), want) {
+		t.Errorf("Roots() = %q, want %q", cfg.Roots(), want)
+	}
+}
+
+func TestPrimeRootServers(t *testing.T) {
+	h := dnstest.New(t)
+	cfg := h.Config()
+	// The first hint does not answer the priming query.
+	cfg.RootServers = []string{"192.0.2.250:53", h.Root.Addr}
+
+	if err := cfg.PrimeRootServers(t.Context()); err != nil {
+		t.Fatalf("PrimeRootServers: %v", err)
+	}
+	if got := cfg.Roots(); !slices.Equal(got, []string{h.Root.Addr}) {
+		t.Errorf("Roots() = %q, want the primed root %s", got, h.Root.Addr)
+	}
+	if len(cfg.RootServers) != 2 {
+		t.Error("priming must not change the configured hints")
+	}
+}
@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rttPenalty is charged to a server whose query failed, so it sorts behind
+// servers that answer.
+const rttPenalty = 2 * time.Second
+
+// rttTracker keeps a smoothed round-trip time per server address, shared by
+// every check in the process, for picking the fastest of a set of equivalent
+// servers such as the roots.
+type rttTracker struct {
+	mu   sync.Mutex
+	srtt map[string]time.Duration
+}
+
+var serverRTT = &rttTracker{srtt: make(map[string]time.Duration)}
+
+// observe folds a new sample into the server's smoothed RTT (alpha = 1/4 as
+// in RFC 6298). A failed query counts as rttPenalty.
+func (t *rttTracker) observe(server string, rtt time.Duration, failed bool) {
+	if failed {
+		rtt = rttPenalty
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.srtt) >= 4096 {
+		clear(t.srtt) // forget everything rather than grow without bound
+	}
+	if old, ok := t.srtt[server]; ok {
+		rtt = old + (rtt-old)/4
+	}
+	t.srtt[server] = rtt
+}
+
+// orderByRTT returns servers sorted by smoothed RTT. Servers without samples
+// get a small random RTT so each of them is tried early on.
+func orderByRTT(servers []string) []string {
+	serverRTT.mu.Lock()
+	rank := make(map[string]time.Duration, len(servers))
+	for _, s := range servers {
+		if rtt, ok := serverRTT.srtt[s]; ok {
+			rank[s] = rtt
+		} else {
+			rank[s] = rand.N(time.Millisecond)
+		}
+	}
+	serverRTT.mu.Unlock()
+
+	sorted := append([]string(nil), servers...)
+	sort.SliceStable(sorted, func(i, j int) bool { return rank[sorted[i]] < rank[sorted[j]] })
+	return sorted
+}
+
+// ServerRTT returns the smoothed round-trip time of the queries sent to a
+// server so far, and false if none was.
+func ServerRTT(s *ResolverStatus) (time.Duration, bool) {
+	addr := s.Addr
+	if addr == "" {
+		addr = systemResolver()
+	}
+	serverRTT.mu.Lock()
+	defer serverRTT.mu.Unlock()
+	rtt, ok := serverRTT.srtt[addr]
+	return rtt, ok
+}
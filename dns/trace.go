@@ -0,0 +1,206 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Trace is the delegation walk from the root to the authoritative servers of
+// a name, like dig +trace: every zone cut with the queries sent to it and the
+// referral it gave, then the answer of each authoritative server.
+type Trace struct {
+	Domain        string       `json:"domain"`
+	DomainUnicode string       `json:"domain_unicode,omitempty"`
+	RecordType    string       `json:"record_type"`
+	Steps         []TraceStep  `json:"steps"`
+	Answers       []TraceQuery `json:"answers"` // the requested type from every authoritative server
+	Elapsed       string       `json:"elapsed"`
+	Error         string       `json:"error,omitempty"`
+	Code          string       `json:"code,omitempty"` // see ErrorCode
+}
+
+// TraceStep is one zone cut of a trace.
+type TraceStep struct {
+	Zone     string       `json:"zone"` // zone the queried servers serve
+	Queries  []TraceQuery `json:"queries"`
+	Referral string       `json:"referral,omitempty"` // child zone delegated to
+	NS       []string     `json:"ns,omitempty"`
+	Glue     []string     `json:"glue,omitempty"` // "name address" of each reachable nameserver
+	TTL      uint32       `json:"ttl,omitempty"`  // lowest TTL of the referral NS and glue
+}
+
+// TraceQuery is one query of a trace and its outcome.
+type TraceQuery struct {
+	Server        string   `json:"server"`
+	Question      string   `json:"question"`
+	Recurse       bool     `json:"rd,omitempty"`
+	RTT           string   `json:"rtt"`
+	Rcode         string   `json:"rcode,omitempty"`
+	Authoritative bool     `json:"aa,omitempty"`
+	Answer        []string `json:"answer,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// tracer collects the steps of a trace from the queries made with its
+// context. A nil tracer records nothing.
+type tracer struct {
+	mu    sync.Mutex
+	trace *Trace
+}
+
+type tracerKey struct{}
+
+func tracerFrom(ctx context.Context) *tracer {
+	t, _ := ctx.Value(tracerKey{}).(*tracer)
+	return t
+}
+
+// step starts a new zone cut.
+func (t *tracer) step(zone string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.trace.Steps = append(t.trace.Steps, TraceStep{Zone: zone, Queries: []TraceQuery{}})
+}
+
+// referral records the delegation given at the current zone cut.
+func (t *tracer) referral(zone string, ns, glue []string, ttl uint32) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.trace.Steps); n > 0 {
+		step := &t.trace.Steps[n-1]
+		step.Referral, step.NS, step.Glue, step.TTL = zone, ns, glue, ttl
+	}
+}
+
+// exchange records a query at the current zone cut.
+func (t *tracer) exchange(server string, m, r *mdns.Msg, err error, rtt time.Duration) {
+	if t == nil {
+		return
+	}
+	q := traceQuery(server, m, r, err, rtt)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if n := len(t.trace.Steps); n > 0 {
+		t.trace.Steps[n-1].Queries = append(t.trace.Steps[n-1].Queries, q)
+	}
+}
+
+func traceQuery(server string, m, r *mdns.Msg, err error, rtt time.Duration) TraceQuery {
+	q := TraceQuery{
+		Server:   strings.TrimSuffix(server, ":53"),
+		Question: questionString(m),
+		Recurse:  m.RecursionDesired,
+		RTT:      rtt.Round(time.Microsecond).String(),
+	}
+	if err != nil {
+		q.Error = err.Error()
+		return q
+	}
+	q.Rcode = mdns.RcodeToString[r.Rcode]
+	q.Authoritative = r.Authoritative
+	for _, rr := range r.Answer {
+		q.Answer = append(q.Answer, rr.String())
+	}
+	return q
+}
+
+// TraceDelegation walks the delegations of domain from the root servers,
+// without using cached zone cuts, and then asks every authoritative server
+// for the record type. The returned trace holds the steps taken even if the
+// walk failed; the error is also set in its Error and Code fields.
+func TraceDelegation(ctx context.Context, domain string, qtype uint16, rootServers []string) (*Trace, error) {
+	start := time.Now()
+	t := &tracer{trace: &Trace{
+		Domain:        strings.TrimSuffix(domain, "."),
+		DomainUnicode: UnicodeName(domain),
+		RecordType:    mdns.TypeToString[qtype],
+		Steps:         []TraceStep{},
+		Answers:       []TraceQuery{},
+	}}
+	tr := t.trace
+
+	servers, err := findAuthoritative(context.WithValue(ctx, tracerKey{}, t), domain, rootServers)
+	if err != nil {
+		err = fmt.Errorf("failed to find authoritative servers: %w", err)
+		tr.Error, tr.Code = err.Error(), ErrorCode(err)
+		tr.Elapsed = time.Since(start).Round(time.Millisecond).String()
+		return tr, err
+	}
+
+	answers := make([]TraceQuery, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func(i int, s *ResolverStatus) {
+			defer wg.Done()
+			m := new(mdns.Msg)
+			m.SetQuestion(domain, qtype)
+			m.RecursionDesired = false
+			begin := time.Now()
+			r, err := exchange(ctx, s.Addr, m)
+			answers[i] = traceQuery(s.Addr, m, r, err, time.Since(begin))
+			answers[i].Server = s.Name
+			if s.Name != strings.TrimSuffix(s.Addr, ":53") {
+				answers[i].Server += " (" + strings.TrimSuffix(s.Addr, ":53") + ")"
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	tr.Answers = answers
+	tr.Elapsed = time.Since(start).Round(time.Millisecond).String()
+	return tr, nil
+}
+
+// PrintTrace writes t in the layout of dig +trace: the queries made at each
+// zone cut and the referral that led to the next, then the answers.
+func PrintTrace(w io.Writer, t *Trace) {
+	fmt.Fprintf(w, "Trace of %s %s\n", FormatName(t.Domain), t.RecordType)
+	for _, step := range t.Steps {
+		fmt.Fprintf(w, "\n%s\n", step.Zone)
+		for _, q := range step.Queries {
+			printTraceQuery(w, q)
+		}
+		if step.Referral != "" {
+			fmt.Fprintf(w, "  referral to %s (TTL %d)\n", step.Referral, step.TTL)
+			for _, g := range step.Glue {
+				fmt.Fprintf(w, "    %s\n", g)
+			}
+		}
+	}
+	if len(t.Answers) > 0 {
+		fmt.Fprintf(w, "\nAuthoritative answers:\n")
+		for _, q := range t.Answers {
+			printTraceQuery(w, q)
+			for _, rr := range q.Answer {
+				fmt.Fprintf(w, "    %s\n", rr)
+			}
+		}
+	}
+	if t.Error != "" {
+		fmt.Fprintf(w, "\nError: %s\n", t.Error)
+	}
+	fmt.Fprintf(w, "\nTraced in %s\n", t.Elapsed)
+}
+
+func printTraceQuery(w io.Writer, q TraceQuery) {
+	outcome := q.Rcode
+	if q.Authoritative {
+		outcome += " aa"
+	}
+	if q.Error != "" {
+		outcome = "error: " + q.Error
+	}
+	fmt.Fprintf(w, "  %-30s %-8s %s  %s\n", q.Server, q.RTT, q.Question, outcome)
+}
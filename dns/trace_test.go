@@ -0,0 +1,68 @@
+package dns_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestTraceDelegation(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	roots := h.Config().RootServers
+
+	// A cached delegation must not shorten the trace
+	if _, err := dnspkg.FindAuthoritativeServers(www, roots); err != nil {
+		t.Fatalf("FindAuthoritativeServers: %v", err)
+	}
+
+	tr, err := dnspkg.TraceDelegation(context.Background(), www, mdns.TypeA, roots)
+	if err != nil {
+		t.Fatalf("TraceDelegation: %v", err)
+	}
+
+	var zones, referrals []string
+	for _, step := range tr.Steps {
+		zones = append(zones, step.Zone)
+		referrals = append(referrals, step.Referral)
+		if len(step.Queries) == 0 || step.Queries[0].RTT == "" {
+			t.Errorf("step %s: no timed queries: %+v", step.Zone, step.Queries)
+		}
+	}
+	if got := strings.Join(zones, " "); got != ". test. example.test." {
+		t.Errorf("zones %q", got)
+	}
+	if got := strings.Join(referrals, " "); got != "test. example.test. " {
+		t.Errorf("referrals %q", got)
+	}
+	if glue := tr.Steps[1].Glue; len(glue) != 2 || glue[0] != "ns1.example.test. 192.0.2.11" || tr.Steps[1].TTL != 3600 {
+		t.Errorf("glue %v, TTL %d", glue, tr.Steps[1].TTL)
+	}
+
+	if len(tr.Answers) != 2 {
+		t.Fatalf("got %d answers, want 2", len(tr.Answers))
+	}
+	for _, a := range tr.Answers {
+		if !a.Authoritative || len(a.Answer) != 1 || !strings.HasSuffix(a.Answer[0], "192.0.2.10") {
+			t.Errorf("answer %+v", a)
+		}
+	}
+}
+
+func TestTraceDelegationError(t *testing.T) {
+	h := dnstest.New(t)
+
+	tr, err := dnspkg.TraceDelegation(context.Background(), "www.example.nope.", mdns.TypeA, h.Config().RootServers)
+	if !errors.Is(err, dnspkg.ErrNXDomain) {
+		t.Fatalf("got %v, want ErrNXDomain", err)
+	}
+	if tr.Code != "nxdomain" || len(tr.Steps) != 1 || tr.Steps[0].Queries[0].Rcode != "NXDOMAIN" {
+		t.Errorf("trace %+v", tr)
+	}
+}
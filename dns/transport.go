@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Transport sends a DNS message to a server and returns the response.
+type Transport interface {
+	Exchange(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error)
+}
+
+// TransportFunc adapts an ordinary function to the Transport interface.
+type TransportFunc func(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error)
+
+// Exchange calls f(ctx, m, server).
+func (f TransportFunc) Exchange(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+	return f(ctx, m, server)
+}
+
+// NetTransport queries servers over UDP and retries over TCP when the answer
+// is truncated.
+type NetTransport struct {
+	Timeout time.Duration
+}
+
+// Exchange implements Transport.
+func (t NetTransport) Exchange(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+	c := new(mdns.Client)
+	c.Timeout = t.Timeout
+
+	r, _, err := c.ExchangeContext(ctx, m, server)
+	if err != nil {
+		return nil, err
+	}
+	reportProtocol(ctx, "udp")
+	if r.Truncated {
+		c.Net = "tcp"
+		if tr, _, err := c.ExchangeContext(ctx, m, server); err == nil {
+			r = tr
+			reportProtocol(ctx, "tcp")
+		}
+	}
+
+	return r, nil
+}
+
+// protocolKey marks where a transport reports the protocol of the response
+// it returns, for QueryEvent.Transport.
+type protocolKey struct{}
+
+func reportProtocol(ctx context.Context, protocol string) {
+	if p, ok := ctx.Value(protocolKey{}).(*string); ok {
+		*p = protocol
+	}
+}
+
+// DefaultTransport is the transport used unless SetTransport replaces it.
+var DefaultTransport Transport = NetTransport{Timeout: 5 * time.Second}
+
+type transportHolder struct{ Transport }
+
+var transport atomic.Pointer[transportHolder]
+
+func init() {
+	transport.Store(&transportHolder{DefaultTransport})
+}
+
+// SetTransport replaces the transport used for every query and returns the
+// previous one.
+func SetTransport(t Transport) Transport {
+	return transport.Swap(&transportHolder{t}).Transport
+}
+
+func currentTransport() Transport {
+	return transport.Load().Transport
+}
@@ -0,0 +1,143 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// WildcardNote flags results whose match came from a wildcard.
+const WildcardNote = "matched via wildcard"
+
+// detectWildcard reports whether record, the match found for name at server,
+// was synthesized from a wildcard rather than published at name itself.
+//
+// Signed zones are judged by their DNSSEC records (see wildcardSynthesized).
+// For unsigned zones a random sibling of name is queried: if it gets the same
+// answer, a wildcard covers name and the match cannot be told apart from it.
+func detectWildcard(ctx context.Context, server, name string, qtype uint16, recurse bool, record, match string) bool {
+	if strings.HasPrefix(name, "*.") {
+		return false // checking the wildcard itself
+	}
+
+	m := new(mdns.Msg)
+	m.SetQuestion(name, qtype)
+	m.RecursionDesired = recurse
+	m.SetEdns0(1232, true)
+	if r, err := exchange(ctx, server, m); err == nil && usable(r) {
+		if wildcardSynthesized(r, name, qtype) {
+			return true
+		}
+		if signed(r, qtype) {
+			return false
+		}
+	}
+
+	sibling := randomSibling(name)
+	if sibling == "" {
+		return false
+	}
+	r, err := queryDNS(ctx, server, sibling, qtype, recurse)
+	if err != nil || r.Rcode != mdns.RcodeSuccess {
+		return false
+	}
+	return MatchRecord(r.Answer, qtype, match) == record
+}
+
+// wildcardSynthesized reports whether a positive answer for name carries
+// DNSSEC evidence of wildcard expansion: an RRSIG whose label count is lower
+// than the owner name's (RFC 4035 section 5.3.4), or an NSEC or NSEC3 record
+// proving that name does not exist (RFC 4035 section 3.1.3.3, RFC 5155
+// section 7.2.6). Denial records that do not cover name prove nothing.
+func wildcardSynthesized(m *mdns.Msg, name string, qtype uint16) bool {
+	if len(m.Answer) == 0 {
+		return false
+	}
+	for _, rr := range m.Answer {
+		sig, ok := rr.(*mdns.RRSIG)
+		if !ok || sig.TypeCovered != qtype || !strings.EqualFold(sig.Hdr.Name, name) {
+			continue
+		}
+		if int(sig.Labels) < mdns.CountLabel(name) {
+			return true
+		}
+	}
+	for _, rr := range m.Ns {
+		switch rr := rr.(type) {
+		case *mdns.NSEC:
+			if nsecCovers(rr, name) {
+				return true
+			}
+		case *mdns.NSEC3:
+			if nsec3Covers(rr, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nsecCovers reports whether name falls strictly between the owner and the
+// next name of an NSEC record in canonical order, that is, whether the record
+// proves name does not exist. The last NSEC of a zone wraps to the apex.
+func nsecCovers(rr *mdns.NSEC, name string) bool {
+	owner, next := rr.Hdr.Name, rr.NextDomain
+	if canonicalCompare(owner, next) < 0 {
+		return canonicalCompare(owner, name) < 0 && canonicalCompare(name, next) < 0
+	}
+	return mdns.IsSubDomain(next, name) && (canonicalCompare(owner, name) < 0 || canonicalCompare(name, next) < 0)
+}
+
+// nsec3Covers reports whether an NSEC3 record proves that name does not
+// exist: its hash interval covers the hash of name or of an ancestor of name
+// within the zone. A record whose owner is the hash itself proves the
+// opposite.
+func nsec3Covers(rr *mdns.NSEC3, name string) bool {
+	for n := name; ; {
+		if rr.Cover(n) && !rr.Match(n) {
+			return true
+		}
+		i, end := mdns.NextLabel(n, 0)
+		if end {
+			return false
+		}
+		n = n[i:]
+	}
+}
+
+// canonicalCompare orders two domain names as DNSSEC does (RFC 4034 section
+// 6.1): label by label from the root, each compared case-insensitively.
+func canonicalCompare(a, b string) int {
+	la, lb := mdns.SplitDomainName(a), mdns.SplitDomainName(b)
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(strings.ToLower(la[i]), strings.ToLower(lb[j])); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// signed reports whether the answer has an RRSIG covering qtype.
+func signed(m *mdns.Msg, qtype uint16) bool {
+	for _, rr := range m.Answer {
+		if sig, ok := rr.(*mdns.RRSIG); ok && sig.TypeCovered == qtype {
+			return true
+		}
+	}
+	return false
+}
+
+// randomSibling returns name with its first label replaced by a random one
+// that is very unlikely to exist, or "" for names directly below the root.
+func randomSibling(name string) string {
+	labels := mdns.SplitDomainName(name)
+	if len(labels) < 2 {
+		return ""
+	}
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "ripple-" + hex.EncodeToString(b) + "." + mdns.Fqdn(strings.Join(labels[1:], "."))
+}
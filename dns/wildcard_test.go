@@ -0,0 +1,139 @@
+package dns_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+const wildcardA = "*." + dnstest.Zone + " 300 IN A 192.0.2.10"
+
+// rrsig returns a signature over an A RRset at owner with the given label count.
+func rrsig(owner string, labels int) string {
+	return fmt.Sprintf("%s 300 IN RRSIG A 13 %d 300 20300101000000 20200101000000 12345 %s AAAA", owner, labels, dnstest.Zone)
+}
+
+func checkA(t *testing.T, h *dnstest.Hierarchy) *dnspkg.CheckResponse {
+	t.Helper()
+	resp, err := dnspkg.CheckPropagation(h.Config(), www, "a", "192.0.2.10", mdns.TypeA, 2*time.Second, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CheckPropagation: %v", err)
+	}
+	if !resp.AllPropagated {
+		t.Fatalf("expected all servers to propagate, got %+v", resp)
+	}
+	return resp
+}
+
+func TestWildcardDetectedBySibling(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(wildcardA)
+
+	resp := checkA(t, h)
+	for _, s := range append(resp.Authoritative, resp.Resolvers...) {
+		if !s.Wildcard {
+			t.Errorf("%s: match not flagged as wildcard", s.Name)
+		}
+	}
+}
+
+func TestSpecificRecordBesideWildcard(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish("*."+dnstest.Zone+" 300 IN A 192.0.2.99", www+" 300 IN A 192.0.2.10")
+
+	resp := checkA(t, h)
+	for _, s := range append(resp.Authoritative, resp.Resolvers...) {
+		if s.Wildcard {
+			t.Errorf("%s: specific record flagged as wildcard", s.Name)
+		}
+	}
+}
+
+func TestWildcardDetectedBySignature(t *testing.T) {
+	h := dnstest.New(t)
+	// The wildcard and the specific record have the same data, so only the
+	// RRSIG label counts tell them apart.
+	h.Publish(wildcardA, rrsig("*."+dnstest.Zone, 2))
+	resp := checkA(t, h)
+	if !resp.Authoritative[0].Wildcard {
+		t.Error("signed wildcard answer not flagged")
+	}
+
+	h.Publish(www+" 300 IN A 192.0.2.10", rrsig(www, 3))
+	resp = checkA(t, h)
+	if resp.Authoritative[0].Wildcard {
+		t.Error("signed specific answer flagged as wildcard")
+	}
+}
+
+// addToPositive adds rr to the authority section of every positive answer,
+// like a signed zone proving that the name asked for does not exist.
+func addToPositive(t *testing.T, h *dnstest.Hierarchy, record string) {
+	t.Helper()
+	rr, err := mdns.NewRR(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := dnspkg.SetTransport(dnspkg.TransportFunc(func(ctx context.Context, m *mdns.Msg, server string) (*mdns.Msg, error) {
+		r, err := h.Exchange(ctx, m, server)
+		if err == nil && len(r.Answer) > 0 {
+			r.Ns = append(r.Ns, rr)
+		}
+		return r, err
+	}))
+	t.Cleanup(func() { dnspkg.SetTransport(prev) })
+}
+
+func TestUnrelatedNSECIsNoWildcardProof(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www+" 300 IN A 192.0.2.10", rrsig(www, 3))
+
+	// Every positive answer carries an NSEC that does not cover www
+	addToPositive(t, h, "mail."+dnstest.Zone+" 300 IN NSEC smtp."+dnstest.Zone+" A RRSIG NSEC")
+
+	resp := checkA(t, h)
+	for _, s := range resp.Authoritative {
+		if s.Wildcard {
+			t.Errorf("%s: flagged as wildcard by an unrelated NSEC", s.Name)
+		}
+	}
+}
+
+func TestWildcardDetectedByDenial(t *testing.T) {
+	// The hash of www.example.test. with SHA-1, no salt and no extra iterations
+	hash := mdns.HashName(www, mdns.SHA1, 0, "")
+	nsec3 := func(owner, next string) string {
+		return fmt.Sprintf("%s.%s 300 IN NSEC3 1 0 0 - %s A RRSIG", owner, dnstest.Zone, next)
+	}
+	tests := []struct {
+		name     string
+		denial   string
+		wildcard bool
+	}{
+		{"covering NSEC", "mail." + dnstest.Zone + " 300 IN NSEC zzz." + dnstest.Zone + " A RRSIG NSEC", true},
+		{"last NSEC of the zone", "mail." + dnstest.Zone + " 300 IN NSEC " + dnstest.Zone + " A RRSIG NSEC", true},
+		{"NSEC of www itself", www + " 300 IN NSEC zzz." + dnstest.Zone + " A RRSIG NSEC", false},
+		{"covering NSEC3", nsec3("00000000000000000000000000000000", "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV"), true},
+		{"NSEC3 of www itself", nsec3(hash, "VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV"), false},
+		{"NSEC3 of another zone", "00000000000000000000000000000000.other.test. 300 IN NSEC3 1 0 0 - VVVVVVVVVVVVVVVVVVVVVVVVVVVVVVVV A", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A specific record, so only the denial can tell a wildcard
+			h := dnstest.New(t)
+			h.Publish(www + " 300 IN A 192.0.2.10")
+			addToPositive(t, h, tt.denial)
+
+			resp := checkA(t, h)
+			if got := resp.Authoritative[0].Wildcard; got != tt.wildcard {
+				t.Errorf("wildcard %v, want %v", got, tt.wildcard)
+			}
+		})
+	}
+}
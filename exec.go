@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// recordingOutput passes a check through to another output and keeps its
+// result.
+type recordingOutput struct {
+	checkOutput
+	result *checkResult
+}
+
+func (o *recordingOutput) finish(r *checkResult) {
+	o.result = r
+	o.checkOutput.finish(r)
+}
+
+// waitFlags are the flags of the wait command on top of checkFlags.
+type waitFlags struct {
+	until     string
+	onTimeout string
+}
+
+func setupWait(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	var wf waitFlags
+	g.register(fs)
+	c.register(fs, true)
+	fs.StringVar(&wf.until, "until", "all", "what to wait for: all (authoritative servers and resolvers) or authoritative")
+	fs.StringVar(&wf.onTimeout, "on-timeout", "", "shell command to run if the record has not propagated by the timeout")
+	return func(args []string) int {
+		if wf.until != "all" && wf.until != "authoritative" {
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "-until must be all or authoritative"))
+		}
+		domain, command := splitCommand(args)
+		if len(command) == 0 {
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "a command to run is required after --"))
+		}
+		var domainArgs []string
+		if domain != "" {
+			domainArgs = []string{domain}
+		}
+		if err := g.needDomain(domainArgs); err != nil {
+			return fail(err)
+		}
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+
+		// The check reports on stderr; stdout belongs to the command
+		out, err := c.newOutput(os.Stderr)
+		if err != nil {
+			return fail(err)
+		}
+		opts, err := c.options(domain, s.replay)
+		if err != nil {
+			out.fail(err)
+			return exitCodeFor(err)
+		}
+		opts.authOnly = wf.until == "authoritative"
+		s.primeRoots()
+		return runWait(out, opts, command, wf.onTimeout)
+	}
+}
+
+// splitCommand separates the domain from the command that follows it, with
+// or without a "--" between them.
+func splitCommand(args []string) (domain string, command []string) {
+	if len(args) > 0 && args[0] != "--" {
+		domain, args = args[0], args[1:]
+	}
+	if len(args) > 0 && args[0] == "--" {
+		args = args[1:]
+	}
+	return domain, args
+}
+
+// runWait waits for the record like a check and then runs command, returning
+// its exit code. If the check times out, onTimeout is run with sh -c instead
+// and the check's own exit code is returned. Neither runs if the check could
+// not start.
+func runWait(out checkOutput, opts *checkOptions, command []string, onTimeout string) int {
+	rec := &recordingOutput{checkOutput: out}
+	code := runCLI(rec, opts)
+	if rec.result == nil {
+		return code
+	}
+
+	env := append(os.Environ(), checkEnv(rec.result)...)
+	if code != exitPropagated {
+		if onTimeout != "" {
+			cmd := exec.Command("sh", "-c", onTimeout)
+			if err := runCommand(cmd, env); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: timeout command: %v\n", err)
+			}
+		}
+		return code
+	}
+
+	return commandExitCode(runCommand(exec.Command(command[0], command[1:]...), env))
+}
+
+// commandExitCode returns the exit code to forward for a command that ran
+// with err.
+func commandExitCode(err error) int {
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() > 0:
+		return exitErr.ExitCode()
+	case err != nil:
+		return fail(err)
+	}
+	return exitPropagated
+}
+
+// runCommand runs cmd with env and the standard streams of ripple.
+func runCommand(cmd *exec.Cmd, env []string) error {
+	cmd.Env = env
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// checkEnv describes the outcome of a check as RIPPLE_* environment
+// variables for the commands run after it.
+func checkEnv(r *checkResult) []string {
+	status := r.code()
+	if status == "" {
+		status = "propagated"
+	}
+	found, total, _ := r.reached()
+	var names []string
+	for _, s := range append(r.response.Authoritative, r.response.Resolvers...) {
+		if s.Propagated {
+			names = append(names, s.Name)
+		}
+	}
+	return []string{
+		"RIPPLE_DOMAIN=" + r.response.Domain,
+		"RIPPLE_TYPE=" + r.response.RecordType,
+		"RIPPLE_MATCH=" + r.response.Match,
+		"RIPPLE_STATUS=" + status,
+		"RIPPLE_ELAPSED=" + r.elapsed.Round(time.Millisecond).String(),
+		"RIPPLE_ELAPSED_SECONDS=" + strconv.FormatFloat(r.elapsed.Seconds(), 'f', 3, 64),
+		"RIPPLE_PROPAGATED=" + strconv.Itoa(found),
+		"RIPPLE_TOTAL=" + strconv.Itoa(total),
+		"RIPPLE_PROPAGATED_SERVERS=" + strings.Join(names, " "),
+	}
+}
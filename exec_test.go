@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ripple/dns/dnstest"
+)
+
+func TestRunWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		publish  func(h *dnstest.Hierarchy)
+		domain   string
+		authOnly bool
+		want     int
+		ran      string // what the command or the timeout command wrote
+	}{
+		{
+			name:    "propagated",
+			publish: func(h *dnstest.Hierarchy) { h.Publish(www + " 300 IN A 192.0.2.10") },
+			want:    7,
+			ran:     "command propagated www.example.test A 4/4",
+		},
+		{
+			name: "authoritative only",
+			publish: func(h *dnstest.Hierarchy) {
+				for _, ns := range h.NS {
+					ns.Add(www + " 300 IN A 192.0.2.10")
+				}
+			},
+			authOnly: true,
+			want:     7,
+			ran:      "command propagated www.example.test A 2/2",
+		},
+		{
+			name:    "timeout",
+			publish: func(h *dnstest.Hierarchy) { h.NS[0].Add(www + " 300 IN A 192.0.2.10") },
+			want:    exitNotAuthoritative,
+			ran:     "timeout authoritative_timeout www.example.test A 1/2",
+		},
+		{
+			name:   "discovery error",
+			domain: "www.example.nope",
+			want:   exitDiscovery,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := useHierarchy(t)
+			if tt.publish != nil {
+				tt.publish(h)
+			}
+			domain := "www.example.test"
+			if tt.domain != "" {
+				domain = tt.domain
+			}
+
+			file := filepath.Join(t.TempDir(), "ran")
+			report := `echo "$0 $RIPPLE_STATUS $RIPPLE_DOMAIN $RIPPLE_TYPE $RIPPLE_PROPAGATED/$RIPPLE_TOTAL" > ` + file
+			command := []string{"sh", "-c", report + "; exit 7", "command"}
+			onTimeout := strings.Replace(report, "$0", "timeout", 1)
+
+			opts := &checkOptions{domain: domain, recordType: "a", match: "192.0.2.10", retry: 50 * time.Millisecond, duration: 300 * time.Millisecond, authOnly: tt.authOnly}
+			code := runWait(&quietOutput{w: &strings.Builder{}}, opts, command, onTimeout)
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d", code, tt.want)
+			}
+			ran, _ := os.ReadFile(file)
+			if got := strings.TrimSpace(string(ran)); got != tt.ran {
+				t.Errorf("ran %q, want %q", got, tt.ran)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	for _, tt := range []struct {
+		args    []string
+		domain  string
+		command string
+	}{
+		{[]string{"example.com", "--", "certbot", "renew"}, "example.com", "certbot renew"},
+		{[]string{"example.com", "certbot"}, "example.com", "certbot"},
+		{[]string{"--", "certbot"}, "", "certbot"},
+		{[]string{"example.com"}, "example.com", ""},
+	} {
+		domain, command := splitCommand(tt.args)
+		if domain != tt.domain || strings.Join(command, " ") != tt.command {
+			t.Errorf("splitCommand(%q) = %q, %q", tt.args, domain, command)
+		}
+	}
+}
+
+func TestWaitReplay(t *testing.T) {
+	h := useHierarchy(t)
+	capture := recordCapture(t, h)
+
+	// The flag package drops the "--", and the capture supplies the domain
+	var code int
+	captureStdout(t, func() { code = run([]string{"wait", "-q", "-replay", capture, "--", "sh", "-c", "exit 7"}) })
+	if code != 7 {
+		t.Errorf("exit code %d, want the command's 7", code)
+	}
+}
@@ -3,131 +3,75 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	tea "github.com/charmbracelet/bubbletea"
-
 	dnspkg "ripple/dns"
-	"ripple/tui"
 )
 
 // Global config loaded at startup
 var config = dnspkg.DefaultConfig
 
 func main() {
-	configFile := flag.String("c", "", "config file path (YAML)")
-	retryInterval := flag.String("r", "", "retry interval (default from config or 5s)")
-	duration := flag.String("w", "", "how long to run (default from config or 1m)")
-	recordType := flag.String("t", "", "record type (a, txt, cname, mx)")
-	match := flag.String("m", "", "match value in record")
-	serve := flag.String("serve", "", "start HTTP server on address (e.g., :8080)")
-	tuiMode := flag.Bool("tui", false, "launch interactive terminal UI")
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] <domain>\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "       %s -serve :8080\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "       %s -tui\n\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "Options:\n")
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\nCLI Examples:\n")
-		fmt.Fprintf(os.Stderr, "  %s -t txt -m _varnish-cdn-verify lab.varnish.cloud\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -t a -m 127.0.0.1 lab.varnish.cloud\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -t txt -m v=spf1 -w 30s -r 3s google.com\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  %s -t a -m 1.1.1.1 -w 30s -r 3s one.one.one.one\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nHTTP Server Mode:\n")
-		fmt.Fprintf(os.Stderr, "  %s -serve :8080\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "\nHTTP API Endpoints:\n")
-		fmt.Fprintf(os.Stderr, "  GET  /health                                    - Health check\n")
-		fmt.Fprintf(os.Stderr, "  GET  /check?domain=<d>&type=<t>&match=<m>       - One-shot check\n")
-		fmt.Fprintf(os.Stderr, "  POST /check {domain,type,match,timeout,retry}   - Check with retries\n")
-		fmt.Fprintf(os.Stderr, "\nConfig File:\n")
-		fmt.Fprintf(os.Stderr, "  %s -c config.yaml -serve :8080\n", os.Args[0])
-	}
-	flag.Parse()
+	os.Exit(run(os.Args[1:]))
+}
 
-	// Load config file if specified
-	if *configFile != "" {
-		if err := dnspkg.LoadConfig(&config, *configFile); err != nil {
-			fmt.Fprintf(os.Stderr, "Error loading config file: %v\n", err)
-			os.Exit(1)
-		}
-	}
+func runServer(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleUI)
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/check", handleCheck)
+	mux.HandleFunc("/check/stream", handleCheckStream)
+	mux.HandleFunc("/trace", handleTrace)
+	mux.HandleFunc("/cache/flush", handleCacheFlush)
+	mux.HandleFunc("/watch", handleWatch)
+	mux.HandleFunc("/watch/check", handleWatchCheck)
 
-	// Apply defaults from config, override with CLI flags
-	retryDuration, _ := time.ParseDuration(config.Defaults.Retry)
-	if *retryInterval != "" {
-		retryDuration, _ = time.ParseDuration(*retryInterval)
+	n, err := newNotifier(config.Webhooks)
+	if err != nil {
+		return err
 	}
+	notifications = n
 
-	timeoutDuration, _ := time.ParseDuration(config.Defaults.Timeout)
-	if *duration != "" {
-		timeoutDuration, _ = time.ParseDuration(*duration)
+	t, err := newSpanExporter(config.Tracing)
+	if err != nil {
+		return err
 	}
-
-	recType := config.Defaults.RecordType
-	if *recordType != "" {
-		recType = *recordType
+	tracing = t
+	if t != nil {
+		dnspkg.SetSpanFunc(t.startDiscoverySpan)
+		go t.run(context.Background())
+		log.Printf("Exporting traces to %s", t.url)
 	}
+	dnspkg.SetQueryObserver(func(ctx context.Context, q dnspkg.QueryEvent) {
+		metrics.observeQuery(ctx, q)
+		tracing.observeQuery(ctx, q)
+	})
 
-	// TUI mode
-	if *tuiMode {
-		m := tui.New(&config, *configFile)
-		p := tea.NewProgram(m, tea.WithAltScreen())
-		if _, err := p.Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "Error running TUI: %v\n", err)
-			os.Exit(1)
+	if len(config.Watch.Records) > 0 {
+		m, err := newDriftMonitor(config.Watch)
+		if err != nil {
+			return err
 		}
-		return
+		watchList = m
+		go m.run(context.Background())
+		log.Printf("Watching %d records for drift", len(m.records))
 	}
 
-	// Determine listen address (CLI flag overrides config)
-	listenAddr := config.Listen
-	if *serve != "" {
-		listenAddr = *serve
-	}
-
-	// HTTP Server mode
-	if listenAddr != "" {
-		runServer(listenAddr)
-		return
-	}
-
-	// CLI mode
-	if flag.NArg() < 1 {
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	domain := flag.Arg(0)
-	if *match == "" {
-		fmt.Fprintf(os.Stderr, "Error: -m (match) is required\n")
-		os.Exit(1)
-	}
-
-	runCLI(domain, recType, *match, retryDuration, timeoutDuration)
-}
-
-func runServer(addr string) {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", handleUI)
-	mux.HandleFunc("/health", handleHealth)
-	mux.HandleFunc("/check", handleCheck)
-	mux.HandleFunc("/check/stream", handleCheckStream)
-
-	handler := accessLog(mux)
+	handler := accessLog(propagateTrace(mux))
 
 	log.Printf("Starting HTTP server on %s", addr)
 	log.Printf("UI available at http://localhost%s", addr)
 
-	if err := http.ListenAndServe(addr, handler); err != nil {
-		log.Fatalf("Server failed: %v", err)
-	}
+	return http.ListenAndServe(addr, handler)
 }
 
 // responseWriter wraps http.ResponseWriter to capture status code
@@ -342,6 +286,27 @@ const uiHTML = `<!DOCTYPE html>
             color: #666;
             margin-top: 10px;
         }
+        .btn-secondary { background: #6c757d; }
+        .btn-secondary:hover { background: #5a6268; }
+        .trace-step { margin-bottom: 12px; font-size: 13px; }
+        .trace-zone { font-weight: 600; font-family: monospace; }
+        .trace-line {
+            font-family: monospace;
+            font-size: 12px;
+            color: #444;
+            padding-left: 16px;
+            white-space: pre-wrap;
+            word-break: break-all;
+        }
+        .trace-referral { color: #007bff; }
+        .status-drifted { background: #dc3545; color: white; }
+        .watch-record { cursor: pointer; }
+        .watch-events {
+            font-family: monospace;
+            font-size: 12px;
+            color: #444;
+            margin-top: 8px;
+        }
     </style>
 </head>
 <body>
@@ -353,7 +318,7 @@ const uiHTML = `<!DOCTYPE html>
             <div class="form-row">
                 <div class="form-group" style="flex: 2;">
                     <label>Domain</label>
-                    <input type="text" x-model="domain" placeholder="example.com" required>
+                    <input type="text" x-model="domain" :placeholder="recordType === 'ptr' ? '192.0.2.1 or 2001:db8::1' : 'example.com'" required>
                 </div>
                 <div class="form-group" style="flex: 0.7;">
                     <label>Record Type</label>
@@ -363,6 +328,7 @@ const uiHTML = `<!DOCTYPE html>
                         <option value="cname">CNAME</option>
                         <option value="mx">MX</option>
                         <option value="aaaa">AAAA</option>
+                        <option value="ptr">PTR</option>
                     </select>
                 </div>
                 <div class="form-group" style="flex: 1.5;">
@@ -379,6 +345,11 @@ const uiHTML = `<!DOCTYPE html>
                     <label>Retry Interval</label>
                     <input type="text" x-model="retry" placeholder="5s">
                 </div>
+                <div class="form-group" style="flex: 0.7; justify-content: flex-end;">
+                    <label title="Walk the delegation from the root instead of using cached NS sets, e.g. after an NS change">
+                        <input type="checkbox" x-model="noCache"> Fresh delegation
+                    </label>
+                </div>
                 <div class="form-group" style="flex: 1; justify-content: flex-end; flex-direction: row; align-items: flex-end; gap: 10px;">
                     <button type="submit" :disabled="loading">
                         <span x-show="loading" class="loading"></span>
@@ -387,6 +358,10 @@ const uiHTML = `<!DOCTYPE html>
                     <button type="button" x-show="loading" @click="cancelCheck" class="btn-cancel">
                         Cancel
                     </button>
+                    <button type="button" x-show="!loading" @click="runTrace" :disabled="tracing || !domain" class="btn-secondary"
+                            title="Show the delegation path from the root servers, like dig +trace">
+                        <span x-text="tracing ? 'Tracing...' : 'Trace'"></span>
+                    </button>
                 </div>
             </div>
         </form>
@@ -409,9 +384,9 @@ const uiHTML = `<!DOCTYPE html>
                                 <div class="status-icon" :class="server.propagated ? 'status-ok' : 'status-pending'"
                                      x-text="server.propagated ? '✓' : '○'"></div>
                                 <span class="server-name" x-text="server.name"></span>
-                                <span class="server-addr" x-text="server.address"></span>
+                                <span class="server-addr" x-text="server.query ? server.address + ' (' + server.query + ')' : server.address"></span>
                                 <span class="server-time" x-text="server.propagated ? server.found_after : '-'"></span>
-                                <span class="server-record" x-text="server.record || '-'" :title="server.record"></span>
+                                <span class="server-record" x-text="(server.record || '-') + (server.wildcard ? ' (matched via wildcard)' : '')" :title="server.chain ? 'via ' + server.chain.join(' → ') : server.record"></span>
                             </div>
                         </template>
                     </div>
@@ -427,20 +402,149 @@ const uiHTML = `<!DOCTYPE html>
                                 <span class="server-name" x-text="server.name"></span>
                                 <span class="server-addr" x-text="server.address"></span>
                                 <span class="server-time" x-text="server.propagated ? server.found_after : '-'"></span>
-                                <span class="server-record" x-text="server.record || '-'" :title="server.record"></span>
+                                <span class="server-record" x-text="(server.record || '-') + (server.wildcard ? ' (matched via wildcard)' : '')" :title="server.chain ? 'via ' + server.chain.join(' → ') : server.record"></span>
                             </div>
                         </template>
                     </div>
                 </div>
 
                 <div class="meta">
+                    Domain: <span x-text="result.domain_unicode ? result.domain_unicode + ' (' + result.domain + ')' : result.domain"></span>
+                    &middot;
                     Checked at: <span x-text="result.checked_at"></span>
                 </div>
             </div>
         </template>
+
+        <template x-if="trace">
+            <div style="margin-top: 20px;">
+                <div class="section-title">
+                    Delegation trace of <span x-text="trace.domain_unicode ? trace.domain_unicode + ' (' + trace.domain + ')' : trace.domain"></span>
+                    <span x-text="trace.record_type"></span>
+                </div>
+                <template x-for="step in trace.steps" :key="step.zone">
+                    <div class="trace-step">
+                        <div class="trace-zone" x-text="step.zone"></div>
+                        <template x-for="q in step.queries">
+                            <div class="trace-line" x-text="traceQuery(q)"></div>
+                        </template>
+                        <template x-if="step.referral">
+                            <div>
+                                <div class="trace-line trace-referral" x-text="'referral to ' + step.referral + ' (TTL ' + step.ttl + ')'"></div>
+                                <template x-for="glue in step.glue || []">
+                                    <div class="trace-line" x-text="'  ' + glue"></div>
+                                </template>
+                            </div>
+                        </template>
+                    </div>
+                </template>
+                <template x-if="trace.answers.length">
+                    <div class="trace-step">
+                        <div class="trace-zone">Authoritative answers</div>
+                        <template x-for="q in trace.answers">
+                            <div>
+                                <div class="trace-line" x-text="traceQuery(q)"></div>
+                                <template x-for="rr in q.answer || []">
+                                    <div class="trace-line" x-text="'  ' + rr"></div>
+                                </template>
+                            </div>
+                        </template>
+                    </div>
+                </template>
+                <template x-if="trace.error">
+                    <div class="error" x-text="trace.error"></div>
+                </template>
+                <div class="meta">Traced in <span x-text="trace.elapsed"></span></div>
+            </div>
+        </template>
+    </div>
+
+    <div x-data="watchList()" x-init="load(); setInterval(() => load(), 30000)" x-show="enabled" class="card">
+        <div class="section-title" style="display: flex; justify-content: space-between; align-items: center;">
+            <span>Watch list</span>
+            <button type="button" class="btn-secondary" @click="checkNow()" :disabled="rechecking"
+                    x-text="rechecking ? 'Checking...' : 'Check now'"></button>
+        </div>
+        <div class="server-list">
+            <template x-for="rec in records" :key="rec.domain + ' ' + rec.record_type + ' ' + rec.match">
+                <div>
+                    <div class="server-item watch-record" @click="toggle(rec)">
+                        <div class="status-icon" :class="statusClass(rec.status)" x-text="statusIcon(rec.status)"></div>
+                        <span class="server-name" x-text="(rec.domain_unicode || rec.domain) + ' ' + rec.record_type"></span>
+                        <span class="server-addr" x-text="rec.status + (rec.since ? ' since ' + rec.since : '')"></span>
+                        <span class="server-record" x-text="rec.error || (rec.drifted ? rec.drifted.join(', ') : rec.match)"
+                              :title="'every ' + rec.interval + ', checked ' + (rec.checked_at || 'not yet')"></span>
+                    </div>
+                    <template x-if="open[key(rec)]">
+                        <div style="padding: 8px 0 8px 30px;">
+                            <template x-for="server in rec.authoritative.concat(rec.resolvers)" :key="server.name">
+                                <div class="server-item">
+                                    <div class="status-icon" :class="server.matching ? 'status-ok' : (server.required ? 'status-drifted' : 'status-pending')"
+                                         x-text="server.matching ? '✓' : '✗'"></div>
+                                    <span class="server-name" x-text="server.name"></span>
+                                    <span class="server-addr" x-text="server.address"></span>
+                                    <span class="server-record" x-text="server.matching ? server.record : (server.last_match ? 'last matched ' + server.last_match : 'never matched')"
+                                          :title="'since ' + server.since + (server.required ? '' : ' (not required)')"></span>
+                                </div>
+                            </template>
+                            <div class="watch-events">
+                                <template x-for="e in rec.events.slice().reverse()">
+                                    <div x-text="e.time + '  ' + e.change + (e.server ? ' ' + e.server : '') + (e.detail ? ': ' + e.detail : '')"></div>
+                                </template>
+                            </div>
+                        </div>
+                    </template>
+                </div>
+            </template>
+        </div>
     </div>
 
     <script>
+        function watchList() {
+            return {
+                enabled: false,
+                records: [],
+                open: {},
+                rechecking: false,
+
+                async load() {
+                    try {
+                        const resp = await fetch('/watch');
+                        const data = await resp.json();
+                        this.enabled = data.enabled;
+                        this.records = data.records;
+                    } catch (e) {
+                        // keep the last state until the server is back
+                    }
+                },
+
+                async checkNow() {
+                    this.rechecking = true;
+                    await fetch('/watch/check', { method: 'POST' });
+                    setTimeout(async () => {
+                        await this.load();
+                        this.rechecking = false;
+                    }, 5000);
+                },
+
+                key(rec) {
+                    return rec.domain + ' ' + rec.record_type + ' ' + rec.match;
+                },
+
+                toggle(rec) {
+                    this.open[this.key(rec)] = !this.open[this.key(rec)];
+                },
+
+                statusClass(status) {
+                    return { ok: 'status-ok', drifted: 'status-drifted', error: 'status-drifted' }[status] || 'status-waiting';
+                },
+
+                statusIcon(status) {
+                    return { ok: '✓', drifted: '✗', error: '!' }[status] || '○';
+                }
+            }
+        }
+
         function dnsChecker() {
             return {
                 domain: '',
@@ -448,10 +552,13 @@ const uiHTML = `<!DOCTYPE html>
                 match: '',
                 timeout: '1m',
                 retry: '5s',
+                noCache: false,
                 loading: false,
                 error: null,
                 result: null,
                 eventSource: null,
+                tracing: false,
+                trace: null,
 
                 runCheck() {
                     this.loading = true;
@@ -471,7 +578,8 @@ const uiHTML = `<!DOCTYPE html>
                         type: this.recordType,
                         match: this.match,
                         timeout: this.timeout,
-                        retry: this.retry
+                        retry: this.retry,
+                        no_cache: this.noCache
                     });
 
                     this.eventSource = new EventSource('/check/stream?' + params);
@@ -480,6 +588,10 @@ const uiHTML = `<!DOCTYPE html>
                         const data = JSON.parse(event.data);
 
                         switch (data.type) {
+                            case 'check':
+                                this.result.domain = data.domain;
+                                this.result.domain_unicode = data.domain_unicode !== data.domain ? data.domain_unicode : '';
+                                break;
                             case 'discovered':
                                 this.result.authoritative.push(data.server);
                                 break;
@@ -532,6 +644,30 @@ const uiHTML = `<!DOCTYPE html>
                     };
                 },
 
+                async runTrace() {
+                    this.tracing = true;
+                    this.error = null;
+                    this.trace = null;
+                    const params = new URLSearchParams({ domain: this.domain, type: this.recordType });
+                    try {
+                        const resp = await fetch('/trace?' + params);
+                        const data = await resp.json();
+                        if (data.steps) {
+                            this.trace = data;
+                        } else {
+                            this.error = data.error;
+                        }
+                    } catch (e) {
+                        this.error = 'Trace failed: ' + e.message;
+                    }
+                    this.tracing = false;
+                },
+
+                traceQuery(q) {
+                    const outcome = q.error ? 'error: ' + q.error : q.rcode + (q.aa ? ' aa' : '');
+                    return q.server + '  ' + q.rtt + '  ' + q.question + '  ' + outcome;
+                },
+
                 cancelCheck() {
                     if (this.eventSource) {
                         this.eventSource.close();
@@ -548,7 +684,56 @@ const uiHTML = `<!DOCTYPE html>
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(struct {
+		Status      string              `json:"status"`
+		Queries     dnspkg.LimiterStats `json:"queries"`
+		Delegations dnspkg.CacheStats   `json:"delegations"`
+	}{"ok", dnspkg.QueryLimiterStats(), dnspkg.DelegationCacheStats()})
+}
+
+// handleCacheFlush drops cached delegations for a zone and its parents, or
+// all of them without a zone parameter.
+func handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "use POST", Code: dnspkg.ErrorCode(dnspkg.ErrInvalidInput)})
+		return
+	}
+
+	zone := r.URL.Query().Get("zone")
+	if zone != "" {
+		name, err := dnspkg.NormalizeName(zone)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		zone = name
+	}
+	dnspkg.FlushDelegations(zone)
+	json.NewEncoder(w).Encode(dnspkg.DelegationCacheStats())
+}
+
+// errorStatus maps the dns package failure classes to HTTP status codes.
+var errorStatus = map[string]int{
+	"invalid_input":    http.StatusBadRequest,
+	"unsupported_type": http.StatusBadRequest,
+	"nxdomain":         http.StatusNotFound,
+	"lame_delegation":  http.StatusBadGateway,
+	"loop":             http.StatusBadGateway,
+	"unreachable":      http.StatusGatewayTimeout,
+}
+
+// writeError writes err as an ErrorResponse with a status code and
+// machine-readable code matching its failure class.
+func writeError(w http.ResponseWriter, err error) {
+	code := dnspkg.ErrorCode(err)
+	status, ok := errorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: err.Error(), Code: code})
 }
 
 func handleCheck(w http.ResponseWriter, r *http.Request) {
@@ -556,30 +741,34 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 
 	var domain, recordType, match string
 	var timeout, retry time.Duration
+	var noCache bool
+	var callback string
 
 	if r.Method == http.MethodPost {
 		var req struct {
-			Domain  string `json:"domain"`
-			Type    string `json:"type"`
-			Match   string `json:"match"`
-			Timeout string `json:"timeout"`
-			Retry   string `json:"retry"`
+			Domain      string `json:"domain"`
+			Type        string `json:"type"`
+			Match       string `json:"match"`
+			Timeout     string `json:"timeout"`
+			Retry       string `json:"retry"`
+			NoCache     bool   `json:"no_cache"`
+			CallbackURL string `json:"callback_url"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "invalid JSON body"})
+			writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid JSON body"))
 			return
 		}
 		domain = req.Domain
 		recordType = req.Type
 		match = req.Match
+		noCache = req.NoCache
+		callback = req.CallbackURL
 
 		if req.Timeout != "" {
 			var err error
 			timeout, err = time.ParseDuration(req.Timeout)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "invalid timeout format"})
+				writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid timeout format"))
 				return
 			}
 		}
@@ -587,8 +776,7 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 			var err error
 			retry, err = time.ParseDuration(req.Retry)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "invalid retry format"})
+				writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid retry format"))
 				return
 			}
 		}
@@ -596,13 +784,17 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 		domain = r.URL.Query().Get("domain")
 		recordType = r.URL.Query().Get("type")
 		match = r.URL.Query().Get("match")
+		noCache = queryBool(r, "no_cache")
+		if r.URL.Query().Has("callback_url") {
+			writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "callback_url is only accepted in a POST /check body"))
+			return
+		}
 
 		if t := r.URL.Query().Get("timeout"); t != "" {
 			var err error
 			timeout, err = time.ParseDuration(t)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "invalid timeout format"})
+				writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid timeout format"))
 				return
 			}
 		}
@@ -610,8 +802,7 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 			var err error
 			retry, err = time.ParseDuration(rt)
 			if err != nil {
-				w.WriteHeader(http.StatusBadRequest)
-				json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "invalid retry format"})
+				writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid retry format"))
 				return
 			}
 		}
@@ -630,44 +821,128 @@ func handleCheck(w http.ResponseWriter, r *http.Request) {
 
 	// Validation
 	if domain == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "domain is required"})
+		writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "domain is required"))
 		return
 	}
 	if match == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "match is required"})
+		writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "match is required"))
 		return
 	}
+	if callback != "" {
+		if err := notifications.checkCallback(callback); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
 
 	dnsType := dnspkg.ParseRecordType(recordType)
 	if dnsType == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: fmt.Sprintf("unsupported record type: %s", recordType)})
+		writeError(w, dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type: %s", recordType))
+		return
+	}
+
+	domain, err := dnspkg.PrepareName(domain, dnsType)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	// Ensure domain is FQDN
-	if !strings.HasSuffix(domain, ".") {
-		domain = domain + "."
+	ctx := r.Context()
+	if noCache {
+		ctx = dnspkg.WithoutDelegationCache(ctx)
 	}
 
 	// Run the check
-	response, err := dnspkg.CheckPropagation(&config, domain, recordType, match, dnsType, timeout, retry)
+	metrics.checkStarted(recordType)
+	ctx, span := tracing.start(ctx, "check", spanServer, checkSpanAttributes(domain, recordType, match))
+	start := time.Now()
+	// The check runs to the end even if the client goes away
+	response, err := dnspkg.CheckPropagationContext(context.WithoutCancel(ctx), &config, domain, recordType, match, dnsType, timeout, retry)
+	outcome := checkOutcome(response, err)
+	metrics.checkFinished(recordType, outcome, response, time.Since(start))
+	span.set("ripple.outcome", outcome)
+	span.end(err)
+	notifications.notify(checkNotification(domain, recordType, match, response, err, time.Since(start)), callback)
+	if format := acceptedReport(r); format != "" {
+		writeCheckReport(w, format, domain, recordType, match, response, err, time.Since(start))
+		return
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: err.Error()})
+		writeError(w, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(response)
 }
 
+// writeCheckReport writes the outcome of a check as a test report. A check
+// that could not run keeps the status code of its failure class.
+func writeCheckReport(w http.ResponseWriter, format, domain, recordType, match string, response *dnspkg.CheckResponse, err error, elapsed time.Duration) {
+	result := BatchResult{Domain: strings.TrimSuffix(domain, "."), Type: strings.ToUpper(recordType), Match: match, Response: response, elapsed: elapsed}
+	if err != nil {
+		result.Status, result.Error = dnspkg.ErrorCode(err), err.Error()
+	} else {
+		result.Status = checkStatus(response)
+	}
+
+	w.Header().Set("Content-Type", reportContentTypes[format])
+	if err != nil {
+		status, ok := errorStatus[result.Status]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+	}
+	writeReport(w, format, []BatchResult{result})
+}
+
+// handleTrace walks the delegations of a name from the root servers and
+// returns the trace. A failed walk still returns the steps taken, with the
+// status code of its failure class.
+func handleTrace(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	domain := r.URL.Query().Get("domain")
+	recordType := r.URL.Query().Get("type")
+	if recordType == "" {
+		recordType = "a"
+	}
+	if domain == "" {
+		writeError(w, dnspkg.Errorf(dnspkg.ErrInvalidInput, "domain is required"))
+		return
+	}
+	dnsType := dnspkg.ParseRecordType(recordType)
+	if dnsType == 0 {
+		writeError(w, dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type: %s", recordType))
+		return
+	}
+	domain, err := dnspkg.PrepareName(domain, dnsType)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	trace, err := dnspkg.TraceDelegation(ctx, domain, dnsType, config.Roots())
+	if err != nil {
+		status, ok := errorStatus[trace.Code]
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		w.WriteHeader(status)
+	}
+	json.NewEncoder(w).Encode(trace)
+}
+
 // SSE event types
 type StreamEvent struct {
-	Type   string            `json:"type"`
-	Server dnspkg.ServerStatus `json:"server,omitempty"`
-	Error  string            `json:"error,omitempty"`
+	Type          string              `json:"type"`
+	Server        dnspkg.ServerStatus `json:"server,omitempty"`
+	Domain        string              `json:"domain,omitempty"`         // "check" events
+	DomainUnicode string              `json:"domain_unicode,omitempty"` // "check" events
+	Error         string              `json:"error,omitempty"`
+	Code          string              `json:"code,omitempty"` // "error" events: see dnspkg.ErrorCode; "timeout" events: partial or authoritative_timeout
 }
 
 func handleCheckStream(w http.ResponseWriter, r *http.Request) {
@@ -709,27 +984,55 @@ func handleCheckStream(w http.ResponseWriter, r *http.Request) {
 
 	// Validation
 	if domain == "" {
-		sendSSE(w, flusher, StreamEvent{Type: "error", Error: "domain is required"})
+		sendError(w, flusher, dnspkg.Errorf(dnspkg.ErrInvalidInput, "domain is required"))
 		return
 	}
 	if match == "" {
-		sendSSE(w, flusher, StreamEvent{Type: "error", Error: "match is required"})
+		sendError(w, flusher, dnspkg.Errorf(dnspkg.ErrInvalidInput, "match is required"))
+		return
+	}
+	if r.URL.Query().Has("callback_url") {
+		sendError(w, flusher, dnspkg.Errorf(dnspkg.ErrInvalidInput, "callback_url is only accepted in a POST /check body"))
 		return
 	}
 
 	dnsType := dnspkg.ParseRecordType(recordType)
 	if dnsType == 0 {
-		sendSSE(w, flusher, StreamEvent{Type: "error", Error: "unsupported record type"})
+		sendError(w, flusher, dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type"))
+		return
+	}
+
+	domain, err := dnspkg.PrepareName(domain, dnsType)
+	if err != nil {
+		sendError(w, flusher, err)
 		return
 	}
 
-	// Ensure domain is FQDN
-	if !strings.HasSuffix(domain, ".") {
-		domain = domain + "."
+	ctx := r.Context()
+	if queryBool(r, "no_cache") {
+		ctx = dnspkg.WithoutDelegationCache(ctx)
 	}
 
+	// Tell the client which name is actually checked
+	sendSSE(w, flusher, StreamEvent{
+		Type:          "check",
+		Domain:        strings.TrimSuffix(domain, "."),
+		DomainUnicode: dnspkg.DisplayName(domain),
+	})
+
 	// Run streaming check
-	runCheckStream(r.Context(), w, flusher, domain, recordType, match, dnsType, timeout, retry)
+	runCheckStream(ctx, w, flusher, domain, recordType, match, dnsType, timeout, retry)
+}
+
+// sendError sends an "error" event with the machine-readable code for err.
+func sendError(w http.ResponseWriter, flusher http.Flusher, err error) {
+	sendSSE(w, flusher, StreamEvent{Type: "error", Error: err.Error(), Code: dnspkg.ErrorCode(err)})
+}
+
+// queryBool reports whether a query parameter is set to a true value.
+func queryBool(r *http.Request, name string) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return v
 }
 
 func sendSSE(w http.ResponseWriter, flusher http.Flusher, event StreamEvent) {
@@ -740,329 +1043,89 @@ func sendSSE(w http.ResponseWriter, flusher http.Flusher, event StreamEvent) {
 
 func runCheckStream(ctx context.Context, w http.ResponseWriter, flusher http.Flusher, domain, recordType, match string, dnsType uint16, timeout, retry time.Duration) {
 	// Create context with timeout
+	metrics.checkStarted(recordType)
+	ctx, span := tracing.start(ctx, "check", spanServer, checkSpanAttributes(domain, recordType, match))
+	start := time.Now()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Find authoritative nameservers
-	authServers, err := dnspkg.FindAuthoritativeServers(domain, config.RootServers)
+	authServers, err := dnspkg.DiscoverAuthoritativeContext(ctx, domain, dnsType, config.Roots())
 	if err != nil {
-		sendSSE(w, flusher, StreamEvent{Type: "error", Error: fmt.Sprintf("failed to find authoritative servers: %v", err)})
+		err = fmt.Errorf("failed to find authoritative servers: %w", err)
+		metrics.checkFinished(recordType, eventError, nil, time.Since(start))
+		span.set("ripple.outcome", eventError)
+		span.end(err)
+		notifications.notify(checkNotification(domain, recordType, match, nil, err, time.Since(start)), "")
+		sendError(w, flusher, err)
 		return
 	}
 
 	// Send discovered authoritative servers
 	for _, s := range authServers {
 		sendSSE(w, flusher, StreamEvent{
-			Type: "discovered",
-			Server: dnspkg.ServerStatus{
-				Name:       s.Name,
-				Address:    strings.TrimSuffix(s.Addr, ":53"),
-				Propagated: false,
-			},
+			Type:   "discovered",
+			Server: dnspkg.NewServerStatus(s),
 		})
 	}
 
-	// Build public resolver list
-	resolvers := make([]*dnspkg.ResolverStatus, 0, len(config.PublicResolvers)+1)
-	for _, addr := range config.PublicResolvers {
-		resolvers = append(resolvers, &dnspkg.ResolverStatus{
-			Name: strings.Split(addr, ":")[0],
-			Addr: addr,
-		})
-	}
-	resolvers = append(resolvers, &dnspkg.ResolverStatus{
-		Name: "local",
-		Addr: "",
-	})
+	resolvers := dnspkg.BuildResolvers(config.PublicResolvers)
 
 	// Send resolver list
 	for _, r := range resolvers {
-		addr := r.Addr
-		if addr == "" {
-			addr = "system"
-		} else {
-			addr = strings.TrimSuffix(addr, ":53")
-		}
 		sendSSE(w, flusher, StreamEvent{
-			Type: "resolver",
-			Server: dnspkg.ServerStatus{
-				Name:       r.Name,
-				Address:    addr,
-				Propagated: false,
-			},
+			Type:   "resolver",
+			Server: dnspkg.NewServerStatus(r),
 		})
 	}
 
 	var mu sync.Mutex
-	startTime := time.Now()
-
-	// Channel to receive propagation events
 	eventCh := make(chan StreamEvent, 100)
 
-	// Check with retries until timeout or all done
-	ticker := time.NewTicker(retry)
-	defer ticker.Stop()
-
-	checkAll := func() {
-		// Check authoritative servers
-		var wg sync.WaitGroup
-		for _, s := range authServers {
-			mu.Lock()
-			if s.Propagated {
-				mu.Unlock()
-				continue
-			}
-			mu.Unlock()
-
-			wg.Add(1)
-			go func(s *dnspkg.ResolverStatus) {
-				defer wg.Done()
-				record := dnspkg.QueryAuthoritativeRecord(s.Addr, domain, dnsType, match)
-				if record != "" {
-					mu.Lock()
-					if !s.Propagated {
-						s.Propagated = true
-						s.FoundAt = time.Since(startTime)
-						s.Record = record
-						eventCh <- StreamEvent{
-							Type: "auth_propagated",
-							Server: dnspkg.ServerStatus{
-								Name:       s.Name,
-								Address:    strings.TrimSuffix(s.Addr, ":53"),
-								Propagated: true,
-								FoundAfter: dnspkg.FormatDuration(s.FoundAt),
-								Record:     record,
-							},
-						}
-					}
-					mu.Unlock()
-				}
-			}(s)
-		}
-		wg.Wait()
-
-		// Check resolvers
-		for _, r := range resolvers {
-			mu.Lock()
-			if r.Propagated {
-				mu.Unlock()
-				continue
-			}
-			mu.Unlock()
-
-			wg.Add(1)
-			go func(r *dnspkg.ResolverStatus) {
-				defer wg.Done()
-				record, found := dnspkg.CheckResolver(r.Addr, strings.TrimSuffix(domain, "."), recordType, match)
-				if found {
-					mu.Lock()
-					if !r.Propagated {
-						r.Propagated = true
-						r.FoundAt = time.Since(startTime)
-						r.Record = record
-						addr := r.Addr
-						if addr == "" {
-							addr = "system"
-						} else {
-							addr = strings.TrimSuffix(addr, ":53")
-						}
-						eventCh <- StreamEvent{
-							Type: "resolver_propagated",
-							Server: dnspkg.ServerStatus{
-								Name:       r.Name,
-								Address:    addr,
-								Propagated: true,
-								FoundAfter: dnspkg.FormatDuration(r.FoundAt),
-								Record:     record,
-							},
-						}
-					}
-					mu.Unlock()
-				}
-			}(r)
-		}
-		wg.Wait()
-	}
-
-	// Goroutine to send events
-	done := make(chan struct{})
+	// Poll every server on its own schedule; events are forwarded as they happen
+	// so the response writer is only used from this goroutine.
+	done := make(chan bool, 1)
 	go func() {
-		for {
-			select {
-			case event := <-eventCh:
-				sendSSE(w, flusher, event)
-			case <-done:
-				// Drain remaining events
-				for {
-					select {
-					case event := <-eventCh:
-						sendSSE(w, flusher, event)
-					default:
-						return
-					}
+		done <- dnspkg.Watch(ctx, authServers, resolvers,
+			dnspkg.AuthoritativeProbe(domain, dnsType, match, config.Roots()), dnspkg.ResolverProbe(domain, dnsType, match),
+			config.Schedule(retry), time.Now(), &mu,
+			func(s *dnspkg.ResolverStatus, isAuth bool) {
+				event := StreamEvent{Type: "resolver_propagated", Server: dnspkg.NewServerStatus(s)}
+				if isAuth {
+					event.Type = "auth_propagated"
 				}
-			}
-		}
+				eventCh <- event
+			})
+		close(eventCh)
 	}()
 
-	// Initial check
-	checkAll()
-
-	for {
-		// Check if all done
-		allDone := true
-		mu.Lock()
-		for _, s := range authServers {
-			if !s.Propagated {
-				allDone = false
-				break
-			}
-		}
-		if allDone {
-			for _, r := range resolvers {
-				if !r.Propagated {
-					allDone = false
-					break
-				}
-			}
-		}
-		mu.Unlock()
-
-		if allDone {
-			close(done)
-			time.Sleep(50 * time.Millisecond) // Allow pending events to drain
-			sendSSE(w, flusher, StreamEvent{Type: "complete"})
-			return
-		}
-
-		select {
-		case <-ctx.Done():
-			close(done)
-			time.Sleep(50 * time.Millisecond) // Allow pending events to drain
-			sendSSE(w, flusher, StreamEvent{Type: "timeout"})
-			return
-		case <-ticker.C:
-			checkAll()
-		}
-	}
-}
-
-func runCLI(domain, recordType, match string, retryInterval, duration time.Duration) {
-	// Ensure domain is FQDN
-	if !strings.HasSuffix(domain, ".") {
-		domain = domain + "."
-	}
-
-	dnsType := dnspkg.ParseRecordType(recordType)
-	if dnsType == 0 {
-		fmt.Fprintf(os.Stderr, "Error: unsupported record type %q\n", recordType)
-		os.Exit(1)
-	}
-
-	fmt.Printf("Testing DNS propagation for %s (%s=%s)\n", strings.TrimSuffix(domain, "."), strings.ToUpper(recordType), match)
-	fmt.Printf("Retry interval: %s, Max duration: %s\n\n", retryInterval, duration)
-
-	// Step 1: Find all authoritative nameservers
-	fmt.Println("=== Discovering authoritative nameservers ===")
-	authServers, err := dnspkg.FindAuthoritativeServers(domain, config.RootServers)
-	if err != nil {
-		fmt.Printf("Error finding authoritative servers: %v\n", err)
-		os.Exit(1)
-	}
-
-	if len(authServers) == 0 {
-		fmt.Println("No authoritative nameservers found")
-		os.Exit(1)
-	}
-
-	fmt.Printf("Found %d authoritative nameservers:\n", len(authServers))
-	for _, ns := range authServers {
-		fmt.Printf("  - %s (%s)\n", ns.Name, strings.TrimSuffix(ns.Addr, ":53"))
+	for event := range eventCh {
+		sendSSE(w, flusher, event)
 	}
-	fmt.Println()
-
-	// Step 2: Check all authoritative nameservers
-	fmt.Println("=== Checking authoritative nameservers ===")
-	startTime := time.Now()
 
-	ctx, cancel := context.WithTimeout(context.Background(), duration)
-	defer cancel()
-
-	var mu sync.Mutex
-	allAuthPropagated := func() bool {
+	// A check the client walked away from is not worth a notification
+	allDone := <-done
+	if errors.Is(ctx.Err(), context.Canceled) {
+		metrics.checkFinished(recordType, outcomeCancelled, nil, time.Since(start))
+		span.set("ripple.outcome", outcomeCancelled)
+	} else {
 		mu.Lock()
-		defer mu.Unlock()
-		for _, r := range authServers {
-			if !r.Propagated {
-				return false
-			}
-		}
-		return true
-	}
-
-	// Initial check
-	dnspkg.CheckAuthoritativeAllVerbose(authServers, domain, dnsType, match, recordType, startTime, &mu)
-
-	if !allAuthPropagated() {
-		ticker := time.NewTicker(retryInterval)
-		for !allAuthPropagated() {
-			select {
-			case <-ctx.Done():
-				fmt.Printf("\nTimeout: not all authoritative servers have the record\n")
-				dnspkg.PrintSummary(authServers, "authoritative")
-				ticker.Stop()
-				os.Exit(1)
-			case <-ticker.C:
-				dnspkg.CheckAuthoritativeAllVerbose(authServers, domain, dnsType, match, recordType, startTime, &mu)
-			}
-		}
-		ticker.Stop()
-	}
-
-	fmt.Println("\nAll authoritative nameservers have the record!")
-
-	// Step 3: Check public resolvers
-	fmt.Println("\n=== Checking public resolvers for propagation ===")
-	resolvers := make([]*dnspkg.ResolverStatus, 0, len(config.PublicResolvers)+1)
-	for _, addr := range config.PublicResolvers {
-		resolvers = append(resolvers, &dnspkg.ResolverStatus{
-			Name: strings.Split(addr, ":")[0],
-			Addr: addr,
-		})
+		response := dnspkg.NewCheckResponse(domain, recordType, match, authServers, resolvers)
+		mu.Unlock()
+		outcome := checkOutcome(response, nil)
+		metrics.checkFinished(recordType, outcome, response, time.Since(start))
+		span.set("ripple.outcome", outcome)
+		notifications.notify(checkNotification(domain, recordType, match, response, nil, time.Since(start)), "")
 	}
-	resolvers = append(resolvers, &dnspkg.ResolverStatus{
-		Name: "local",
-		Addr: "", // empty means use system resolver
-	})
-
-	resolverStartTime := time.Now()
-	ticker := time.NewTicker(retryInterval)
-	defer ticker.Stop()
+	span.end(nil)
 
-	allResolversPropagated := func() bool {
-		mu.Lock()
-		defer mu.Unlock()
-		for _, r := range resolvers {
-			if !r.Propagated {
-				return false
-			}
-		}
-		return true
+	if allDone {
+		sendSSE(w, flusher, StreamEvent{Type: "complete"})
+		return
 	}
-
-	// Initial check
-	dnspkg.CheckResolverAllVerbose(resolvers, domain, recordType, match, resolverStartTime, &mu)
-
-	for !allResolversPropagated() {
-		select {
-		case <-ctx.Done():
-			fmt.Printf("\nTimeout reached after %s\n", duration)
-			dnspkg.PrintSummary(resolvers, "resolver")
-			os.Exit(1)
-		case <-ticker.C:
-			dnspkg.CheckResolverAllVerbose(resolvers, domain, recordType, match, resolverStartTime, &mu)
-		}
+	code := codePartial
+	if !dnspkg.AllPropagated(authServers) {
+		code = codeAuthoritativeTimeout
 	}
-
-	fmt.Printf("\nAll resolvers propagated!\n")
+	sendSSE(w, flusher, StreamEvent{Type: "timeout", Code: code})
 }
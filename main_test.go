@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	dnspkg "ripple/dns"
+	"ripple/dns/dnstest"
+
+	mdns "github.com/miekg/dns"
+)
+
+const www = "www." + dnstest.Zone
+
+// useHierarchy points the global config at a fake DNS tree for one test.
+func useHierarchy(t *testing.T) *dnstest.Hierarchy {
+	t.Helper()
+	h := dnstest.New(t)
+	saved := config
+	config = *h.Config()
+	t.Cleanup(func() { config = saved })
+	return h
+}
+
+func TestHandleCheck(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	req := httptest.NewRequest(http.MethodGet, "/check?domain=www.example.test&type=a&match=192.0.2.10&timeout=2s&retry=50ms", nil)
+	rec := httptest.NewRecorder()
+	handleCheck(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp dnspkg.CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if !resp.AllPropagated || resp.Domain != "www.example.test" || resp.RecordType != "A" {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+}
+
+func TestHandleCheckNoCache(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	const url = "/check?domain=www.example.test&type=a&match=192.0.2.10&timeout=2s&retry=50ms"
+	handleCheck(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+
+	// A no_cache check walks from the root, which now refuses
+	h.Root.SetRcode(mdns.RcodeRefused)
+	rec := httptest.NewRecorder()
+	handleCheck(rec, httptest.NewRequest(http.MethodGet, url+"&no_cache=true", nil))
+	if rec.Code == http.StatusOK {
+		t.Errorf("no_cache check did not start at the root: %s", rec.Body)
+	}
+
+	// and leaves the cache to the other checks
+	rec = httptest.NewRecorder()
+	handleCheck(rec, httptest.NewRequest(http.MethodGet, url, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("check after a no_cache check: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestHandleCheckPost(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN TXT \"hello world\"")
+
+	body := `{"domain":"www.example.test","type":"txt","match":"hello","timeout":"2s","retry":"50ms"}`
+	req := httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handleCheck(rec, req)
+
+	var resp dnspkg.CheckResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if rec.Code != http.StatusOK || !resp.AllPropagated {
+		t.Errorf("status %d, response %+v", rec.Code, resp)
+	}
+}
+
+func TestHandleCheckBadRequest(t *testing.T) {
+	useHierarchy(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  string
+		code  string
+	}{
+		{"missing domain", "match=x", "domain is required", "invalid_input"},
+		{"missing match", "domain=www.example.test", "match is required", "invalid_input"},
+		{"bad type", "domain=www.example.test&match=x&type=srv", "unsupported record type: srv", "unsupported_type"},
+		{"bad domain", "domain=ex+ample.test&match=x", `invalid domain "ex ample.test": label "ex ample" contains ' '`, "invalid_input"},
+		{"bad timeout", "domain=www.example.test&match=x&timeout=soon", "invalid timeout format", "invalid_input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handleCheck(rec, httptest.NewRequest(http.MethodGet, "/check?"+tt.query, nil))
+
+			var resp dnspkg.ErrorResponse
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if rec.Code != http.StatusBadRequest || resp.Error != tt.want || resp.Code != tt.code {
+				t.Errorf("got %d %q (%s), want 400 %q (%s)", rec.Code, resp.Error, resp.Code, tt.want, tt.code)
+			}
+		})
+	}
+}
+
+func TestHandleCheckDiscoveryError(t *testing.T) {
+	useHierarchy(t)
+
+	rec := httptest.NewRecorder()
+	handleCheck(rec, httptest.NewRequest(http.MethodGet, "/check?domain=www.missing.test&match=x&timeout=1s", nil))
+
+	var resp dnspkg.ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusNotFound || resp.Code != "nxdomain" {
+		t.Errorf("got %d %+v, want 404 nxdomain", rec.Code, resp)
+	}
+}
+
+func TestHandleCheckStream(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	rec := httptest.NewRecorder()
+	handleCheckStream(rec, httptest.NewRequest(http.MethodGet, "/check/stream?domain=www.example.test&match=192.0.2.10&timeout=2s&retry=50ms", nil))
+
+	counts := make(map[string]int)
+	var last string
+	scanner := bufio.NewScanner(rec.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event StreamEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("bad event %q: %v", data, err)
+		}
+		counts[event.Type]++
+		last = event.Type
+	}
+
+	want := map[string]int{"discovered": 2, "resolver": 2, "auth_propagated": 2, "resolver_propagated": 2, "complete": 1}
+	for typ, n := range want {
+		if counts[typ] != n {
+			t.Errorf("got %d %q events, want %d", counts[typ], typ, n)
+		}
+	}
+	if last != "complete" {
+		t.Errorf("last event %q, want complete", last)
+	}
+}
+
+func TestHandleTrace(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	rec := httptest.NewRecorder()
+	handleTrace(rec, httptest.NewRequest(http.MethodGet, "/trace?domain=www.example.test&type=a", nil))
+
+	var tr dnspkg.Trace
+	if err := json.NewDecoder(rec.Body).Decode(&tr); err != nil {
+		t.Fatalf("decoding trace: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(tr.Steps) != 3 || len(tr.Answers) != 2 || tr.Steps[2].Zone != "example.test." {
+		t.Errorf("status %d, trace %+v", rec.Code, tr)
+	}
+}
+
+func TestHandleTraceNXDomain(t *testing.T) {
+	useHierarchy(t)
+
+	rec := httptest.NewRecorder()
+	handleTrace(rec, httptest.NewRequest(http.MethodGet, "/trace?domain=www.example.nope", nil))
+
+	// The steps taken are returned along with the failure
+	var tr dnspkg.Trace
+	if err := json.NewDecoder(rec.Body).Decode(&tr); err != nil {
+		t.Fatalf("decoding trace: %v", err)
+	}
+	if rec.Code != http.StatusNotFound || tr.Code != "nxdomain" || len(tr.Steps) != 1 {
+		t.Errorf("status %d, trace %+v", rec.Code, tr)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// metricsContentType is the Prometheus text exposition format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Histogram buckets, in seconds
+var (
+	rttBuckets         = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+	propagationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+)
+
+// maxMetricServers bounds the server label: queries to further servers are
+// counted as server="other", so a stream of checks against arbitrary domains
+// cannot grow the exposition without bound.
+const maxMetricServers = 1000
+
+// outcomeCancelled is the outcome of a check the client walked away from; the
+// others are the webhook events complete, timeout and error.
+const outcomeCancelled = "cancelled"
+
+// metricFamily is a counter, gauge or histogram with its series by label
+// values.
+type metricFamily struct {
+	name, kind, help string
+	labels           []string
+	buckets          []float64 // upper bounds, for histograms
+	series           map[string]*metricSeries
+}
+
+type metricSeries struct {
+	values []string
+	value  float64  // counters and gauges
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newFamily(name, kind, help string, buckets []float64, labels ...string) *metricFamily {
+	return &metricFamily{name: name, kind: kind, help: help, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+}
+
+func (f *metricFamily) with(values ...string) *metricSeries {
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &metricSeries{values: values, counts: make([]uint64, len(f.buckets))}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *metricFamily) add(v float64, values ...string) {
+	f.with(values...).value += v
+}
+
+func (f *metricFamily) observe(v float64, values ...string) {
+	s := f.with(values...)
+	if i, _ := slices.BinarySearch(f.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// write renders the family, its series sorted by label values.
+func (f *metricFamily) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != "histogram" {
+			writeSample(w, f.name, f.labels, s.values, s.value)
+			continue
+		}
+		labels := append(slices.Clone(f.labels), "le")
+		var cumulative uint64
+		for i, le := range f.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, f.name+"_bucket", labels, append(slices.Clone(s.values), formatFloat(le)), float64(cumulative))
+		}
+		writeSample(w, f.name+"_bucket", labels, append(slices.Clone(s.values), "+Inf"), float64(s.count))
+		writeSample(w, f.name+"_sum", f.labels, s.values, s.sum)
+		writeSample(w, f.name+"_count", f.labels, s.values, float64(s.count))
+	}
+}
+
+// writeSample writes one line of the exposition.
+func writeSample(w io.Writer, name string, labels, values []string, v float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l + `="` + labelEscaper.Replace(values[i]) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	fmt.Fprintf(w, "%s %s\n", b.String(), formatFloat(v))
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsRegistry holds the metrics of server mode that ripple counts
+// itself; the query limiter and delegation cache keep their own and are
+// read at scrape time.
+type metricsRegistry struct {
+	mu      sync.Mutex
+	servers map[string]bool // server label values in use
+
+	checksStarted     *metricFamily
+	checksFinished    *metricFamily
+	checksInFlight    *metricFamily
+	propagation       *metricFamily
+	serverPropagation *metricFamily
+	queries           *metricFamily
+	queryErrors       *metricFamily
+	queryDuration     *metricFamily
+}
+
+func newMetrics() *metricsRegistry {
+	m := &metricsRegistry{
+		servers:           make(map[string]bool),
+		checksStarted:     newFamily("ripple_checks_started_total", "counter", "Checks started through the API.", nil, "type"),
+		checksFinished:    newFamily("ripple_checks_finished_total", "counter", "Checks finished through the API, by outcome: complete, timeout, error or cancelled.", nil, "type", "outcome"),
+		checksInFlight:    newFamily("ripple_checks_in_flight", "gauge", "Checks running through the API.", nil),
+		propagation:       newFamily("ripple_propagation_seconds", "histogram", "Time until every server had the record, for complete checks.", propagationBuckets, "type"),
+		serverPropagation: newFamily("ripple_server_propagation_seconds", "histogram", "Time until a server had the record, by role: authoritative or resolver.", propagationBuckets, "type", "role"),
+		queries:           newFamily("ripple_dns_queries_total", "counter", "DNS queries answered, by server and response code.", nil, "server", "rcode"),
+		queryErrors:       newFamily("ripple_dns_query_errors_total", "counter", "DNS queries that got no response, by server.", nil, "server"),
+		queryDuration:     newFamily("ripple_dns_query_duration_seconds", "histogram", "Round-trip time of answered DNS queries, by server and response code.", rttBuckets, "server", "rcode"),
+	}
+	m.checksInFlight.add(0)
+	return m
+}
+
+// metrics is the registry /metrics exposes.
+var metrics = newMetrics()
+
+// checkStarted counts a check of recordType as started and in flight.
+func (m *metricsRegistry) checkStarted(recordType string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checksStarted.add(1, strings.ToUpper(recordType))
+	m.checksInFlight.add(1)
+}
+
+// checkFinished records the outcome of a check started with checkStarted,
+// and for a check that ran, how long its servers took to get the record.
+func (m *metricsRegistry) checkFinished(recordType, outcome string, response *dnspkg.CheckResponse, elapsed time.Duration) {
+	recordType = strings.ToUpper(recordType)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checksFinished.add(1, recordType, outcome)
+	m.checksInFlight.add(-1)
+	if outcome == eventComplete {
+		m.propagation.observe(elapsed.Seconds(), recordType)
+	}
+	if response == nil {
+		return
+	}
+	for role, servers := range map[string][]dnspkg.ServerStatus{"authoritative": response.Authoritative, "resolver": response.Resolvers} {
+		for _, s := range servers {
+			if d, err := time.ParseDuration(s.FoundAfter); err == nil && s.Propagated {
+				m.serverPropagation.observe(d.Seconds(), recordType, role)
+			}
+		}
+	}
+}
+
+// checkOutcome names the outcome of a check that was not cancelled.
+func checkOutcome(response *dnspkg.CheckResponse, err error) string {
+	switch {
+	case err != nil:
+		return eventError
+	case response.AllPropagated:
+		return eventComplete
+	}
+	return eventTimeout
+}
+
+// observeQuery is the query observer of server mode. Queries cut short by
+// the end of their check say nothing about the server and are not counted.
+func (m *metricsRegistry) observeQuery(ctx context.Context, q dnspkg.QueryEvent) {
+	if ctx.Err() != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	server := strings.TrimSuffix(q.Server, ":53")
+	if !m.servers[server] {
+		if len(m.servers) >= maxMetricServers {
+			server = "other"
+		}
+		m.servers[server] = true
+	}
+	if q.Err != nil {
+		m.queryErrors.add(1, server)
+		return
+	}
+	m.queries.add(1, server, q.Rcode)
+	m.queryDuration.observe(q.RTT.Seconds(), server, q.Rcode)
+}
+
+// write renders every metric, reading the query limiter and delegation
+// cache as they are now.
+func (m *metricsRegistry) write(w io.Writer) {
+	m.mu.Lock()
+	for _, f := range []*metricFamily{m.checksStarted, m.checksFinished, m.checksInFlight, m.propagation, m.serverPropagation, m.queries, m.queryErrors, m.queryDuration} {
+		f.write(w)
+	}
+	m.mu.Unlock()
+
+	limiter, cache := dnspkg.QueryLimiterStats(), dnspkg.DelegationCacheStats()
+	for _, g := range []struct {
+		name, kind, help string
+		value            float64
+	}{
+		{"ripple_limiter_in_flight_queries", "gauge", "DNS queries outstanding.", float64(limiter.InFlight)},
+		{"ripple_limiter_max_in_flight_queries", "gauge", "Cap on outstanding DNS queries, 0 if unlimited.", float64(limiter.MaxInFlight)},
+		{"ripple_limiter_waiting_queries", "gauge", "DNS queries waiting for the limiter.", float64(limiter.Waiting)},
+		{"ripple_limiter_queries_total", "counter", "DNS queries let through the limiter.", float64(limiter.Queries)},
+		{"ripple_limiter_delayed_total", "counter", "DNS queries the limiter held back.", float64(limiter.Delayed)},
+		{"ripple_delegation_cache_entries", "gauge", "Zone cuts in the delegation cache.", float64(cache.Entries)},
+		{"ripple_delegation_cache_hits_total", "counter", "Discoveries that started at a cached zone cut.", float64(cache.Hits)},
+		{"ripple_delegation_cache_misses_total", "counter", "Discoveries that found no cached zone cut.", float64(cache.Misses)},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", g.name, g.help, g.name, g.kind)
+		writeSample(w, g.name, nil, nil, g.value)
+	}
+}
+
+// handleMetrics exposes the metrics of server mode to Prometheus.
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+	metrics.write(w)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// useMetrics counts into a fresh registry for one test.
+func useMetrics(t *testing.T) *metricsRegistry {
+	t.Helper()
+	m := newMetrics()
+	prev := metrics
+	metrics = m
+	dnspkg.SetQueryObserver(m.observeQuery)
+	t.Cleanup(func() {
+		dnspkg.SetQueryObserver(nil)
+		metrics = prev
+	})
+	return m
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != metricsContentType {
+		t.Errorf("content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func TestMetricsChecks(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	useMetrics(t)
+
+	body := `{"domain":"www.example.test","type":"a","match":"192.0.2.10","timeout":"2s","retry":"50ms"}`
+	handleCheck(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(body)))
+	handleCheckStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/check/stream?domain=www.example.nope&type=txt&match=x", nil))
+
+	out := scrape(t)
+	for _, want := range []string{
+		`ripple_checks_started_total{type="A"} 1`,
+		`ripple_checks_started_total{type="TXT"} 1`,
+		`ripple_checks_finished_total{type="A",outcome="complete"} 1`,
+		`ripple_checks_finished_total{type="TXT",outcome="error"} 1`,
+		"ripple_checks_in_flight 0",
+		`ripple_propagation_seconds_count{type="A"} 1`,
+		`ripple_server_propagation_seconds_bucket{type="A",role="authoritative",le="1"} 2`,
+		`ripple_server_propagation_seconds_count{type="A",role="resolver"} 2`,
+		`ripple_dns_queries_total{server="192.0.2.11",rcode="NOERROR"} `,
+		`ripple_dns_queries_total{server="192.0.2.1",rcode="NXDOMAIN"} `,
+		`ripple_dns_query_duration_seconds_bucket{server="192.0.2.53",rcode="NOERROR",le="+Inf"} `,
+		"# TYPE ripple_dns_query_duration_seconds histogram",
+		"# TYPE ripple_limiter_queries_total counter",
+		"ripple_delegation_cache_misses_total ",
+	} {
+		if !strings.Contains(out, "\n"+want) {
+			t.Errorf("metrics lack %q", want)
+		}
+	}
+}
+
+func TestMetricsQueries(t *testing.T) {
+	m := newMetrics()
+	ctx := context.Background()
+	m.observeQuery(ctx, dnspkg.QueryEvent{Server: "192.0.2.11:53", Rcode: "NOERROR", RTT: 3 * time.Millisecond})
+	m.observeQuery(ctx, dnspkg.QueryEvent{Server: "192.0.2.11:53", Rcode: "NOERROR", RTT: 2 * time.Second})
+	m.observeQuery(ctx, dnspkg.QueryEvent{Server: "[2001:db8::1]:5353", Err: errors.New("i/o timeout")})
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	m.observeQuery(cancelled, dnspkg.QueryEvent{Server: "192.0.2.11:53", Err: context.Canceled})
+
+	var b strings.Builder
+	m.queryErrors.write(&b)
+	m.queryDuration.write(&b)
+	want := `# HELP ripple_dns_query_errors_total DNS queries that got no response, by server.
+# TYPE ripple_dns_query_errors_total counter
+ripple_dns_query_errors_total{server="[2001:db8::1]:5353"} 1
+# HELP ripple_dns_query_duration_seconds Round-trip time of answered DNS queries, by server and response code.
+# TYPE ripple_dns_query_duration_seconds histogram
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.001"} 0
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.0025"} 0
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.005"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.01"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.025"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.05"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.1"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.25"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="0.5"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="1"} 1
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="2.5"} 2
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="5"} 2
+ripple_dns_query_duration_seconds_bucket{server="192.0.2.11",rcode="NOERROR",le="+Inf"} 2
+ripple_dns_query_duration_seconds_sum{server="192.0.2.11",rcode="NOERROR"} 2.003
+ripple_dns_query_duration_seconds_count{server="192.0.2.11",rcode="NOERROR"} 2
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestMetricsServerLimit(t *testing.T) {
+	m := newMetrics()
+	for i := range maxMetricServers + 5 {
+		m.observeQuery(context.Background(), dnspkg.QueryEvent{Server: fmt.Sprintf("10.0.%d.%d:53", i/256, i%256), Rcode: "NOERROR"})
+	}
+	if n := len(m.queries.series); n != maxMetricServers+1 {
+		t.Errorf("%d series, want %d", n, maxMetricServers+1)
+	}
+	if s := m.queries.with("other", "NOERROR"); s.value != 5 {
+		t.Errorf("other: %v queries, want 5", s.value)
+	}
+}
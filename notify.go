@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// Webhook events
+const (
+	eventComplete = "complete" // every server has the record
+	eventTimeout  = "timeout"  // the check ended before every server had it
+	eventError    = "error"    // the check could not run
+	eventDrift    = "drift"    // a watched record changed status
+)
+
+var webhookEvents = []string{eventComplete, eventTimeout, eventError, eventDrift}
+
+// Webhook payload formats
+var webhookFormats = []string{"json", "slack", "teams"}
+
+// Notification is the body of a webhook in the json format.
+type Notification struct {
+	Event      string                `json:"event"`
+	Time       string                `json:"time"`
+	Domain     string                `json:"domain"`
+	RecordType string                `json:"record_type"`
+	Match      string                `json:"match"`
+	Status     string                `json:"status"` // propagated, partial, authoritative_timeout or an error code; for drift the record's new status
+	Summary    string                `json:"summary"`
+	Elapsed    string                `json:"elapsed,omitempty"`
+	Error      string                `json:"error,omitempty"`
+	Check      *dnspkg.CheckResponse `json:"check,omitempty"`
+	Watch      *WatchState           `json:"watch,omitempty"`
+}
+
+// notifier delivers notifications to the configured webhooks and to the
+// callback URLs of checks. Deliveries run in the background, each retried
+// with exponential backoff on network errors, 429 and 5xx responses.
+type notifier struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	secret   string
+	targets  []dnspkg.WebhookTarget
+	wg       sync.WaitGroup
+
+	// Callback URLs come from API clients, so they only reach the listed
+	// hosts, only at public addresses, and are signed with their own secret.
+	callbackClient *http.Client
+	callbackHosts  []string
+	callbackSecret string
+}
+
+// notifications is the notifier of server mode; nil sends nothing.
+var notifications *notifier
+
+// newNotifier validates the webhooks of cfg.
+func newNotifier(cfg dnspkg.WebhooksConfig) (*notifier, error) {
+	backoff, err := time.ParseDuration(cfg.Backoff)
+	if err != nil || backoff < 0 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "webhooks: invalid backoff %q", cfg.Backoff)
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil || timeout <= 0 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "webhooks: invalid timeout %q", cfg.Timeout)
+	}
+	for i, t := range cfg.Targets {
+		if err := checkCallbackURL(t.URL); err != nil {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "webhook %d: %v", i+1, err)
+		}
+		if t.Format != "" && !slices.Contains(webhookFormats, t.Format) {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "webhook %d: unknown format %q: expected %s", i+1, t.Format, strings.Join(webhookFormats, ", "))
+		}
+		for _, e := range t.Events {
+			if !slices.Contains(webhookEvents, e) {
+				return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "webhook %d: unknown event %q: expected %s", i+1, e, strings.Join(webhookEvents, ", "))
+			}
+		}
+	}
+	for _, h := range cfg.CallbackHosts {
+		if strings.TrimPrefix(h, "*.") == "" || strings.ContainsAny(h, "/:@") {
+			return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "webhooks: invalid callback host %q: expected a name or *.domain", h)
+		}
+	}
+	control := refusePrivate
+	if cfg.AllowPrivateCallbacks {
+		control = nil
+	}
+	return &notifier{
+		client:   &http.Client{Timeout: timeout},
+		attempts: max(cfg.Attempts, 1),
+		backoff:  backoff,
+		secret:   cfg.Secret,
+		targets:  cfg.Targets,
+		callbackClient: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: timeout, Control: control}).DialContext,
+				TLSHandshakeTimeout: timeout,
+			},
+			// A redirect could leave the allowed hosts
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		callbackHosts:  cfg.CallbackHosts,
+		callbackSecret: cfg.CallbackSecret,
+	}, nil
+}
+
+// checkCallbackURL checks that a webhook URL is an absolute http(s) URL.
+func checkCallbackURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid callback URL %q: expected http or https", s)
+	}
+	return nil
+}
+
+// checkCallback checks that a check may call s back: callbacks are off
+// unless webhooks.callback_hosts lists the host of s.
+func (n *notifier) checkCallback(s string) error {
+	if err := checkCallbackURL(s); err != nil {
+		return err
+	}
+	if n == nil || len(n.callbackHosts) == 0 {
+		return dnspkg.Errorf(dnspkg.ErrInvalidInput, "callback URLs are disabled: list the allowed hosts in webhooks.callback_hosts")
+	}
+	u, _ := url.Parse(s)
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	for _, h := range n.callbackHosts {
+		h = strings.ToLower(h)
+		if host == h || (strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:])) {
+			return nil
+		}
+	}
+	return dnspkg.Errorf(dnspkg.ErrInvalidInput, "callback host %q is not in webhooks.callback_hosts", u.Hostname())
+}
+
+// nonPublic lists the special-purpose ranges net/netip does not classify.
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which may map to any of the above
+}
+
+// refusePrivate is the dialer Control of callbacks, unless
+// webhooks.allow_private_callbacks is set. It runs on the address actually
+// dialed, after name resolution and for every redirect or retry, so a
+// callback host that resolves to loopback, private, link-local or other
+// non-public addresses cannot reach ripple's own network.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || slices.ContainsFunc(nonPublic, func(p netip.Prefix) bool { return p.Contains(ip) }) {
+		return fmt.Errorf("callback to non-public address %s refused", ip)
+	}
+	return nil
+}
+
+// notify sends note to every target that wants its event, and to callback
+// if it is set, in the json format.
+func (n *notifier) notify(note Notification, callback string) {
+	if n == nil {
+		return
+	}
+	for _, t := range n.targets {
+		if len(t.Events) > 0 && !slices.Contains(t.Events, note.Event) {
+			continue
+		}
+		secret := t.Secret
+		if secret == "" {
+			secret = n.secret
+		}
+		n.send(n.client, t.URL, t.Format, secret, note)
+	}
+	if callback != "" {
+		n.send(n.callbackClient, callback, "json", n.callbackSecret, note)
+	}
+}
+
+// send delivers note to url in the background.
+func (n *notifier) send(client *http.Client, url, format, secret string, note Notification) {
+	body, err := webhookBody(format, note)
+	if err != nil {
+		log.Printf("webhook %s: %v", redactURL(url), err)
+		return
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	delivery := hex.EncodeToString(id)
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		for attempt := 1; ; attempt++ {
+			retry, err := n.post(client, url, secret, note.Event, delivery, body)
+			if err == nil {
+				return
+			}
+			if !retry || attempt >= n.attempts {
+				log.Printf("webhook %s: %s %s not delivered after %d attempts: %v", redactURL(url), note.Event, note.Domain, attempt, err)
+				return
+			}
+			time.Sleep(n.backoff << (attempt - 1))
+		}
+	}()
+}
+
+// post makes one delivery attempt and reports whether a failure is worth
+// retrying.
+func (n *notifier) post(client *http.Client, url, secret, event, delivery string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ripple")
+	req.Header.Set("X-Ripple-Event", event)
+	req.Header.Set("X-Ripple-Delivery", delivery)
+	req.Header.Set("X-Ripple-Timestamp", timestamp)
+	if secret != "" {
+		req.Header.Set("X-Ripple-Signature", webhookSignature(secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", resp.Status)
+	}
+	return false, fmt.Errorf("status %s", resp.Status)
+}
+
+// wait blocks until every delivery in progress is done.
+func (n *notifier) wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// webhookSignature signs a delivery the way receivers verify it: HMAC-SHA256
+// of the timestamp, a dot and the body, so a captured request cannot be
+// replayed later with a new timestamp.
+func webhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// redactURL keeps the secrets in webhook paths, such as Slack's, out of logs.
+func redactURL(s string) string {
+	if u, err := url.Parse(s); err == nil {
+		return u.Scheme + "://" + u.Host
+	}
+	return "webhook"
+}
+
+// webhookBody renders note in a payload format.
+func webhookBody(format string, note Notification) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return json.Marshal(note)
+	case "slack":
+		return json.Marshal(map[string]string{"text": "*ripple* " + note.Summary})
+	case "teams":
+		return json.Marshal(teamsMessage(note))
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// teamsMessage wraps note in an Adaptive Card, as Teams workflow webhooks
+// expect.
+func teamsMessage(note Notification) any {
+	color := "Attention"
+	switch {
+	case note.Event == eventComplete || note.Status == watchOK:
+		color = "Good"
+	case note.Event == eventTimeout:
+		color = "Warning"
+	}
+	type textBlock struct {
+		Type   string `json:"type"`
+		Text   string `json:"text"`
+		Weight string `json:"weight,omitempty"`
+		Color  string `json:"color,omitempty"`
+		Wrap   bool   `json:"wrap"`
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []any{map[string]any{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []textBlock{
+					{Type: "TextBlock", Text: "ripple: " + note.Event, Weight: "Bolder", Color: color},
+					{Type: "TextBlock", Text: note.Summary, Wrap: true},
+				},
+			},
+		}},
+	}
+}
+
+// checkStatus classifies a finished check as propagated, partial or
+// authoritative_timeout.
+func checkStatus(response *dnspkg.CheckResponse) string {
+	switch {
+	case response.AllPropagated:
+		return "propagated"
+	case slices.ContainsFunc(response.Authoritative, func(s dnspkg.ServerStatus) bool { return !s.Propagated }):
+		return codeAuthoritativeTimeout
+	}
+	return codePartial
+}
+
+// checkNotification describes the outcome of an API check.
+func checkNotification(domain, recordType, match string, response *dnspkg.CheckResponse, err error, elapsed time.Duration) Notification {
+	note := Notification{
+		Time:       time.Now().UTC().Format(time.RFC3339),
+		Domain:     strings.TrimSuffix(domain, "."),
+		RecordType: strings.ToUpper(recordType),
+		Match:      match,
+		Elapsed:    elapsed.Round(time.Millisecond).String(),
+		Check:      response,
+	}
+	check := note.Domain + " " + note.RecordType + " " + match
+	if err != nil {
+		note.Event, note.Status, note.Error = eventError, dnspkg.ErrorCode(err), err.Error()
+		note.Summary = fmt.Sprintf("%s failed: %v", check, err)
+		return note
+	}
+
+	note.Status = checkStatus(response)
+	servers := slices.Concat(response.Authoritative, response.Resolvers)
+	found := 0
+	for _, s := range servers {
+		if s.Propagated {
+			found++
+		}
+	}
+	if note.Status == "propagated" {
+		note.Event = eventComplete
+		note.Summary = fmt.Sprintf("%s propagated to %d/%d servers in %s", check, found, len(servers), dnspkg.FormatDuration(elapsed))
+	} else {
+		note.Event = eventTimeout
+		note.Summary = fmt.Sprintf("%s timed out (%s) after %s: %d/%d servers have it", check, note.Status, dnspkg.FormatDuration(elapsed), found, len(servers))
+	}
+	return note
+}
+
+// driftNotification describes a watched record that changed status.
+func driftNotification(st WatchState, change, detail string) Notification {
+	summary := fmt.Sprintf("%s %s %s %s", st.Domain, st.RecordType, st.Match, change)
+	if detail != "" {
+		summary += ": " + detail
+	}
+	return Notification{
+		Event:      eventDrift,
+		Time:       st.CheckedAt,
+		Domain:     st.Domain,
+		RecordType: st.RecordType,
+		Match:      st.Match,
+		Status:     st.Status,
+		Summary:    summary,
+		Error:      st.Error,
+		Watch:      &st,
+	}
+}
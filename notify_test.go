@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	dnspkg "ripple/dns"
+)
+
+// receiver is a local webhook endpoint that records the deliveries it gets
+// and answers with the next of its statuses, then 200.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.requests = append(rc.requests, r)
+		rc.bodies = append(rc.bodies, string(body))
+		if len(rc.statuses) > 0 {
+			w.WriteHeader(rc.statuses[0])
+			rc.statuses = rc.statuses[1:]
+		}
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+// useNotifier installs a notifier for cfg with fast retries for one test.
+func useNotifier(t *testing.T, cfg dnspkg.WebhooksConfig) *notifier {
+	t.Helper()
+	cfg.Backoff, cfg.Timeout = "1ms", "2s"
+	n, err := newNotifier(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	notifications = n
+	t.Cleanup(func() { notifications = nil })
+	return n
+}
+
+func TestNotifierRetries(t *testing.T) {
+	rc := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	n := useNotifier(t, dnspkg.WebhooksConfig{Attempts: 3, Secret: "s3cret", Targets: []dnspkg.WebhookTarget{{URL: rc.URL + "/hook"}}})
+
+	note := Notification{Event: eventComplete, Domain: "www.example.test", Summary: "done"}
+	n.notify(note, "")
+	n.wait()
+
+	if len(rc.requests) != 3 {
+		t.Fatalf("%d attempts, want 3", len(rc.requests))
+	}
+	delivery := rc.requests[0].Header.Get("X-Ripple-Delivery")
+	for i, r := range rc.requests {
+		timestamp := r.Header.Get("X-Ripple-Timestamp")
+		if want := webhookSignature("s3cret", timestamp, []byte(rc.bodies[i])); r.Header.Get("X-Ripple-Signature") != want {
+			t.Errorf("attempt %d: signature %q, want %q", i+1, r.Header.Get("X-Ripple-Signature"), want)
+		}
+		if r.Header.Get("X-Ripple-Delivery") != delivery || r.Header.Get("X-Ripple-Event") != eventComplete || r.URL.Path != "/hook" {
+			t.Errorf("attempt %d: headers %v", i+1, r.Header)
+		}
+	}
+	var got Notification
+	if err := json.Unmarshal([]byte(rc.bodies[2]), &got); err != nil || got.Domain != "www.example.test" || got.Summary != "done" {
+		t.Errorf("body %s: %v", rc.bodies[2], err)
+	}
+}
+
+func TestNotifierGivesUp(t *testing.T) {
+	for _, tt := range []struct {
+		statuses []int
+		want     int
+	}{
+		{[]int{http.StatusBadRequest}, 1}, // not worth retrying
+		{[]int{500, 502, 503, 504, 500}, 4},
+	} {
+		rc := newReceiver(t, tt.statuses...)
+		n := useNotifier(t, dnspkg.WebhooksConfig{Attempts: 4, Targets: []dnspkg.WebhookTarget{{URL: rc.URL}}})
+		n.notify(Notification{Event: eventError}, "")
+		n.wait()
+		if len(rc.requests) != tt.want {
+			t.Errorf("statuses %v: %d attempts, want %d", tt.statuses, len(rc.requests), tt.want)
+		}
+		if rc.requests[0].Header.Get("X-Ripple-Signature") != "" {
+			t.Errorf("signed without a secret")
+		}
+	}
+}
+
+func TestNotifierTargets(t *testing.T) {
+	slack, teams, drift := newReceiver(t), newReceiver(t), newReceiver(t)
+	n := useNotifier(t, dnspkg.WebhooksConfig{Targets: []dnspkg.WebhookTarget{
+		{URL: slack.URL, Format: "slack"},
+		{URL: teams.URL, Format: "teams", Events: []string{eventTimeout}},
+		{URL: drift.URL, Events: []string{eventDrift}},
+	}})
+	n.notify(Notification{Event: eventTimeout, Summary: "www.example.test A 192.0.2.10 timed out"}, "")
+	n.wait()
+
+	if len(slack.bodies) != 1 || slack.bodies[0] != `{"text":"*ripple* www.example.test A 192.0.2.10 timed out"}` {
+		t.Errorf("slack: %q", slack.bodies)
+	}
+	var card struct {
+		Type        string
+		Attachments []struct {
+			ContentType string
+			Content     struct {
+				Type string
+				Body []struct{ Text, Color string }
+			}
+		}
+	}
+	if len(teams.bodies) != 1 {
+		t.Fatalf("teams: %q", teams.bodies)
+	}
+	if err := json.Unmarshal([]byte(teams.bodies[0]), &card); err != nil {
+		t.Fatal(err)
+	}
+	if card.Type != "message" || card.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" ||
+		card.Attachments[0].Content.Body[0].Color != "Warning" || card.Attachments[0].Content.Body[1].Text != "www.example.test A 192.0.2.10 timed out" {
+		t.Errorf("teams: %s", teams.bodies[0])
+	}
+	if len(drift.bodies) != 0 {
+		t.Errorf("drift target got %q", drift.bodies)
+	}
+}
+
+func TestNewNotifierErrors(t *testing.T) {
+	for _, cfg := range []dnspkg.WebhooksConfig{
+		{Backoff: "soon", Timeout: "1s"},
+		{Backoff: "1s", Timeout: "0s"},
+		{Backoff: "1s", Timeout: "1s", Targets: []dnspkg.WebhookTarget{{URL: "ftp://example.com"}}},
+		{Backoff: "1s", Timeout: "1s", Targets: []dnspkg.WebhookTarget{{URL: "https://example.com", Format: "discord"}}},
+		{Backoff: "1s", Timeout: "1s", Targets: []dnspkg.WebhookTarget{{URL: "https://example.com", Events: []string{"started"}}}},
+	} {
+		if _, err := newNotifier(cfg); dnspkg.ErrorCode(err) != "invalid_input" {
+			t.Errorf("newNotifier(%+v) = %v", cfg, err)
+		}
+	}
+}
+
+func TestCheckCallback(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	rc := newReceiver(t)
+	// The receiver is on loopback, which callbacks only reach when allowed
+	n := useNotifier(t, dnspkg.WebhooksConfig{Secret: "s3cret", CallbackHosts: []string{"127.0.0.1"}, CallbackSecret: "cb-s3cret", AllowPrivateCallbacks: true})
+
+	body := `{"domain":"www.example.test","type":"a","match":"192.0.2.10","timeout":"2s","retry":"50ms","callback_url":"` + rc.URL + `"}`
+	handleCheck(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(body)))
+	n.wait()
+	if len(rc.bodies) != 1 {
+		t.Fatalf("%d deliveries", len(rc.bodies))
+	}
+	var note Notification
+	if err := json.Unmarshal([]byte(rc.bodies[0]), &note); err != nil {
+		t.Fatal(err)
+	}
+	if note.Event != eventComplete || note.Status != "propagated" || note.Check == nil || !strings.HasPrefix(note.Summary, "www.example.test A 192.0.2.10 propagated to 4/4 servers in") {
+		t.Errorf("complete: %+v", note)
+	}
+	r := rc.requests[0]
+	if want := webhookSignature("cb-s3cret", r.Header.Get("X-Ripple-Timestamp"), []byte(rc.bodies[0])); r.Header.Get("X-Ripple-Signature") != want {
+		t.Errorf("callback signature %q, want %q", r.Header.Get("X-Ripple-Signature"), want)
+	}
+
+	for _, tt := range []struct {
+		name string
+		req  *http.Request
+	}{
+		{"host not listed", httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(`{"domain":"www.example.test","match":"x","callback_url":"http://localhost/hook"}`))},
+		{"not http", httptest.NewRequest(http.MethodPost, "/check", strings.NewReader(`{"domain":"www.example.test","match":"x","callback_url":"file:///etc/passwd"}`))},
+		{"GET", httptest.NewRequest(http.MethodGet, "/check?domain=www.example.test&match=x&callback_url="+rc.URL, nil)},
+	} {
+		rec := httptest.NewRecorder()
+		handleCheck(rec, tt.req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", tt.name, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handleCheckStream(rec, httptest.NewRequest(http.MethodGet, "/check/stream?domain=www.example.test&match=x&callback_url="+rc.URL, nil))
+	if !strings.Contains(rec.Body.String(), "callback_url is only accepted") {
+		t.Errorf("stream with callback: %s", rec.Body.String())
+	}
+	n.wait()
+	if len(rc.bodies) != 1 {
+		t.Errorf("%d deliveries after refused callbacks", len(rc.bodies))
+	}
+}
+
+func TestCallbacksDisabled(t *testing.T) {
+	for _, n := range []*notifier{nil, useNotifier(t, dnspkg.WebhooksConfig{Secret: "s3cret"})} {
+		if err := n.checkCallback("https://ci.example.com/hook"); dnspkg.ErrorCode(err) != "invalid_input" {
+			t.Errorf("callback without callback_hosts: %v", err)
+		}
+	}
+	n := useNotifier(t, dnspkg.WebhooksConfig{CallbackHosts: []string{"ci.example.com", "*.hooks.example.com"}})
+	for url, ok := range map[string]bool{
+		"https://ci.example.com/hook":       true,
+		"https://CI.example.com./hook":      true,
+		"http://a.hooks.example.com:8080/x": true,
+		"https://hooks.example.com/x":       false,
+		"https://evilci.example.com/hook":   false,
+		"https://ci.example.com.evil.net/":  false,
+		"https://xhooks.example.com/hook":   false,
+		"ftp://ci.example.com/hook":         false,
+	} {
+		if err := n.checkCallback(url); (err == nil) != ok {
+			t.Errorf("checkCallback(%q) = %v", url, err)
+		}
+	}
+}
+
+func TestCallbackPrivateAddress(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"8.8.8.8:443":           true,
+		"[2606:4700::1111]:443": true,
+		"127.0.0.1:80":          false,
+		"10.0.0.1:80":           false,
+		"172.16.5.4:80":         false,
+		"192.168.1.1:80":        false,
+		"169.254.169.254:80":    false,
+		"100.64.0.1:80":         false,
+		"0.0.0.0:80":            false,
+		"[::1]:80":              false,
+		"[fe80::1]:80":          false,
+		"[fd00::1]:80":          false,
+		"[::ffff:127.0.0.1]:80": false,
+	} {
+		if err := refusePrivate("tcp4", addr, nil); (err == nil) != ok {
+			t.Errorf("refusePrivate(%s) = %v", addr, err)
+		}
+	}
+
+	// The guard is in the dialer, so it holds for whatever a listed host
+	// resolves to
+	rc := newReceiver(t)
+	n := useNotifier(t, dnspkg.WebhooksConfig{Attempts: 1, CallbackHosts: []string{"127.0.0.1"}})
+	if err := n.checkCallback(rc.URL); err != nil {
+		t.Fatal(err)
+	}
+	n.notify(Notification{Event: eventComplete}, rc.URL)
+	n.wait()
+	if len(rc.requests) != 0 {
+		t.Errorf("callback reached a loopback address")
+	}
+}
+
+func TestDriftNotification(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	rc := newReceiver(t)
+	n := useNotifier(t, dnspkg.WebhooksConfig{Targets: []dnspkg.WebhookTarget{{URL: rc.URL, Events: []string{eventDrift}}}})
+	m, err := newDriftMonitor(dnspkg.WatchConfig{Interval: "1m", Records: []dnspkg.WatchRecord{{Domain: "www.example.test", Type: "a", Match: "192.0.2.10"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.check(context.Background(), m.records[0])
+	h.NS[0].Remove(www, dnspkg.ParseRecordType("a"))
+	m.check(context.Background(), m.records[0])
+	n.wait()
+
+	if len(rc.bodies) != 1 {
+		t.Fatalf("deliveries %q", rc.bodies)
+	}
+	var note Notification
+	if err := json.Unmarshal([]byte(rc.bodies[0]), &note); err != nil {
+		t.Fatal(err)
+	}
+	if note.Event != eventDrift || note.Status != watchDrifted || note.Watch == nil || note.Watch.Status != watchDrifted ||
+		note.Summary != "www.example.test A 192.0.2.10 drifted: authoritative 192.0.2.11 without the record" {
+		t.Errorf("drift: %+v", note)
+	}
+}
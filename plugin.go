@@ -0,0 +1,237 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// Exit codes of the plugin command, as Nagios, Icinga and Sensu read them.
+const (
+	pluginOK       = 0
+	pluginWarning  = 1
+	pluginCritical = 2
+	pluginUnknown  = 3
+)
+
+var pluginStates = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// pluginMinimum is how many servers of a kind must have the record: a count,
+// or a percentage of the servers queried.
+type pluginMinimum struct {
+	value   int
+	percent bool
+}
+
+// parsePluginMinimum reads a count such as 3, a percentage such as 80%, or
+// "all".
+func parsePluginMinimum(s string) (pluginMinimum, error) {
+	if s == "all" {
+		return pluginMinimum{value: 100, percent: true}, nil
+	}
+	number, percent := strings.CutSuffix(s, "%")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 || (percent && n > 100) {
+		return pluginMinimum{}, dnspkg.Errorf(dnspkg.ErrInvalidInput, "invalid threshold %q: expected a count, a percentage or all", s)
+	}
+	return pluginMinimum{value: n, percent: percent}, nil
+}
+
+// of returns the minimum for total servers, rounding percentages up.
+func (m pluginMinimum) of(total int) int {
+	if m.percent {
+		return (total*m.value + 99) / 100
+	}
+	return m.value
+}
+
+// rangeOf formats the minimum as a Nagios threshold range: alert below it.
+func (m pluginMinimum) rangeOf(total int) string {
+	return strconv.Itoa(m.of(total)) + ":"
+}
+
+// pluginThresholds are the minimum counts of servers with the record below
+// which the plugin warns or goes critical.
+type pluginThresholds struct {
+	authWarning, authCritical           pluginMinimum
+	resolversWarning, resolversCritical pluginMinimum
+}
+
+// pluginFlags are the threshold flags of the plugin command, as given.
+type pluginFlags struct {
+	authWarning, authCritical           string
+	resolversWarning, resolversCritical string
+}
+
+func (f *pluginFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.authWarning, "auth-warning", "all", "authoritative servers that must have the record, or WARNING (count, percentage or all)")
+	fs.StringVar(&f.authCritical, "auth-critical", "all", "authoritative servers that must have the record, or CRITICAL")
+	fs.StringVar(&f.resolversWarning, "resolvers-warning", "all", "resolvers that must have the record, or WARNING")
+	fs.StringVar(&f.resolversCritical, "resolvers-critical", "0", "resolvers that must have the record, or CRITICAL")
+}
+
+func (f *pluginFlags) thresholds() (*pluginThresholds, error) {
+	var t pluginThresholds
+	for _, p := range []struct {
+		min  *pluginMinimum
+		flag string
+	}{
+		{&t.authWarning, f.authWarning},
+		{&t.authCritical, f.authCritical},
+		{&t.resolversWarning, f.resolversWarning},
+		{&t.resolversCritical, f.resolversCritical},
+	} {
+		m, err := parsePluginMinimum(p.flag)
+		if err != nil {
+			return nil, err
+		}
+		*p.min = m
+	}
+	return &t, nil
+}
+
+func setupPlugin(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	var c checkFlags
+	var pf pluginFlags
+	g.register(fs)
+	fs.StringVar(&c.recordType, "t", "", "record type (a, aaaa, txt, cname, mx, ns, ptr)")
+	fs.StringVar(&c.match, "m", "", "match value in record")
+	fs.StringVar(&c.duration, "w", "10s", "how long to wait for answers")
+	pf.register(fs)
+	return func(args []string) int {
+		out := &pluginOutput{w: os.Stdout}
+		thresholds, err := pf.thresholds()
+		if err != nil {
+			out.fail(err)
+			return out.status
+		}
+		out.thresholds = thresholds
+		if err := g.needDomain(args); err != nil {
+			out.fail(err)
+			return out.status
+		}
+		s, err := g.setup()
+		if err != nil {
+			out.fail(err)
+			return out.status
+		}
+		defer s.close()
+
+		opts, err := c.options(firstArg(args), s.replay)
+		if err != nil {
+			out.fail(err)
+			return out.status
+		}
+		opts.once = true
+		s.writeHeader(opts)
+		s.primeRoots()
+		runCLI(out, opts)
+		return out.status
+	}
+}
+
+// pluginOutput prints the outcome of a single round of queries the way
+// monitoring plugins do: a status line, then perfdata after a "|". The
+// plugin's exit code is left in status.
+type pluginOutput struct {
+	w          io.Writer
+	thresholds *pluginThresholds
+	check      string // domain and record type, for error lines
+	status     int
+}
+
+func (o *pluginOutput) begin(domain string, opts *checkOptions) {
+	o.check = strings.TrimSuffix(domain, ".") + " " + strings.ToUpper(opts.recordType)
+}
+func (o *pluginOutput) discovered(servers []*dnspkg.ResolverStatus)             {}
+func (o *pluginOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {}
+func (o *pluginOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool)        {}
+
+func (o *pluginOutput) finish(r *checkResult) {
+	t := o.thresholds
+	var parts, missing, perfdata []string
+	evaluate := func(kind string, servers []dnspkg.ServerStatus, warning, critical pluginMinimum) {
+		found := 0
+		for _, s := range servers {
+			if s.Propagated {
+				found++
+			} else {
+				missing = append(missing, s.Name)
+			}
+		}
+		switch total := len(servers); {
+		case found < critical.of(total):
+			o.status = max(o.status, pluginCritical)
+		case found < warning.of(total):
+			o.status = max(o.status, pluginWarning)
+		}
+		parts = append(parts, fmt.Sprintf("%d/%d %s", found, len(servers), kind))
+		perfdata = append(perfdata, fmt.Sprintf("%s=%d;%s;%s;0;%d", kind, found, warning.rangeOf(len(servers)), critical.rangeOf(len(servers)), len(servers)))
+	}
+	evaluate("authoritative", r.response.Authoritative, t.authWarning, t.authCritical)
+	evaluate("resolvers", r.response.Resolvers, t.resolversWarning, t.resolversCritical)
+
+	// The time to match is how long the slowest server with the record took
+	servers := slices.Concat(r.authServers, r.resolvers)
+	var matched time.Duration
+	for _, s := range servers {
+		if s.Propagated {
+			matched = max(matched, s.FoundAt)
+		}
+	}
+	perfdata = append(perfdata, "time_to_match="+perfSeconds(matched)+";;;0")
+	for _, s := range servers {
+		if rtt, ok := dnspkg.ServerRTT(s); ok {
+			perfdata = append(perfdata, perfLabel("rtt_"+s.Name)+"="+perfSeconds(rtt)+";;;0")
+		}
+	}
+
+	resp := r.response
+	line := fmt.Sprintf("%s %s %s: %s", resp.Domain, resp.RecordType, resp.Match, strings.Join(parts, ", "))
+	if o.status != pluginOK && len(missing) > 0 {
+		line += " (missing: " + strings.Join(missing, ", ") + ")"
+	}
+	fmt.Fprintf(o.w, "RIPPLE %s - %s | %s\n", pluginStates[o.status], pluginEscape(line), strings.Join(perfdata, " "))
+}
+
+// fail reports a check that could not run. A record whose name does not
+// resolve is critical; bad arguments and failures of ripple itself are
+// unknown.
+func (o *pluginOutput) fail(err error) {
+	o.status = pluginUnknown
+	if exitCodeFor(err) == exitDiscovery {
+		o.status = pluginCritical
+	}
+	line := err.Error()
+	if o.check != "" {
+		line = o.check + ": " + line
+	}
+	fmt.Fprintf(o.w, "RIPPLE %s - %s: %s\n", pluginStates[o.status], dnspkg.ErrorCode(err), pluginEscape(line))
+}
+
+// perfSeconds formats a duration as a perfdata value in seconds.
+func perfSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64) + "s"
+}
+
+// perfLabel quotes a perfdata label if it has characters that would split it.
+func perfLabel(label string) string {
+	label = strings.ReplaceAll(label, "'", "")
+	if strings.ContainsAny(label, " =") {
+		return "'" + label + "'"
+	}
+	return label
+}
+
+// pluginEscape keeps text from being read as the start of perfdata.
+func pluginEscape(s string) string {
+	return strings.ReplaceAll(s, "|", "/")
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+
+	"ripple/dns/dnstest"
+)
+
+func TestPluginOutput(t *testing.T) {
+	bothNS := func(h *dnstest.Hierarchy) {
+		for _, ns := range h.NS {
+			ns.Add(www + " 300 IN A 192.0.2.10")
+		}
+	}
+	tests := []struct {
+		name    string
+		publish func(h *dnstest.Hierarchy)
+		domain  string
+		args    []string // threshold flags
+		want    int
+		line    string // status line up to the perfdata
+		perf    []string
+	}{
+		{
+			name:    "ok",
+			publish: func(h *dnstest.Hierarchy) { h.Publish(www + " 300 IN A 192.0.2.10") },
+			want:    pluginOK,
+			line:    "RIPPLE OK - www.example.test A 192.0.2.10: 2/2 authoritative, 2/2 resolvers",
+			perf:    []string{"authoritative=2;2:;2:;0;2", "resolvers=2;2:;0:;0;2", "time_to_match=", "rtt_192.0.2.11=", "rtt_192.0.2.53="},
+		},
+		{
+			name:    "resolvers behind",
+			publish: bothNS,
+			want:    pluginWarning,
+			line:    "RIPPLE WARNING - www.example.test A 192.0.2.10: 2/2 authoritative, 0/2 resolvers (missing: 192.0.2.53, local)",
+			perf:    []string{"resolvers=0;2:;0:;0;2"},
+		},
+		{
+			name:    "resolvers threshold",
+			publish: bothNS,
+			args:    []string{"-resolvers-warning", "50%", "-resolvers-critical", "1"},
+			want:    pluginCritical,
+			line:    "RIPPLE CRITICAL - www.example.test A 192.0.2.10: 2/2 authoritative, 0/2 resolvers (missing: 192.0.2.53, local)",
+			perf:    []string{"resolvers=0;1:;1:;0;2"},
+		},
+		{
+			name:    "authoritative missing",
+			publish: func(h *dnstest.Hierarchy) { h.NS[0].Add(www + " 300 IN A 192.0.2.10") },
+			args:    []string{"-resolvers-warning", "0"},
+			want:    pluginCritical,
+			line:    "RIPPLE CRITICAL - www.example.test A 192.0.2.10: 1/2 authoritative, 0/2 resolvers (missing: 192.0.2.12, 192.0.2.53, local)",
+			perf:    []string{"authoritative=1;2:;2:;0;2"},
+		},
+		{
+			name:    "authoritative warning",
+			publish: func(h *dnstest.Hierarchy) { h.NS[0].Add(www + " 300 IN A 192.0.2.10") },
+			args:    []string{"-auth-critical", "1", "-resolvers-warning", "0"},
+			want:    pluginWarning,
+			line:    "RIPPLE WARNING - www.example.test A 192.0.2.10: 1/2 authoritative, 0/2 resolvers (missing: 192.0.2.12, 192.0.2.53, local)",
+		},
+		{
+			name:   "nxdomain",
+			domain: "www.example.nope",
+			want:   pluginCritical,
+			line:   "RIPPLE CRITICAL - nxdomain: www.example.nope A: failed to find authoritative servers:",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := useHierarchy(t)
+			if tt.publish != nil {
+				tt.publish(h)
+			}
+			domain := "www.example.test"
+			if tt.domain != "" {
+				domain = tt.domain
+			}
+			var flags pluginFlags
+			fs := flag.NewFlagSet("plugin", flag.ContinueOnError)
+			flags.register(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			thresholds, err := flags.thresholds()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var b strings.Builder
+			out := &pluginOutput{w: &b, thresholds: thresholds}
+			runCLI(out, &checkOptions{domain: domain, recordType: "a", match: "192.0.2.10", duration: time.Second, once: true})
+			if out.status != tt.want {
+				t.Errorf("status %d, want %d", out.status, tt.want)
+			}
+			line, perf, _ := strings.Cut(strings.TrimSpace(b.String()), " | ")
+			if !strings.HasPrefix(line, tt.line) || strings.Count(b.String(), "\n") != 1 {
+				t.Errorf("got %q, want %q", b.String(), tt.line)
+			}
+			for _, p := range tt.perf {
+				if !strings.Contains(" "+perf, " "+p) {
+					t.Errorf("perfdata %q lacks %q", perf, p)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePluginMinimum(t *testing.T) {
+	for _, tt := range []struct {
+		in    string
+		total int
+		want  int
+	}{
+		{"all", 7, 7},
+		{"3", 7, 3},
+		{"50%", 7, 4},
+		{"0%", 7, 0},
+		{"100%", 0, 0},
+	} {
+		m, err := parsePluginMinimum(tt.in)
+		if err != nil || m.of(tt.total) != tt.want {
+			t.Errorf("parsePluginMinimum(%q).of(%d) = %d, %v; want %d", tt.in, tt.total, m.of(tt.total), err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "-1", "101%", "most"} {
+		if _, err := parsePluginMinimum(in); err == nil {
+			t.Errorf("parsePluginMinimum(%q) succeeded", in)
+		}
+	}
+}
+
+func TestPluginBadFlags(t *testing.T) {
+	if code := run([]string{"plugin", "-no-such-flag"}); code != pluginUnknown {
+		t.Errorf("exit code %d, want %d", code, pluginUnknown)
+	}
+	if code := run([]string{"plugin", "-resolvers-warning", "most", "-t", "a", "-m", "x", "example.com"}); code != pluginUnknown {
+		t.Errorf("exit code %d, want %d", code, pluginUnknown)
+	}
+}
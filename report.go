@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// Test report formats for -o and the Accept header
+const (
+	reportJUnit = "junit"
+	reportTAP   = "tap"
+)
+
+// reportMediaTypes maps the media types a client may accept to a report
+// format. Only types naming a report count: browsers accept application/xml
+// and */* too, and get JSON.
+var reportMediaTypes = map[string]string{
+	"application/junit+xml": reportJUnit,
+	"text/x-tap":            reportTAP,
+	"text/tap":              reportTAP,
+}
+
+// reportContentTypes are the Content-Type headers of the report formats.
+var reportContentTypes = map[string]string{
+	reportJUnit: "application/xml; charset=utf-8",
+	reportTAP:   "text/plain; charset=utf-8",
+}
+
+// acceptedReport returns the report format the request accepts, or "" for
+// the default JSON.
+func acceptedReport(r *http.Request) string {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		params := strings.Split(part, ";")
+		format, ok := reportMediaTypes[strings.ToLower(strings.TrimSpace(params[0]))]
+		// q=0 marks a type as not acceptable
+		if ok && !slices.ContainsFunc(params[1:], func(p string) bool {
+			q, isQ := strings.CutPrefix(strings.TrimSpace(p), "q=")
+			return isQ && strings.Trim(q, "0.") == ""
+		}) {
+			return format
+		}
+	}
+	return ""
+}
+
+// writeReport renders checks as a test report in format.
+func writeReport(w io.Writer, format string, results []BatchResult) error {
+	switch format {
+	case reportJUnit:
+		return writeJUnit(w, results)
+	case reportTAP:
+		return writeTAP(w, results)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// reportOutput renders a CLI check as a test report once it is over.
+type reportOutput struct {
+	w      io.Writer
+	format string
+	result BatchResult
+}
+
+func (o *reportOutput) begin(domain string, opts *checkOptions) {
+	o.result = BatchResult{Domain: strings.TrimSuffix(domain, "."), Type: strings.ToUpper(opts.recordType), Match: opts.match}
+}
+func (o *reportOutput) discovered(servers []*dnspkg.ResolverStatus)             {}
+func (o *reportOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {}
+func (o *reportOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool)        {}
+
+func (o *reportOutput) finish(r *checkResult) {
+	o.result.record(r, nil)
+	writeReport(o.w, o.format, []BatchResult{o.result})
+}
+
+func (o *reportOutput) fail(err error) {
+	o.result.record(nil, err)
+	writeReport(o.w, o.format, []BatchResult{o.result})
+}
+
+// reportCase is a server of a check as a test case: it passes if the server
+// has the record.
+type reportCase struct {
+	kind   string // authoritative or resolver
+	server dnspkg.ServerStatus
+	time   time.Duration // until the record was found, or the whole check
+}
+
+func (c reportCase) name() string {
+	if c.server.Address != c.server.Name {
+		return c.server.Name + " (" + c.server.Address + ")"
+	}
+	return c.server.Name
+}
+
+// failure describes why the server did not pass.
+func (c reportCase) failure(r *BatchResult) string {
+	return fmt.Sprintf("%s %s does not have %s %s", c.kind, c.name(), r.Type, r.Match)
+}
+
+// details describes how the server passed.
+func (c reportCase) details() string {
+	s := c.server
+	text := "found " + s.Record + " after " + s.FoundAfter
+	if len(s.Chain) > 0 {
+		text += " via " + dnspkg.FormatChain(s.Chain)
+	}
+	if s.Wildcard {
+		text += " [" + dnspkg.WildcardNote + "]"
+	}
+	return text
+}
+
+// reportCases lists the servers of a check. The authoritative servers are
+// the whole check if it stopped before polling resolvers.
+func reportCases(r *BatchResult) []reportCase {
+	if r.Response == nil {
+		return nil
+	}
+	var cases []reportCase
+	add := func(kind string, servers []dnspkg.ServerStatus) {
+		for _, s := range servers {
+			c := reportCase{kind: kind, server: s, time: r.elapsed}
+			if d, err := time.ParseDuration(s.FoundAfter); err == nil && s.Propagated {
+				c.time = d
+			}
+			cases = append(cases, c)
+		}
+	}
+	add("authoritative", r.Response.Authoritative)
+	add("resolver", r.Response.Resolvers)
+	return cases
+}
+
+// suiteName identifies a check in reports.
+func suiteName(r *BatchResult) string {
+	return strings.TrimSpace(r.Domain + " " + r.Type + " " + r.Match)
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// writeJUnit renders checks as JUnit XML: a test suite per check with a test
+// case per server. A check that could not run is a single case in error.
+func writeJUnit(w io.Writer, results []BatchResult) error {
+	report := junitSuites{Name: "ripple"}
+	var total time.Duration
+	for i := range results {
+		r := &results[i]
+		suite := junitSuite{Name: suiteName(r), Time: seconds(r.elapsed)}
+		if r.Response != nil {
+			suite.Timestamp = r.Response.CheckedAt
+		}
+		classname := strings.ToLower(r.Domain + "." + r.Type)
+
+		if r.Error != "" {
+			suite.Errors = 1
+			suite.Cases = append(suite.Cases, junitCase{
+				Name:      "discover authoritative servers",
+				Classname: classname,
+				Time:      seconds(0),
+				Error:     &junitProblem{Message: r.Error, Type: r.Status, Text: r.Error},
+			})
+		}
+		for _, c := range reportCases(r) {
+			tc := junitCase{Name: c.name(), Classname: classname + "." + c.kind, Time: seconds(c.time)}
+			if c.server.Propagated {
+				tc.SystemOut = c.details()
+			} else {
+				suite.Failures++
+				text := c.failure(r)
+				if r.Elapsed != "" {
+					text += " after " + r.Elapsed
+				}
+				tc.Failure = &junitProblem{Message: c.failure(r), Type: r.Status, Text: text}
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+		suite.Tests = len(suite.Cases)
+
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		total = max(total, r.elapsed)
+		report.Suites = append(report.Suites, suite)
+	}
+	report.Time = seconds(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeTAP renders checks in the Test Anything Protocol, a test point per
+// server with YAML diagnostics for failures. A check that could not run is a
+// single failed test point.
+func writeTAP(w io.Writer, results []BatchResult) error {
+	var b strings.Builder
+	n := 0
+	for i := range results {
+		r := &results[i]
+		fmt.Fprintf(&b, "# %s\n", suiteName(r))
+		if r.Error != "" {
+			n++
+			fmt.Fprintf(&b, "not ok %d - %s: %s\n", n, tapEscape(suiteName(r)), r.Status)
+			writeTAPDiagnostics(&b, "code", r.Status, "message", r.Error)
+		}
+		for _, c := range reportCases(r) {
+			n++
+			if c.server.Propagated {
+				fmt.Fprintf(&b, "ok %d - %s %s: %s\n", n, c.kind, c.name(), tapEscape(c.details()))
+			} else {
+				fmt.Fprintf(&b, "not ok %d - %s\n", n, tapEscape(c.failure(r)))
+				writeTAPDiagnostics(&b, "status", r.Status, "elapsed", r.Elapsed)
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "TAP version 13\n1..%d\n%s", n, b.String())
+	return err
+}
+
+// tapEscape escapes the character that would start a directive in a test
+// point description.
+func tapEscape(s string) string {
+	return strings.ReplaceAll(s, "#", `\#`)
+}
+
+// writeTAPDiagnostics writes the non-empty key/value pairs as a YAML block.
+func writeTAPDiagnostics(b *strings.Builder, pairs ...string) {
+	b.WriteString("  ---\n")
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			fmt.Fprintf(b, "  %s: %q\n", pairs[i], pairs[i+1])
+		}
+	}
+	b.WriteString("  ...\n")
+}
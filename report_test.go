@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+func TestCLIOutputJUnit(t *testing.T) {
+	h := useHierarchy(t)
+	h.NS[0].Add(www + " 300 IN A 192.0.2.10")
+
+	var buf bytes.Buffer
+	opts := &checkOptions{domain: "www.example.test", recordType: "a", match: "192.0.2.10", retry: 50 * time.Millisecond, duration: 300 * time.Millisecond, once: true}
+	if code := runCLI(newCheckOutput("junit", &buf), opts); code != exitNotAuthoritative {
+		t.Errorf("exit code %d, want %d", code, exitNotAuthoritative)
+	}
+
+	var report junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("decoding %s: %v", buf.String(), err)
+	}
+	if report.Tests != 4 || report.Failures != 3 || len(report.Suites) != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	suite := report.Suites[0]
+	if suite.Name != "www.example.test A 192.0.2.10" || suite.Timestamp == "" {
+		t.Errorf("suite %+v", suite)
+	}
+	// The servers come in the order of discovery
+	cases := map[string]junitCase{}
+	for _, c := range suite.Cases {
+		cases[c.Name] = c
+	}
+	if c := cases["192.0.2.11"]; c.Classname != "www.example.test.a.authoritative" || c.Failure != nil || !strings.HasPrefix(c.SystemOut, "found A 192.0.2.10 after") {
+		t.Errorf("passing case %+v", c)
+	}
+	if c := cases["192.0.2.12"]; c.Failure == nil || c.Failure.Type != "authoritative_timeout" || c.Failure.Message != "authoritative 192.0.2.12 does not have A 192.0.2.10" {
+		t.Errorf("failing case %+v", c)
+	}
+}
+
+func TestReportError(t *testing.T) {
+	err := dnspkg.Errorf(dnspkg.ErrNXDomain, "www.example.nope does not exist")
+	results := []BatchResult{{Domain: "www.example.nope", Type: "A", Match: "x"}}
+	results[0].record(nil, err)
+
+	var buf bytes.Buffer
+	writeJUnit(&buf, results)
+	var report junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Errors != 1 || report.Suites[0].Cases[0].Error.Type != "nxdomain" {
+		t.Errorf("junit %s", buf.String())
+	}
+
+	buf.Reset()
+	writeTAP(&buf, results)
+	want := `TAP version 13
+1..1
+# www.example.nope A x
+not ok 1 - www.example.nope A x: nxdomain
+  ---
+  code: "nxdomain"
+  message: "www.example.nope does not exist"
+  ...
+`
+	if buf.String() != want {
+		t.Errorf("tap:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteTAP(t *testing.T) {
+	results := []BatchResult{{
+		Domain: "www.example.test", Type: "TXT", Match: "#1", Status: "partial", Elapsed: "1m",
+		Response: &dnspkg.CheckResponse{
+			Authoritative: []dnspkg.ServerStatus{{Name: "ns1.example.test.", Address: "192.0.2.11", Propagated: true, FoundAfter: "2s", Record: `"#1"`}},
+			Resolvers:     []dnspkg.ServerStatus{{Name: "Google", Address: "8.8.8.8"}},
+		},
+	}}
+	var buf bytes.Buffer
+	writeTAP(&buf, results)
+	want := `TAP version 13
+1..2
+# www.example.test TXT #1
+ok 1 - authoritative ns1.example.test. (192.0.2.11): found "\#1" after 2s
+not ok 2 - resolver Google (8.8.8.8) does not have TXT \#1
+  ---
+  status: "partial"
+  elapsed: "1m"
+  ...
+`
+	if buf.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestHandleCheckReport(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	tests := []struct {
+		accept, query string
+		status        int
+		contentType   string
+		prefix        string
+	}{
+		{"application/junit+xml", "domain=www.example.test&match=192.0.2.10", http.StatusOK, "application/xml", xml.Header + "<testsuites"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*", "domain=www.example.test&match=192.0.2.10", http.StatusOK, "application/json", "{"},
+		{"text/x-tap;q=0, application/json", "domain=www.example.test&match=192.0.2.10", http.StatusOK, "application/json", "{"},
+		{"text/html;q=0.9, text/x-tap", "domain=www.example.test&match=192.0.2.10", http.StatusOK, "text/plain", "TAP version 13\n1..4\n"},
+		{"text/x-tap", "domain=www.example.nope&match=x", http.StatusNotFound, "text/plain", "TAP version 13\n1..1\n"},
+		{"application/json", "domain=www.example.test&match=192.0.2.10", http.StatusOK, "application/json", "{"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/check?timeout=2s&retry=50ms&"+tt.query, nil)
+		req.Header.Set("Accept", tt.accept)
+		rec := httptest.NewRecorder()
+		handleCheck(rec, req)
+
+		if rec.Code != tt.status || !strings.HasPrefix(rec.Header().Get("Content-Type"), tt.contentType) || !strings.HasPrefix(rec.Body.String(), tt.prefix) {
+			t.Errorf("Accept %s: status %d, %s:\n%s", tt.accept, rec.Code, rec.Header().Get("Content-Type"), rec.Body)
+		}
+	}
+}
+
+func TestWriteCheckReportTime(t *testing.T) {
+	response := &dnspkg.CheckResponse{
+		Authoritative: []dnspkg.ServerStatus{{Name: "ns1.example.test.", Address: "192.0.2.11", Propagated: true, FoundAfter: "2s"}},
+		Resolvers:     []dnspkg.ServerStatus{{Name: "Google", Address: "8.8.8.8"}},
+	}
+	rec := httptest.NewRecorder()
+	writeCheckReport(rec, reportJUnit, "www.example.test.", "a", "192.0.2.10", response, nil, 90*time.Second)
+
+	var report junitSuites
+	if err := xml.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	suite := report.Suites[0]
+	if report.Time != "90.000" || suite.Time != "90.000" || suite.Cases[0].Time != "2.000" || suite.Cases[1].Time != "90.000" {
+		t.Errorf("times: %s", rec.Body)
+	}
+}
+
+func TestBatchReport(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	var buf bytes.Buffer
+	c := &checkFlags{recordType: "a", retry: "50ms", duration: "2s", output: "junit"}
+	runBatchOutput(&buf, []batchEntry{{Domain: "www.example.test", Match: "192.0.2.10"}, {Domain: "www.example.nope", Match: "x"}}, c, 2)
+
+	var report junitSuites
+	if err := xml.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatal(errors.Join(err, errors.New(buf.String())))
+	}
+	if len(report.Suites) != 2 || report.Tests != 5 || report.Errors != 1 || report.Failures != 0 {
+		t.Errorf("report %+v", report)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// tableOutput is the text output for terminals: while the authoritative
+// servers and resolvers are polled together, it redraws a table of every
+// server in place instead of printing a line per event.
+type tableOutput struct {
+	*textOutput
+
+	mu    sync.Mutex
+	start time.Time
+	rows  []tableRow
+	index map[*dnspkg.ResolverStatus]int
+	width int // of the server column
+	drawn int // lines written by the last draw
+	final bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+type tableRow struct {
+	kind, server string
+	propagated   bool
+	found        time.Duration
+	result       string
+}
+
+func newTableOutput(w io.Writer) *tableOutput {
+	return &tableOutput{textOutput: &textOutput{w: w}}
+}
+
+// isTerminal reports whether f is an interactive terminal that understands
+// cursor movement.
+func isTerminal(f *os.File) bool {
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func (t *tableOutput) polling(authServers, resolvers []*dnspkg.ResolverStatus) {
+	t.textOutput.polling(authServers, resolvers)
+	if authServers == nil || resolvers == nil {
+		return // sequential phases print line by line
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.start = time.Now()
+	t.index = make(map[*dnspkg.ResolverStatus]int)
+	add := func(kind string, servers []*dnspkg.ResolverStatus) {
+		for _, s := range servers {
+			t.index[s] = len(t.rows)
+			t.rows = append(t.rows, tableRow{kind: kind, server: s.Name})
+			t.width = max(t.width, len(s.Name))
+		}
+	}
+	add("authoritative", authServers)
+	add("resolver", resolvers)
+	t.draw()
+
+	// Keep the waiting times current between events
+	t.stop, t.done = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.mu.Lock()
+				t.draw()
+				t.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (t *tableOutput) propagated(s *dnspkg.ResolverStatus, isAuth bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	i, ok := t.index[s]
+	if !ok {
+		t.textOutput.propagated(s, isAuth)
+		return
+	}
+	row := &t.rows[i]
+	row.propagated = true
+	row.found = s.FoundAt
+	row.result = s.Record + resultNote(s)
+	t.draw()
+}
+
+func (t *tableOutput) finish(r *checkResult) {
+	if t.stop == nil {
+		t.textOutput.finish(r)
+		return
+	}
+	close(t.stop)
+	<-t.done
+
+	t.mu.Lock()
+	t.final = true
+	t.draw()
+	t.mu.Unlock()
+	fmt.Fprintf(t.w, "\n%s\n", verdict(r))
+}
+
+// draw rewrites the table over the previous one. Callers must hold t.mu.
+func (t *tableOutput) draw() {
+	var b strings.Builder
+	if t.drawn > 0 {
+		fmt.Fprintf(&b, "\033[%dA", t.drawn)
+	}
+	for _, row := range t.rows {
+		status := fmt.Sprintf("waiting %s", dnspkg.FormatDuration(time.Since(t.start)))
+		switch {
+		case row.propagated:
+			status = fmt.Sprintf("%-7s %s", dnspkg.FormatDuration(row.found), row.result)
+		case t.final:
+			status = "NOT propagated"
+		}
+		mark := "·"
+		if row.propagated {
+			mark = "✓"
+		}
+		fmt.Fprintf(&b, "\033[2K %s %-13s  %-*s  %s\n", mark, row.kind, t.width, row.server, status)
+	}
+	t.drawn = len(t.rows)
+	io.WriteString(t.w, b.String())
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+func setupTrace(fs *flag.FlagSet) func([]string) int {
+	var g globalOptions
+	g.register(fs)
+	recordType := fs.String("t", "", "record type to ask the authoritative servers for (default from config or a)")
+	output := fs.String("o", "text", "output format: text, json")
+	wait := fs.Duration("w", 30*time.Second, "how long the trace may take")
+	return func(args []string) int {
+		if *output != "text" && *output != "json" {
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "unknown output format %q", *output))
+		}
+		if len(args) == 0 {
+			return fail(dnspkg.Errorf(dnspkg.ErrInvalidInput, "domain is required"))
+		}
+		s, err := g.setup()
+		if err != nil {
+			return fail(err)
+		}
+		defer s.close()
+
+		typ := *recordType
+		if typ == "" {
+			typ = config.Defaults.RecordType
+		}
+		s.primeRoots()
+		ctx, cancel := context.WithTimeout(context.Background(), *wait)
+		defer cancel()
+		return runTrace(ctx, os.Stdout, *output, args[0], typ)
+	}
+}
+
+// runTrace traces the delegations of domain and prints the trace in format,
+// text or json. It returns exitDiscovery if the walk failed.
+func runTrace(ctx context.Context, w io.Writer, format, domain, recordType string) int {
+	dnsType := dnspkg.ParseRecordType(recordType)
+	if dnsType == 0 {
+		return fail(dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type %q", recordType))
+	}
+	name, err := dnspkg.PrepareName(domain, dnsType)
+	if err != nil {
+		return fail(err)
+	}
+
+	tr, err := dnspkg.TraceDelegation(ctx, name, dnsType, config.Roots())
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(tr)
+	} else {
+		dnspkg.PrintTrace(w, tr)
+	}
+	if err != nil {
+		return exitCodeFor(err)
+	}
+	return exitPropagated
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// Span kinds and status codes of OTLP
+const (
+	spanInternal = 1
+	spanServer   = 2
+	spanClient   = 3
+
+	statusError = 2
+)
+
+// Export batching: spans are sent every exportInterval, or as soon as
+// exportBatch are waiting; beyond maxQueuedSpans new spans are dropped.
+const (
+	exportInterval = 5 * time.Second
+	exportBatch    = 512
+	maxQueuedSpans = 8192
+)
+
+// spanContext identifies a span across processes, as a W3C traceparent
+// header does.
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type spanContextKey struct{}
+
+func spanContextFrom(ctx context.Context) (spanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(spanContext)
+	return sc, ok
+}
+
+// parseTraceparent reads a traceparent header. Versions after 00 may add
+// fields, which are ignored.
+func parseTraceparent(h string) (spanContext, bool) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil || sc.traceID == [16]byte{} {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil || sc.spanID == [8]byte{} {
+		return sc, false
+	}
+	sc.sampled = flags[0]&1 == 1
+	return sc, true
+}
+
+// propagateTrace continues the trace of an incoming request: spans started
+// for it become children of the span in its traceparent header.
+func propagateTrace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sc, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			r = r.WithContext(context.WithValue(r.Context(), spanContextKey{}, sc))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// span is a span being recorded. A nil span records nothing, so callers need
+// not check whether tracing is on.
+type span struct {
+	exporter *spanExporter
+	sc       spanContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+
+	mu    sync.Mutex
+	attrs map[string]string
+}
+
+// set adds an attribute.
+func (s *span) set(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs[key] = value
+}
+
+// end finishes the span, with an error status if err is set, and queues it
+// for export.
+func (s *span) end(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exporter.queue(s.otlp(time.Now(), err))
+}
+
+// spanExporter sends the spans of server mode to an OTLP/HTTP collector, in
+// the JSON encoding so no protobuf code is needed.
+type spanExporter struct {
+	url     string
+	service string
+	headers map[string]string
+	ratio   float64
+	client  *http.Client
+
+	mu      sync.Mutex
+	queued  []otlpSpan
+	dropped int
+	kick    chan struct{}
+}
+
+// tracing is the span exporter of server mode, nil without a tracing
+// endpoint.
+var tracing *spanExporter
+
+// newSpanExporter validates cfg. It returns nil without an endpoint.
+func newSpanExporter(cfg dnspkg.TracingConfig) (*spanExporter, error) {
+	if cfg.Endpoint == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "tracing: invalid endpoint %q: expected http or https", cfg.Endpoint)
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "tracing: sample_ratio %v not between 0 and 1", cfg.SampleRatio)
+	}
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil || timeout <= 0 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "tracing: invalid timeout %q", cfg.Timeout)
+	}
+	return &spanExporter{
+		url:     u.String(),
+		service: cfg.ServiceName,
+		headers: cfg.Headers,
+		ratio:   cfg.SampleRatio,
+		client:  &http.Client{Timeout: timeout},
+		kick:    make(chan struct{}, 1),
+	}, nil
+}
+
+// start starts a span under the span in ctx, or a new trace if there is
+// none, and returns a context carrying it. Traces ripple starts are sampled
+// at the configured ratio; others follow the sampled flag of their parent.
+func (e *spanExporter) start(ctx context.Context, name string, kind int, attrs map[string]string) (context.Context, *span) {
+	if e == nil {
+		return ctx, nil
+	}
+	parent, ok := spanContextFrom(ctx)
+	sc := spanContext{traceID: parent.traceID, sampled: parent.sampled}
+	if !ok {
+		putRandom(sc.traceID[:])
+		sc.sampled = rand.Float64() < e.ratio
+	}
+	putRandom(sc.spanID[:])
+	ctx = context.WithValue(ctx, spanContextKey{}, sc)
+	if !sc.sampled {
+		return ctx, nil
+	}
+	s := &span{exporter: e, sc: sc, parentID: parent.spanID, name: name, kind: kind, start: time.Now(), attrs: make(map[string]string, len(attrs))}
+	for k, v := range attrs {
+		s.attrs[k] = v
+	}
+	return ctx, s
+}
+
+func putRandom(b []byte) {
+	for i := range b {
+		b[i] = byte(rand.Uint32())
+	}
+}
+
+// checkSpanAttributes describes a check on its span.
+func checkSpanAttributes(domain, recordType, match string) map[string]string {
+	return map[string]string{
+		"ripple.domain":      strings.TrimSuffix(domain, "."),
+		"ripple.record_type": strings.ToUpper(recordType),
+		"ripple.match":       match,
+	}
+}
+
+// startDiscoverySpan is the dnspkg.SpanFunc of server mode.
+func (e *spanExporter) startDiscoverySpan(ctx context.Context, name string, attrs map[string]string) (context.Context, func(error)) {
+	ctx, s := e.start(ctx, name, spanInternal, attrs)
+	return ctx, s.end
+}
+
+// observeQuery records a query as a client span under the span of its
+// context. Queries outside any trace are not recorded.
+func (e *spanExporter) observeQuery(ctx context.Context, q dnspkg.QueryEvent) {
+	if _, ok := spanContextFrom(ctx); !ok || e == nil {
+		return
+	}
+	attrs := map[string]string{
+		"dns.server":        strings.TrimSuffix(q.Server, ":53"),
+		"dns.question.name": q.Name,
+		"dns.qtype":         q.TypeName(),
+	}
+	if q.Rcode != "" {
+		attrs["dns.rcode"] = q.Rcode
+	}
+	if q.Transport != "" {
+		attrs["network.transport"] = q.Transport
+	}
+	_, s := e.start(ctx, "dns "+q.TypeName(), spanClient, attrs)
+	if s == nil {
+		return
+	}
+	now := time.Now()
+	s.start = now.Add(-q.RTT)
+	e.queue(s.otlp(now, q.Err))
+}
+
+// queue adds a finished span to the next export.
+func (e *spanExporter) queue(s otlpSpan) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queued) >= maxQueuedSpans {
+		e.dropped++
+		return
+	}
+	e.queued = append(e.queued, s)
+	if len(e.queued) >= exportBatch {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run exports the queued spans in batches until ctx is done, then exports
+// what is left.
+func (e *spanExporter) run(ctx context.Context) {
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.flush()
+			return
+		case <-ticker.C:
+		case <-e.kick:
+		}
+		e.flush()
+	}
+}
+
+// flush exports the queued spans now. Spans the collector does not take are
+// dropped rather than retried, so a collector outage cannot hold memory.
+func (e *spanExporter) flush() {
+	e.mu.Lock()
+	spans, dropped := e.queued, e.dropped
+	e.queued, e.dropped = nil, 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("tracing: dropped %d spans over the queue limit", dropped)
+	}
+	for len(spans) > 0 {
+		n := min(len(spans), exportBatch)
+		if err := e.export(spans[:n]); err != nil {
+			log.Printf("tracing: %d spans not exported: %v", n, err)
+		}
+		spans = spans[n:]
+	}
+}
+
+// export posts one batch of spans.
+func (e *spanExporter) export(spans []otlpSpan) error {
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]string{"service.name": e.service})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "ripple"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ripple")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of ExportTraceServiceRequest: IDs are hex and
+// times are decimal strings of Unix nanoseconds.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// otlp encodes the span as ended at end. Callers hold s.mu if the span may
+// still be shared.
+func (s *span) otlp(end time.Time, err error) otlpSpan {
+	o := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        otlpAttributes(s.attrs),
+	}
+	if s.parentID != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		o.Status = otlpStatus{Code: statusError, Message: err.Error()}
+	}
+	return o
+}
+
+func otlpAttributes(attrs map[string]string) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(attrs))
+	for _, k := range slices.Sorted(maps.Keys(attrs)) {
+		a := otlpAttribute{Key: k}
+		a.Value.StringValue = attrs[k]
+		out = append(out, a)
+	}
+	return out
+}
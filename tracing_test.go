@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	dnspkg "ripple/dns"
+)
+
+// collectedSpan is a span as a collector decodes it from OTLP/HTTP JSON.
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string `json:"key"`
+		Value struct {
+			StringValue string `json:"stringValue"`
+		} `json:"value"`
+	} `json:"attributes"`
+	Status struct {
+		Code int `json:"code"`
+	} `json:"status"`
+}
+
+func (s collectedSpan) attr(key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value.StringValue
+		}
+	}
+	return ""
+}
+
+// collector is a local stand-in for an OpenTelemetry collector.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	services []string
+	spans    []collectedSpan
+}
+
+func newCollector(t *testing.T) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ResourceSpans []struct {
+				Resource struct {
+					Attributes []struct {
+						Key   string
+						Value struct{ StringValue string }
+					}
+				}
+				ScopeSpans []struct {
+					Spans []collectedSpan
+				}
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.requests = append(c.requests, r)
+		for _, rs := range req.ResourceSpans {
+			for _, a := range rs.Resource.Attributes {
+				if a.Key == "service.name" {
+					c.services = append(c.services, a.Value.StringValue)
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				c.spans = append(c.spans, ss.Spans...)
+			}
+		}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+// useTracing exports the spans of one test to endpoint.
+func useTracing(t *testing.T, cfg dnspkg.TracingConfig) *spanExporter {
+	t.Helper()
+	cfg.ServiceName, cfg.SampleRatio, cfg.Timeout = "ripple-test", 1, "2s"
+	e, err := newSpanExporter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracing = e
+	dnspkg.SetSpanFunc(e.startDiscoverySpan)
+	dnspkg.SetQueryObserver(e.observeQuery)
+	t.Cleanup(func() {
+		dnspkg.SetSpanFunc(nil)
+		dnspkg.SetQueryObserver(nil)
+		tracing = nil
+	})
+	return e
+}
+
+func TestCheckSpans(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	c := newCollector(t)
+	e := useTracing(t, dnspkg.TracingConfig{Endpoint: c.URL, Headers: map[string]string{"Authorization": "Bearer t0ken"}})
+
+	req := httptest.NewRequest(http.MethodGet, "/check?domain=www.example.test&type=a&match=192.0.2.10&timeout=2s&retry=50ms", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	propagateTrace(http.HandlerFunc(handleCheck)).ServeHTTP(httptest.NewRecorder(), req)
+	e.flush()
+
+	if len(c.requests) != 1 {
+		t.Fatalf("%d exports", len(c.requests))
+	}
+	if r := c.requests[0]; r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer t0ken" || c.services[0] != "ripple-test" {
+		t.Errorf("export %s %v %q", r.URL.Path, r.Header, c.services)
+	}
+
+	byName := map[string][]collectedSpan{}
+	for _, s := range c.spans {
+		if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %s in trace %s", s.Name, s.TraceID)
+		}
+		byName[s.Name] = append(byName[s.Name], s)
+	}
+	if len(byName["check"]) != 1 || len(byName["discover"]) != 1 || len(byName["zone_cut"]) != 3 {
+		t.Fatalf("spans %v", byName)
+	}
+	check, discover := byName["check"][0], byName["discover"][0]
+	if check.ParentSpanID != "00f067aa0ba902b7" || check.Kind != spanServer || check.attr("ripple.outcome") != eventComplete || check.attr("ripple.domain") != "www.example.test" {
+		t.Errorf("check span %+v", check)
+	}
+	if discover.ParentSpanID != check.SpanID {
+		t.Errorf("discover span under %s, want %s", discover.ParentSpanID, check.SpanID)
+	}
+	parents := map[string]string{check.SpanID: "check"}
+	for _, s := range byName["zone_cut"] {
+		if s.ParentSpanID != discover.SpanID {
+			t.Errorf("zone_cut %s under %s", s.attr("dns.zone"), s.ParentSpanID)
+		}
+		parents[s.SpanID] = "zone_cut " + s.attr("dns.zone")
+	}
+
+	// Every query is a client span under its zone cut, or under the check
+	// for the polling of the servers
+	under := map[string]bool{}
+	for _, s := range byName["dns A"] {
+		if s.Kind != spanClient || s.attr("dns.qtype") != "A" || s.attr("dns.rcode") == "" || s.attr("network.transport") != "udp" || s.attr("dns.server") == "" {
+			t.Errorf("query span %+v", s)
+		}
+		under[parents[s.ParentSpanID]] = true
+	}
+	for _, want := range []string{"check", "zone_cut .", "zone_cut test.", "zone_cut example.test."} {
+		if !under[want] {
+			t.Errorf("no query under %s: %v", want, under)
+		}
+	}
+	if len(byName["dns NS"]) == 0 {
+		t.Errorf("no NS query span")
+	}
+}
+
+func TestCheckSpansError(t *testing.T) {
+	useHierarchy(t)
+	c := newCollector(t)
+	e := useTracing(t, dnspkg.TracingConfig{Endpoint: c.URL + "/otlp/"})
+
+	handleCheckStream(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/check/stream?domain=www.example.nope&match=x", nil))
+	e.flush()
+
+	if len(c.requests) != 1 || c.requests[0].URL.Path != "/otlp/v1/traces" {
+		t.Fatalf("exports %v", c.requests)
+	}
+	for _, s := range c.spans {
+		if (s.Name == "check" || s.Name == "discover") && s.Status.Code != statusError {
+			t.Errorf("%s span status %d", s.Name, s.Status.Code)
+		}
+		if s.Name == "check" && (s.ParentSpanID != "" || s.attr("ripple.outcome") != eventError) {
+			t.Errorf("check span %+v", s)
+		}
+	}
+}
+
+func TestUnsampledTrace(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	c := newCollector(t)
+	e := useTracing(t, dnspkg.TracingConfig{Endpoint: c.URL})
+
+	req := httptest.NewRequest(http.MethodGet, "/check?domain=www.example.test&match=192.0.2.10&timeout=2s&retry=50ms", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	propagateTrace(http.HandlerFunc(handleCheck)).ServeHTTP(httptest.NewRecorder(), req)
+	e.flush()
+	if len(c.spans) != 0 {
+		t.Errorf("exported %d spans of an unsampled trace", len(c.spans))
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for _, tt := range []struct {
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09-extra", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01", false, false},
+		{"", false, false},
+	} {
+		sc, ok := parseTraceparent(tt.header)
+		if ok != tt.ok || sc.sampled != tt.sampled {
+			t.Errorf("parseTraceparent(%q) = %+v, %v", tt.header, sc, ok)
+		}
+	}
+}
+
+func TestNewSpanExporter(t *testing.T) {
+	if e, err := newSpanExporter(dnspkg.DefaultConfig.Tracing); e != nil || err != nil {
+		t.Errorf("default config: %v, %v", e, err)
+	}
+	for _, cfg := range []dnspkg.TracingConfig{
+		{Endpoint: "localhost:4318", SampleRatio: 1, Timeout: "1s"},
+		{Endpoint: "http://localhost:4318", SampleRatio: 1.5, Timeout: "1s"},
+		{Endpoint: "http://localhost:4318", SampleRatio: 1, Timeout: "soon"},
+	} {
+		if _, err := newSpanExporter(cfg); dnspkg.ErrorCode(err) != "invalid_input" {
+			t.Errorf("newSpanExporter(%+v) = %v", cfg, err)
+		}
+	}
+	e, err := newSpanExporter(dnspkg.TracingConfig{Endpoint: "https://otel.example.com/v1/traces", SampleRatio: 1, Timeout: "1s"})
+	if err != nil || e.url != "https://otel.example.com/v1/traces" {
+		t.Errorf("endpoint with path: %v, %v", e, err)
+	}
+}
@@ -3,13 +3,15 @@ package tui
 import (
 	"strings"
 
+	dnspkg "ripple/dns"
+
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
 
 // Record types available for selection.
-var recordTypes = []string{"A", "AAAA", "TXT", "CNAME", "MX", "NS"}
+var recordTypes = []string{"A", "AAAA", "TXT", "CNAME", "MX", "NS", "PTR"}
 
 // FormSubmitMsg is sent when the form is submitted with valid data.
 type FormSubmitMsg struct {
@@ -18,6 +20,7 @@ type FormSubmitMsg struct {
 	Match    string
 	Timeout  string
 	Retry    string
+	NoCache  bool // discover from the root, not the cached delegations
 }
 
 // formField identifies a form field by index.
@@ -161,6 +164,8 @@ func (m FormModel) submit() (FormModel, tea.Cmd) {
 
 	if domain == "" {
 		m.errors[fieldDomain] = "domain is required"
+	} else if _, err := dnspkg.PrepareName(domain, dnspkg.ParseRecordType(recordTypes[m.recordIdx])); err != nil {
+		m.errors[fieldDomain] = err.Error()
 	}
 	if matchVal == "" {
 		m.errors[fieldMatch] = "match value is required"
@@ -37,6 +37,7 @@ func (k resultsKeyMap) ShortHelp() []key.Binding {
 	} else {
 		bindings = append(bindings,
 			key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "new check")),
+			key.NewBinding(key.WithKeys("t"), key.WithHelp("t", "trace")),
 			key.NewBinding(key.WithKeys("h"), key.WithHelp("h", "history")),
 		)
 	}
@@ -56,6 +57,7 @@ func (k historyKeyMap) ShortHelp() []key.Binding {
 	return []key.Binding{
 		key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "view")),
 		key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "re-run")),
+		key.NewBinding(key.WithKeys("R"), key.WithHelp("R", "re-run fresh")),
 		key.NewBinding(key.WithKeys("up", "down"), key.WithHelp("↑/↓", "navigate")),
 		key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "help")),
 		key.NewBinding(key.WithKeys("q"), key.WithHelp("q", "quit")),
@@ -64,6 +66,20 @@ func (k historyKeyMap) ShortHelp() []key.Binding {
 
 func (k historyKeyMap) FullHelp() [][]key.Binding { return nil }
 
+type traceKeyMap struct{}
+
+func (k traceKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{
+		key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "back")),
+		key.NewBinding(key.WithKeys("up", "down"), key.WithHelp("↑/↓", "scroll")),
+		key.NewBinding(key.WithKeys("n"), key.WithHelp("n", "new check")),
+		key.NewBinding(key.WithKeys("?"), key.WithHelp("?", "help")),
+		key.NewBinding(key.WithKeys("q"), key.WithHelp("q", "quit")),
+	}
+}
+
+func (k traceKeyMap) FullHelp() [][]key.Binding { return nil }
+
 type configKeyMap struct {
 	editing bool
 }
@@ -96,6 +112,8 @@ func keyMapForView(view View, checkActive bool, configEditing bool) help.KeyMap
 		return historyKeyMap{}
 	case ViewConfig:
 		return configKeyMap{editing: configEditing}
+	case ViewTrace:
+		return traceKeyMap{}
 	default:
 		return formKeyMap{}
 	}
@@ -144,6 +162,14 @@ func renderHelpOverlay(width, height int) string {
 				{"ctrl+c", "Cancel (or quit if idle)"},
 				{"↑/↓ / j/k", "Scroll server list"},
 				{"pgup/pgdn", "Scroll page"},
+				{"t", "Trace the delegation"},
+			},
+		},
+		{
+			name: "Trace",
+			bindings: []struct{ key, desc string }{
+				{"esc", "Back to results"},
+				{"↑/↓ / j/k", "Scroll trace"},
 			},
 		},
 		{
@@ -152,6 +178,7 @@ func renderHelpOverlay(width, height int) string {
 				{"↑ / ↓", "Navigate list"},
 				{"enter", "View check details"},
 				{"r", "Re-run check"},
+				{"R", "Re-run with fresh delegation"},
 			},
 		},
 		{
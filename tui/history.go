@@ -76,6 +76,7 @@ type HistorySelectMsg struct {
 // HistoryRerunMsg is sent when the user wants to re-run a check from history.
 type HistoryRerunMsg struct {
 	Entry HistoryEntry
+	Fresh bool // walk the delegation from the root instead of the cache
 }
 
 // HistoryModel holds the state for the history view.
@@ -139,10 +140,11 @@ func (m HistoryModel) Update(msg tea.Msg) (HistoryModel, tea.Cmd) {
 					return HistorySelectMsg{Index: item.index}
 				}
 			}
-		case "r":
+		case "r", "R":
 			if item, ok := m.list.SelectedItem().(historyItem); ok {
+				fresh := msg.String() == "R"
 				return m, func() tea.Msg {
-					return HistoryRerunMsg{Entry: item.entry}
+					return HistoryRerunMsg{Entry: item.entry, Fresh: fresh}
 				}
 			}
 		}
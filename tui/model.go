@@ -19,6 +19,7 @@ const (
 	ViewResults
 	ViewHistory
 	ViewConfig
+	ViewTrace
 )
 
 // Model is the top-level Bubble Tea model that manages view state and routing.
@@ -33,6 +34,7 @@ type Model struct {
 	results     ResultsModel
 	history     HistoryModel
 	configView  ConfigModel
+	trace       TraceModel
 	historyData []HistoryEntry
 	help        help.Model
 	showHelp    bool // true when the full help overlay is visible
@@ -68,6 +70,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.results.SetSize(msg.Width, msg.Height)
 		m.history.SetSize(msg.Width, msg.Height)
 		m.configView.SetSize(msg.Width, msg.Height)
+		m.trace.SetSize(msg.Width, msg.Height)
 		return m, nil
 
 	case FormSubmitMsg:
@@ -88,6 +91,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.appendHistory(msg)
 		return m, cmd
 
+	case TraceCompleteMsg:
+		// Keep the trace even if the user left its view while it ran
+		var cmd tea.Cmd
+		m.trace, cmd = m.trace.Update(msg)
+		return m, cmd
+
 	case tea.KeyMsg:
 		// Help overlay: ? or esc dismisses, all other keys consumed
 		if m.showHelp {
@@ -107,6 +116,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+		// Esc in the trace view: back to the results it was started from
+		if msg.String() == "esc" && m.currentView == ViewTrace {
+			m.currentView = ViewResults
+			return m, nil
+		}
+
 		// Esc during active check: cancel the check (stay in TUI)
 		if msg.String() == "esc" && m.checkActive && m.currentView == ViewResults {
 			m.results.Cancel()
@@ -150,6 +165,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.configView = NewConfigModel(m.config, m.configPath)
 				m.configView.SetSize(m.width, m.height)
 				return m, nil
+			case "t":
+				if m.currentView == ViewResults && m.results.input != "" {
+					m.trace = NewTraceModel(m.results.input, m.results.recordType)
+					m.trace.SetSize(m.width, m.height)
+					m.currentView = ViewTrace
+					return m, m.trace.StartTrace(m.config)
+				}
 			}
 		}
 
@@ -168,6 +190,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			Match:   msg.Entry.Match,
 			Timeout: msg.Entry.Timeout,
 			Retry:   msg.Entry.Retry,
+			NoCache: msg.Fresh,
 		}
 		m.lastFormMsg = formMsg
 		m.results = NewResultsModel(formMsg)
@@ -193,6 +216,9 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case ViewConfig:
 		m.configView, cmd = m.configView.Update(msg)
 		return m, cmd
+	case ViewTrace:
+		m.trace, cmd = m.trace.Update(msg)
+		return m, cmd
 	}
 
 	return m, nil
@@ -231,6 +257,8 @@ func (m Model) View() string {
 		content = m.history.View()
 	case ViewConfig:
 		content = m.configView.View()
+	case ViewTrace:
+		content = m.trace.View()
 	}
 
 	configEditing := m.currentView == ViewConfig && m.configView.isEditing()
@@ -33,6 +33,8 @@ type ServerPropagatedMsg struct {
 	Propagated bool
 	FoundAt    time.Duration
 	Record     string
+	Chain      []string
+	Wildcard   bool
 	IsAuth     bool // true = authoritative, false = resolver
 }
 
@@ -56,6 +58,7 @@ type CheckErrorMsg struct {
 
 // ResultsModel holds the state for the results dashboard view.
 type ResultsModel struct {
+	input         string // domain as entered, for a trace
 	domain        string
 	recordType    string
 	match         string
@@ -83,6 +86,16 @@ type ResultsModel struct {
 	cancel   context.CancelFunc
 }
 
+// displayDomain shows the normalized name to check, with the ASCII form
+// alongside the Unicode one for internationalized names.
+func displayDomain(input, recordType string) string {
+	name, err := dnspkg.PrepareName(input, dnspkg.ParseRecordType(recordType))
+	if err != nil {
+		return input
+	}
+	return dnspkg.FormatName(name)
+}
+
 // NewResultsModel creates a results model from form submission data.
 func NewResultsModel(msg FormSubmitMsg) ResultsModel {
 	s := spinner.New(
@@ -90,7 +103,8 @@ func NewResultsModel(msg FormSubmitMsg) ResultsModel {
 		spinner.WithStyle(StatusYellow),
 	)
 	return ResultsModel{
-		domain:     msg.Domain,
+		input:      msg.Domain,
+		domain:     displayDomain(msg.Domain, msg.Type),
 		recordType: strings.ToUpper(msg.Type),
 		match:      msg.Match,
 		spinner:    s,
@@ -120,7 +134,11 @@ func (m *ResultsModel) StartCheck(cfg *dnspkg.Config, formMsg FormSubmitMsg) tea
 	m.checking = true
 	m.startTime = time.Now()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	base := context.Background()
+	if formMsg.NoCache {
+		base = dnspkg.WithoutDelegationCache(base)
+	}
+	ctx, cancel := context.WithCancel(base)
 	m.cancel = cancel
 
 	ch := m.updateCh
@@ -135,22 +153,28 @@ func (m *ResultsModel) StartCheck(cfg *dnspkg.Config, formMsg FormSubmitMsg) tea
 		retry = 5 * time.Second
 	}
 
-	domain := formMsg.Domain
-	if !strings.HasSuffix(domain, ".") {
-		domain = domain + "."
-	}
 	recordType := strings.ToLower(formMsg.Type)
 	match := formMsg.Match
 	dnsType := dnspkg.ParseRecordType(recordType)
+	domain, nameErr := dnspkg.PrepareName(formMsg.Domain, dnsType)
 
-	rootServers := cfg.RootServers
+	rootServers := cfg.Roots()
 	publicResolvers := cfg.PublicResolvers
+	sched := cfg.Schedule(retry)
 
 	go func() {
 		startTime := time.Now()
 
+		if nameErr != nil {
+			select {
+			case ch <- CheckErrorMsg{Err: nameErr}:
+			case <-ctx.Done():
+			}
+			return
+		}
+
 		// Find authoritative servers
-		authServers, err := dnspkg.FindAuthoritativeServers(domain, rootServers)
+		authServers, err := dnspkg.DiscoverAuthoritativeContext(ctx, domain, dnsType, rootServers)
 		if err != nil {
 			select {
 			case ch <- CheckErrorMsg{Err: fmt.Errorf("finding auth servers: %w", err)}:
@@ -171,19 +195,11 @@ func (m *ResultsModel) StartCheck(cfg *dnspkg.Config, formMsg FormSubmitMsg) tea
 		}
 
 		// Build resolver list
-		resolverPtrs := make([]*dnspkg.ResolverStatus, 0, len(publicResolvers)+1)
-		resolverStatuses := make([]dnspkg.ResolverStatus, 0, len(publicResolvers)+1)
-		for _, addr := range publicResolvers {
-			r := &dnspkg.ResolverStatus{
-				Name: strings.Split(addr, ":")[0],
-				Addr: addr,
-			}
-			resolverPtrs = append(resolverPtrs, r)
-			resolverStatuses = append(resolverStatuses, *r)
+		resolverPtrs := dnspkg.BuildResolvers(publicResolvers)
+		resolverStatuses := make([]dnspkg.ResolverStatus, len(resolverPtrs))
+		for i, r := range resolverPtrs {
+			resolverStatuses[i] = *r
 		}
-		localResolver := &dnspkg.ResolverStatus{Name: "local", Addr: ""}
-		resolverPtrs = append(resolverPtrs, localResolver)
-		resolverStatuses = append(resolverStatuses, *localResolver)
 
 		select {
 		case ch <- ResolverInitializedMsg{Resolvers: resolverStatuses}:
@@ -195,95 +211,43 @@ func (m *ResultsModel) StartCheck(cfg *dnspkg.Config, formMsg FormSubmitMsg) tea
 		timeoutCtx, timeoutCancel := context.WithTimeout(ctx, timeout)
 		defer timeoutCancel()
 
+		// Each server is polled on its own schedule; updates are sent as they happen.
 		var mu sync.Mutex
-		ticker := time.NewTicker(retry)
-		defer ticker.Stop()
-
-		for {
-			// Check authoritative servers
-			dnspkg.CheckAuthoritativeAllSilent(authServers, domain, dnsType, match, startTime, &mu)
-
-			// Send updates for auth servers
-			mu.Lock()
-			for _, s := range authServers {
-				if s.Propagated {
-					select {
-					case ch <- ServerPropagatedMsg{
-						Name:       s.Name,
-						Addr:       s.Addr,
-						Propagated: true,
-						FoundAt:    s.FoundAt,
-						Record:     s.Record,
-						IsAuth:     true,
-					}:
-					default: // non-blocking
-					}
-				}
-			}
-			mu.Unlock()
-
-			// Check resolvers
-			dnspkg.CheckResolverAllSilent(resolverPtrs, domain, recordType, match, startTime, &mu)
-
-			// Send updates for resolvers
-			mu.Lock()
-			for _, r := range resolverPtrs {
-				if r.Propagated {
-					select {
-					case ch <- ServerPropagatedMsg{
-						Name:       r.Name,
-						Addr:       r.Addr,
-						Propagated: true,
-						FoundAt:    r.FoundAt,
-						Record:     r.Record,
-						IsAuth:     false,
-					}:
-					default: // non-blocking
-					}
-				}
-			}
-
-			// Check if all propagated
-			allDone := true
-			for _, s := range authServers {
-				if !s.Propagated {
-					allDone = false
-					break
-				}
-			}
-			if allDone {
-				for _, r := range resolverPtrs {
-					if !r.Propagated {
-						allDone = false
-						break
-					}
-				}
-			}
-			mu.Unlock()
-
-			if allDone {
+		allDone := dnspkg.Watch(timeoutCtx, authServers, resolverPtrs,
+			dnspkg.AuthoritativeProbe(domain, dnsType, match, rootServers), dnspkg.ResolverProbe(domain, dnsType, match),
+			sched, startTime, &mu,
+			func(s *dnspkg.ResolverStatus, isAuth bool) {
 				select {
-				case ch <- CheckCompleteMsg{Elapsed: time.Since(startTime)}:
+				case ch <- ServerPropagatedMsg{
+					Name:       s.Name,
+					Addr:       s.Addr,
+					Propagated: true,
+					FoundAt:    s.FoundAt,
+					Record:     s.Record,
+					Chain:      s.Chain,
+					Wildcard:   s.Wildcard,
+					IsAuth:     isAuth,
+				}:
 				case <-ctx.Done():
 				}
-				return
-			}
+			})
 
+		if allDone {
 			select {
-			case <-timeoutCtx.Done():
-				if ctx.Err() != nil {
-					// Parent context cancelled (user cancellation)
-					return
-				}
-				// Timeout
-				select {
-				case ch <- CheckTimeoutMsg{Elapsed: time.Since(startTime)}:
-				case <-ctx.Done():
-				}
-				return
-			case <-ticker.C:
-				continue
+			case ch <- CheckCompleteMsg{Elapsed: time.Since(startTime)}:
+			case <-ctx.Done():
 			}
+			return
+		}
+
+		if ctx.Err() != nil {
+			// Parent context cancelled (user cancellation)
+			return
+		}
+		// Timeout
+		select {
+		case ch <- CheckTimeoutMsg{Elapsed: time.Since(startTime)}:
+		case <-ctx.Done():
 		}
 	}()
 
@@ -320,6 +284,8 @@ func (m ResultsModel) Update(msg tea.Msg) (ResultsModel, tea.Cmd) {
 					m.authoritative[i].Propagated = msg.Propagated
 					m.authoritative[i].FoundAt = msg.FoundAt
 					m.authoritative[i].Record = msg.Record
+					m.authoritative[i].Chain = msg.Chain
+					m.authoritative[i].Wildcard = msg.Wildcard
 				}
 			}
 		} else {
@@ -328,6 +294,8 @@ func (m ResultsModel) Update(msg tea.Msg) (ResultsModel, tea.Cmd) {
 					m.resolvers[i].Propagated = msg.Propagated
 					m.resolvers[i].FoundAt = msg.FoundAt
 					m.resolvers[i].Record = msg.Record
+					m.resolvers[i].Chain = msg.Chain
+					m.resolvers[i].Wildcard = msg.Wildcard
 				}
 			}
 		}
@@ -646,6 +614,12 @@ func (m ResultsModel) renderServerEntry(s dnspkg.ResolverStatus, panelWidth int)
 		statusIcon = StatusGreen.Render("✓")
 		timeStr = dnspkg.FormatDuration(s.FoundAt)
 		recordStr = s.Record
+		if len(s.Chain) > 0 {
+			recordStr += " via " + dnspkg.FormatChain(s.Chain)
+		}
+		if s.Wildcard {
+			recordStr += " [" + dnspkg.WildcardNote + "]"
+		}
 	} else if s.Record == "error" {
 		statusIcon = StatusRed.Render("✗")
 		timeStr = "-"
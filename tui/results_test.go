@@ -0,0 +1,93 @@
+package tui
+
+import (
+	"testing"
+	"time"
+
+	"ripple/dns/dnstest"
+
+	tea "github.com/charmbracelet/bubbletea"
+	mdns "github.com/miekg/dns"
+)
+
+const www = "www." + dnstest.Zone
+
+// runCheck starts a check on the results model and feeds it every update
+// until the check finishes.
+func runCheck(t *testing.T, m ResultsModel, start func() tea.Cmd) ResultsModel {
+	t.Helper()
+	start()
+
+	deadline := time.After(5 * time.Second)
+	for !m.done {
+		select {
+		case msg := <-m.updateCh:
+			m, _ = m.Update(msg)
+		case <-deadline:
+			t.Fatal("check did not finish")
+		}
+	}
+	return m
+}
+
+func TestResultsModelCheckComplete(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	form := FormSubmitMsg{Domain: "www.example.test", Type: "a", Match: "192.0.2.10", Timeout: "2s", Retry: "50ms"}
+	m := NewResultsModel(form)
+	m = runCheck(t, m, func() tea.Cmd { return m.StartCheck(h.Config(), form) })
+
+	if m.status != "complete" {
+		t.Fatalf("status %q, want complete (error %q)", m.status, m.errorMsg)
+	}
+	if p, n := m.countPropagated(m.authoritative); p != 2 || n != 2 {
+		t.Errorf("authoritative %d/%d propagated, want 2/2", p, n)
+	}
+	if p, n := m.countPropagated(m.resolvers); p != 2 || n != 2 {
+		t.Errorf("resolvers %d/%d propagated, want 2/2", p, n)
+	}
+}
+
+func TestResultsModelCheckTimeout(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	h.Resolver.Remove(www, mdns.TypeA)
+
+	form := FormSubmitMsg{Domain: "www.example.test", Type: "a", Match: "192.0.2.10", Timeout: "300ms", Retry: "50ms"}
+	m := NewResultsModel(form)
+	m = runCheck(t, m, func() tea.Cmd { return m.StartCheck(h.Config(), form) })
+
+	if m.status != "timeout" {
+		t.Fatalf("status %q, want timeout", m.status)
+	}
+	if p, _ := m.countPropagated(m.resolvers); p != 1 {
+		t.Errorf("%d resolvers propagated, want 1", p)
+	}
+}
+
+func TestModelRecordsHistory(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	var model tea.Model = New(h.Config(), "")
+	model, _ = model.Update(FormSubmitMsg{Domain: "www.example.test", Type: "a", Match: "192.0.2.10", Timeout: "2s", Retry: "50ms"})
+
+	deadline := time.After(5 * time.Second)
+	for model.(Model).checkActive {
+		select {
+		case msg := <-model.(Model).results.updateCh:
+			model, _ = model.Update(msg)
+		case <-deadline:
+			t.Fatal("check did not finish")
+		}
+	}
+
+	m := model.(Model)
+	if len(m.historyData) != 1 || m.historyData[0].Status != "complete" {
+		t.Fatalf("unexpected history: %+v", m.historyData)
+	}
+	if m.historyData[0].AuthPropagated != 2 || m.historyData[0].ResPropagated != 2 {
+		t.Errorf("history counts %d auth, %d resolvers", m.historyData[0].AuthPropagated, m.historyData[0].ResPropagated)
+	}
+}
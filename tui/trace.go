@@ -0,0 +1,150 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	dnspkg "ripple/dns"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// TraceCompleteMsg is sent when a delegation trace has finished.
+type TraceCompleteMsg struct {
+	Trace *dnspkg.Trace
+	Err   error
+}
+
+// TraceModel holds the state for the delegation trace view.
+type TraceModel struct {
+	domain     string
+	recordType string
+	lines      []string // the trace as printed by dnspkg.PrintTrace
+	err        error
+	loading    bool
+	width      int
+	height     int
+
+	scrollOffset int
+	spinner      spinner.Model
+}
+
+// NewTraceModel creates a trace view for the domain and record type of a
+// check.
+func NewTraceModel(domain, recordType string) TraceModel {
+	return TraceModel{
+		domain:     domain,
+		recordType: strings.ToUpper(recordType),
+		loading:    true,
+		spinner: spinner.New(
+			spinner.WithSpinner(spinner.MiniDot),
+			spinner.WithStyle(StatusYellow),
+		),
+	}
+}
+
+// SetSize updates the trace view dimensions.
+func (m *TraceModel) SetSize(w, h int) {
+	m.width = w
+	m.height = h
+}
+
+// StartTrace returns a command that walks the delegations of the domain and
+// reports the trace with a TraceCompleteMsg.
+func (m TraceModel) StartTrace(cfg *dnspkg.Config) tea.Cmd {
+	return tea.Batch(m.spinner.Tick, m.runTrace(cfg))
+}
+
+func (m TraceModel) runTrace(cfg *dnspkg.Config) tea.Cmd {
+	domain, recordType, roots := m.domain, m.recordType, cfg.Roots()
+	return func() tea.Msg {
+		dnsType := dnspkg.ParseRecordType(recordType)
+		if dnsType == 0 {
+			return TraceCompleteMsg{Err: dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type %q", recordType)}
+		}
+		name, err := dnspkg.PrepareName(domain, dnsType)
+		if err != nil {
+			return TraceCompleteMsg{Err: err}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		tr, err := dnspkg.TraceDelegation(ctx, name, dnsType, roots)
+		return TraceCompleteMsg{Trace: tr, Err: err}
+	}
+}
+
+// Update handles trace results, the spinner and scrolling.
+func (m TraceModel) Update(msg tea.Msg) (TraceModel, tea.Cmd) {
+	switch msg := msg.(type) {
+	case TraceCompleteMsg:
+		m.loading = false
+		m.err = msg.Err
+		if msg.Trace != nil {
+			// The trace carries its own error, printed with the steps taken
+			m.err = nil
+			var b strings.Builder
+			dnspkg.PrintTrace(&b, msg.Trace)
+			m.lines = strings.Split(strings.TrimRight(b.String(), "\n"), "\n")
+		}
+		return m, nil
+
+	case spinner.TickMsg:
+		if m.loading {
+			var cmd tea.Cmd
+			m.spinner, cmd = m.spinner.Update(msg)
+			return m, cmd
+		}
+		return m, nil
+
+	case tea.KeyMsg:
+		maxOffset := max(len(m.lines)-m.visibleLines(), 0)
+		switch msg.String() {
+		case "up", "k":
+			m.scrollOffset--
+		case "down", "j":
+			m.scrollOffset++
+		case "pgup":
+			m.scrollOffset -= 10
+		case "pgdown":
+			m.scrollOffset += 10
+		}
+		m.scrollOffset = min(max(m.scrollOffset, 0), maxOffset)
+		return m, nil
+	}
+	return m, nil
+}
+
+// visibleLines is the number of trace lines that fit below the header.
+func (m TraceModel) visibleLines() int {
+	// Top-level View adds 4 lines, the header 2 and the border 2
+	return max(m.height-8, 5)
+}
+
+// View renders the trace view.
+func (m TraceModel) View() string {
+	var b strings.Builder
+	b.WriteString(HeaderStyle.Render("Delegation trace"))
+	b.WriteString("\n")
+	b.WriteString(MutedStyle.Render(fmt.Sprintf("Domain: %s  |  Type: %s", m.domain, m.recordType)))
+	b.WriteString("\n")
+
+	var body string
+	switch {
+	case m.loading:
+		body = m.spinner.View() + " Tracing from the root servers..."
+	case m.err != nil:
+		body = StatusRed.Render("Error: " + m.err.Error())
+	default:
+		end := min(m.scrollOffset+m.visibleLines(), len(m.lines))
+		lines := make([]string, 0, end-m.scrollOffset)
+		for _, line := range m.lines[m.scrollOffset:end] {
+			lines = append(lines, truncate(line, max(m.width-8, 40)))
+		}
+		body = strings.Join(lines, "\n")
+	}
+	b.WriteString(BorderStyle.Width(max(m.width-4, 40)).Render(body))
+	return b.String()
+}
@@ -0,0 +1,39 @@
+package tui
+
+import (
+	"strings"
+	"testing"
+
+	"ripple/dns/dnstest"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestTraceModel(t *testing.T) {
+	h := dnstest.New(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+
+	m := NewTraceModel("www.example.test", "a")
+	m.SetSize(100, 30)
+	m, _ = m.Update(m.runTrace(h.Config())())
+	if m.loading || m.err != nil {
+		t.Fatalf("loading %v, error %v", m.loading, m.err)
+	}
+	view := m.View()
+	for _, want := range []string{"Trace of www.example.test A", "referral to test.", "ns1.example.test. 192.0.2.11"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("view lacks %q:\n%s", want, view)
+		}
+	}
+
+	// Scrolling stops at the last page
+	for range 100 {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	}
+	if want := len(m.lines) - m.visibleLines(); m.scrollOffset != max(want, 0) {
+		t.Errorf("scrolled to %d, want %d", m.scrollOffset, want)
+	}
+	if !strings.Contains(m.View(), "Traced in") {
+		t.Errorf("last page lacks the elapsed time:\n%s", m.View())
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// runWatch audits the record every interval until ctx is done or count
+// rounds have run (0 = no limit). The first round lists every server; later
+// rounds only print the servers that gained or lost the record. It returns
+// the exit code of the last round.
+func runWatch(ctx context.Context, w io.Writer, opts *checkOptions, every time.Duration, count int) int {
+	dnsType := dnspkg.ParseRecordType(opts.recordType)
+	if dnsType == 0 {
+		return fail(dnspkg.Errorf(dnspkg.ErrUnsupportedType, "unsupported record type %q", opts.recordType))
+	}
+	domain, err := dnspkg.PrepareName(opts.domain, dnsType)
+	if err != nil {
+		return fail(err)
+	}
+
+	writeLine(w, "Watching %s %s=%s every %s", dnspkg.FormatName(domain), strings.ToUpper(opts.recordType), opts.match, every)
+	seen := make(map[string]string) // "kind name" -> matching record, "" if none
+	code := exitPropagated
+	for round := 1; ; round++ {
+		roundCtx, cancel := context.WithTimeout(ctx, every)
+		result, err := auditRound(roundCtx, domain, opts.recordType, dnsType, opts.match)
+		cancel()
+		if ctx.Err() != nil {
+			return code
+		}
+
+		if err != nil {
+			writeLine(w, "error: %v", err)
+			code = exitDiscovery
+		} else {
+			report := func(kind string, servers []*dnspkg.ResolverStatus) {
+				for _, s := range servers {
+					key := kind + " " + s.Name
+					prev, known := seen[key]
+					seen[key] = s.Record
+					switch {
+					case known && prev == s.Record:
+					case s.Propagated:
+						writeLine(w, "%s %s has %s%s", kind, s.Name, s.Record, resultNote(s))
+					case known:
+						writeLine(w, "%s %s no longer has the record", kind, s.Name)
+					default:
+						writeLine(w, "%s %s does not have the record", kind, s.Name)
+					}
+				}
+			}
+			report("authoritative", result.authServers)
+			report("resolver", result.resolvers)
+			code = result.exitCode()
+		}
+
+		if count > 0 && round >= count {
+			return code
+		}
+		select {
+		case <-ctx.Done():
+			return code
+		case <-time.After(every):
+		}
+	}
+}
+
+// auditRound discovers the authoritative servers of domain and queries them
+// and the resolvers once.
+func auditRound(ctx context.Context, domain, recordType string, dnsType uint16, match string) (*checkResult, error) {
+	authServers, err := dnspkg.DiscoverAuthoritativeContext(ctx, domain, dnsType, config.Roots())
+	if err != nil {
+		return nil, fmt.Errorf("failed to find authoritative servers: %w", err)
+	}
+	resolvers := dnspkg.BuildResolvers(config.PublicResolvers)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		dnspkg.ProbeOnce(ctx, authServers, dnspkg.AuthoritativeProbe(domain, dnsType, match, config.Roots()), start, &mu, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		dnspkg.ProbeOnce(ctx, resolvers, dnspkg.ResolverProbe(domain, dnsType, match), start, &mu, nil)
+	}()
+	wg.Wait()
+
+	return &checkResult{
+		once:        true,
+		authServers: authServers,
+		resolvers:   resolvers,
+		authDone:    dnspkg.AllPropagated(authServers),
+		response:    dnspkg.NewCheckResponse(domain, recordType, match, authServers, resolvers),
+		elapsed:     time.Since(start),
+	}, nil
+}
+
+// interruptContext returns a context that is cancelled on SIGINT or SIGTERM.
+func interruptContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// writeLine is fmt.Fprintf with a timestamp, for long-running commands.
+func writeLine(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, time.Now().Format("15:04:05")+" "+format+"\n", args...)
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	dnspkg "ripple/dns"
+)
+
+// Statuses of a watched record
+const (
+	watchPending = "pending" // not checked yet
+	watchOK      = "ok"      // every required server has the record
+	watchDrifted = "drifted" // an authoritative server or required resolver does not
+	watchError   = "error"   // the authoritative servers could not be found
+)
+
+// maxWatchEvents is how many changes of a record are kept for the API.
+const maxWatchEvents = 50
+
+// WatchState is the current state of a record on the watch list, as GET
+// /watch returns it.
+type WatchState struct {
+	Domain        string        `json:"domain"`
+	DomainUnicode string        `json:"domain_unicode,omitempty"`
+	RecordType    string        `json:"record_type"`
+	Match         string        `json:"match"`
+	Interval      string        `json:"interval"`
+	Status        string        `json:"status"`
+	Since         string        `json:"since,omitempty"` // when the status last changed
+	CheckedAt     string        `json:"checked_at,omitempty"`
+	Checks        int           `json:"checks"`
+	Drifted       []string      `json:"drifted,omitempty"` // required servers without the record
+	Error         string        `json:"error,omitempty"`
+	Code          string        `json:"code,omitempty"` // see ErrorCode
+	Authoritative []WatchServer `json:"authoritative"`
+	Resolvers     []WatchServer `json:"resolvers"`
+	Events        []WatchEvent  `json:"events"` // oldest first
+}
+
+// WatchServer is the state of one server for a watched record.
+type WatchServer struct {
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Required  bool   `json:"required"` // the record drifts if this server loses it
+	Matching  bool   `json:"matching"`
+	Misses    int    `json:"misses,omitempty"` // consecutive checks without the record
+	Record    string `json:"record,omitempty"`
+	Since     string `json:"since"`                // when Matching last changed
+	LastMatch string `json:"last_match,omitempty"` // last check the server had the record
+}
+
+// WatchEvent is a change of a watched record or of one of its servers.
+type WatchEvent struct {
+	Time   string `json:"time"`
+	Change string `json:"change"`           // drifted, recovered, error, lost or gained
+	Server string `json:"server,omitempty"` // "authoritative <name>" or "resolver <name>"
+	Detail string `json:"detail,omitempty"`
+}
+
+// driftMonitor checks the records of the watch list on their intervals and
+// keeps the state of each record and of each of its servers.
+type driftMonitor struct {
+	mu      sync.Mutex
+	misses  int // consecutive misses after which a server lost the record
+	records []*watchedRecord
+}
+
+// watchedRecord is a record of the watch list and its state.
+type watchedRecord struct {
+	domain    string // as queried
+	qtype     uint16
+	interval  time.Duration
+	resolvers []string      // required resolvers, by name or address, or "all"
+	recheck   chan struct{} // checks the record now instead of at the next interval
+	state     WatchState
+}
+
+// watchList is the drift monitor of server mode, nil without a watch list.
+var watchList *driftMonitor
+
+// newDriftMonitor validates the watch list of cfg.
+func newDriftMonitor(cfg dnspkg.WatchConfig) (*driftMonitor, error) {
+	interval, err := time.ParseDuration(cfg.Interval)
+	if err != nil || interval <= 0 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "watch: invalid interval %q", cfg.Interval)
+	}
+	if cfg.Misses < 0 {
+		return nil, dnspkg.Errorf(dnspkg.ErrInvalidInput, "watch: invalid misses %d", cfg.Misses)
+	}
+	m := &driftMonitor{misses: max(cfg.Misses, 1)}
+	for i, r := range cfg.Records {
+		invalid := func(format string, args ...any) error {
+			return dnspkg.Errorf(dnspkg.ErrInvalidInput, "watch record %d (%s): %s", i+1, r.Domain, fmt.Sprintf(format, args...))
+		}
+		recordType := r.Type
+		if recordType == "" {
+			recordType = config.Defaults.RecordType
+		}
+		qtype := dnspkg.ParseRecordType(recordType)
+		if qtype == 0 {
+			return nil, invalid("unsupported record type %q", recordType)
+		}
+		if r.Match == "" {
+			return nil, invalid("match is required")
+		}
+		domain, err := dnspkg.PrepareName(r.Domain, qtype)
+		if err != nil {
+			return nil, invalid("%v", err)
+		}
+		every := interval
+		if r.Interval != "" {
+			every, err = time.ParseDuration(r.Interval)
+			if err != nil || every <= 0 {
+				return nil, invalid("invalid interval %q", r.Interval)
+			}
+		}
+		m.records = append(m.records, &watchedRecord{
+			domain:    domain,
+			qtype:     qtype,
+			interval:  every,
+			resolvers: r.Resolvers,
+			recheck:   make(chan struct{}, 1),
+			state: WatchState{
+				Domain:        strings.TrimSuffix(domain, "."),
+				DomainUnicode: dnspkg.UnicodeName(domain),
+				RecordType:    strings.ToUpper(recordType),
+				Match:         r.Match,
+				Interval:      every.String(),
+				Status:        watchPending,
+				Authoritative: []WatchServer{},
+				Resolvers:     []WatchServer{},
+				Events:        []WatchEvent{},
+			},
+		})
+	}
+	return m, nil
+}
+
+// run checks every record on its interval until ctx is done.
+func (m *driftMonitor) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, rec := range m.records {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				m.check(ctx, rec)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(rec.interval):
+				case <-rec.recheck:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// check queries every server once for the record and updates its state.
+func (m *driftMonitor) check(ctx context.Context, rec *watchedRecord) {
+	roundCtx, span := tracing.start(ctx, "watch", spanInternal, checkSpanAttributes(rec.domain, rec.state.RecordType, rec.state.Match))
+	roundCtx, cancel := context.WithTimeout(roundCtx, min(rec.interval, 30*time.Second))
+	result, err := auditRound(roundCtx, rec.domain, rec.state.RecordType, rec.qtype, rec.state.Match)
+	cancel()
+	span.end(err)
+	if ctx.Err() != nil {
+		return
+	}
+	m.update(rec, result, err, time.Now())
+}
+
+// update folds the outcome of a check at now into the state of rec.
+func (m *driftMonitor) update(rec *watchedRecord, result *checkResult, err error, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := &rec.state
+	at := now.UTC().Format(time.RFC3339)
+	st.Checks++
+	st.CheckedAt = at
+
+	status, detail := watchOK, ""
+	if err != nil {
+		status, detail = watchError, err.Error()
+		st.Error, st.Code, st.Drifted = err.Error(), dnspkg.ErrorCode(err), nil
+	} else {
+		st.Error, st.Code = "", ""
+		st.Authoritative = rec.merge("authoritative", st.Authoritative, result.response.Authoritative, at, m.misses)
+		st.Resolvers = rec.merge("resolver", st.Resolvers, result.response.Resolvers, at, m.misses)
+		st.Drifted = nil
+		for _, s := range st.Authoritative {
+			if !s.Matching {
+				st.Drifted = append(st.Drifted, "authoritative "+s.Name)
+			}
+		}
+		for _, s := range st.Resolvers {
+			if s.Required && !s.Matching {
+				st.Drifted = append(st.Drifted, "resolver "+s.Name)
+			}
+		}
+		if len(st.Drifted) > 0 {
+			status, detail = watchDrifted, strings.Join(st.Drifted, ", ")+" without the record"
+		}
+	}
+
+	if status == st.Status {
+		return
+	}
+	change := status
+	if status == watchOK {
+		change = "recovered"
+	}
+	// The first check only reports a record that is not in place
+	notify := st.Status != watchPending || status != watchOK
+	st.Status, st.Since = status, at
+	if notify {
+		rec.event(WatchEvent{Time: at, Change: change, Detail: detail})
+		notifications.notify(driftNotification(st.clone(), change, detail), "")
+		if detail != "" {
+			change += ": " + detail
+		}
+		log.Printf("watch: %s %s %s %s", st.Domain, st.RecordType, st.Match, change)
+	}
+}
+
+// merge returns the new state of the servers of a kind, recording the
+// servers that lost or gained the record since the previous check. A server
+// that had the record only loses it after missing it misses checks in a row,
+// so a dropped packet does not count as drift.
+func (rec *watchedRecord) merge(kind string, prev []WatchServer, servers []dnspkg.ServerStatus, at string, misses int) []WatchServer {
+	next := make([]WatchServer, 0, len(servers))
+	for _, s := range servers {
+		ws := WatchServer{
+			Name:     s.Name,
+			Address:  s.Address,
+			Required: kind == "authoritative" || rec.requires(s),
+			Matching: s.Propagated,
+			Record:   s.Record,
+			Since:    at,
+		}
+		i := slices.IndexFunc(prev, func(p WatchServer) bool { return p.Name == s.Name })
+		if !s.Propagated {
+			ws.Misses = 1
+			if i >= 0 {
+				ws.Misses = prev[i].Misses + 1
+				if prev[i].Matching && ws.Misses < misses {
+					ws.Matching, ws.Record = true, prev[i].Record
+				}
+			}
+		}
+		switch {
+		case i < 0:
+		case prev[i].Matching == ws.Matching:
+			ws.Since, ws.LastMatch = prev[i].Since, prev[i].LastMatch
+		case ws.Matching:
+			rec.event(WatchEvent{Time: at, Change: "gained", Server: kind + " " + s.Name, Detail: s.Record})
+		default:
+			ws.LastMatch = prev[i].LastMatch
+			rec.event(WatchEvent{Time: at, Change: "lost", Server: kind + " " + s.Name, Detail: prev[i].Record})
+		}
+		if s.Propagated {
+			ws.LastMatch = at
+		}
+		next = append(next, ws)
+	}
+	return next
+}
+
+// requires reports whether the resolver must have the record.
+func (rec *watchedRecord) requires(s dnspkg.ServerStatus) bool {
+	return slices.ContainsFunc(rec.resolvers, func(r string) bool {
+		return r == "all" || r == s.Name || r == s.Address
+	})
+}
+
+func (rec *watchedRecord) event(e WatchEvent) {
+	rec.state.Events = append(rec.state.Events, e)
+	if n := len(rec.state.Events); n > maxWatchEvents {
+		rec.state.Events = slices.Clone(rec.state.Events[n-maxWatchEvents:])
+	}
+}
+
+// states returns a copy of the state of every record.
+func (m *driftMonitor) states() []WatchState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	states := make([]WatchState, 0, len(m.records))
+	for _, rec := range m.records {
+		states = append(states, rec.state.clone())
+	}
+	return states
+}
+
+// clone copies the state so it can be read without the monitor's lock.
+func (st *WatchState) clone() WatchState {
+	c := *st
+	c.Drifted = slices.Clone(st.Drifted)
+	c.Authoritative = slices.Clone(st.Authoritative)
+	c.Resolvers = slices.Clone(st.Resolvers)
+	c.Events = slices.Clone(st.Events)
+	return c
+}
+
+// recheck checks the records of domain now, or every record if domain is
+// empty. domain is prepared like the watch list entries, so a Unicode name, a
+// URL or the address of a PTR watch finds its record. It returns how many it
+// asked for.
+func (m *driftMonitor) recheck(domain string) int {
+	n := 0
+	for _, rec := range m.records {
+		if domain != "" {
+			name, err := dnspkg.PrepareName(domain, rec.qtype)
+			if err != nil || !strings.EqualFold(rec.domain, name) {
+				continue
+			}
+		}
+		select {
+		case rec.recheck <- struct{}{}:
+		default: // already asked for
+		}
+		n++
+	}
+	return n
+}
+
+// handleWatch returns the state of every record on the watch list.
+func handleWatch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	records := []WatchState{}
+	if watchList != nil {
+		records = watchList.states()
+	}
+	json.NewEncoder(w).Encode(struct {
+		Enabled bool         `json:"enabled"`
+		Records []WatchState `json:"records"`
+	}{watchList != nil, records})
+}
+
+// handleWatchCheck checks the records of a domain, or all of them without a
+// domain parameter, without waiting for their interval.
+func handleWatchCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "use POST", Code: dnspkg.ErrorCode(dnspkg.ErrInvalidInput)})
+		return
+	}
+	if watchList == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(dnspkg.ErrorResponse{Error: "no watch list configured", Code: dnspkg.ErrorCode(dnspkg.ErrInvalidInput)})
+		return
+	}
+	n := watchList.recheck(r.URL.Query().Get("domain"))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		Rechecking int `json:"rechecking"`
+	}{n})
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	dnspkg "ripple/dns"
+)
+
+func TestDriftMonitor(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	m, err := newDriftMonitor(dnspkg.WatchConfig{Interval: "1m", Records: []dnspkg.WatchRecord{
+		{Domain: "www.example.test", Type: "a", Match: "192.0.2.10", Resolvers: []string{"192.0.2.53"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := m.records[0]
+	check := func() WatchState {
+		t.Helper()
+		m.check(context.Background(), rec)
+		return m.states()[0]
+	}
+	summary := func(st WatchState) string {
+		var events []string
+		for _, e := range st.Events {
+			events = append(events, strings.TrimSpace(e.Change+" "+e.Server))
+		}
+		return st.Status + " [" + strings.Join(st.Drifted, ", ") + "] " + strings.Join(events, ", ")
+	}
+
+	st := check()
+	if got := summary(st); got != "ok [] " {
+		t.Errorf("first check: %s", got)
+	}
+	if len(st.Authoritative) != 2 || len(st.Resolvers) != 2 || !st.Resolvers[0].Required || st.Resolvers[1].Required || st.Checks != 1 {
+		t.Errorf("servers %+v %+v", st.Authoritative, st.Resolvers)
+	}
+
+	// A resolver that is not required may lose the record
+	h.SystemResolver.Remove(www, dnspkg.ParseRecordType("a"))
+	if got := summary(check()); got != "ok [] lost resolver local" {
+		t.Errorf("optional resolver: %s", got)
+	}
+
+	h.NS[1].Remove(www, dnspkg.ParseRecordType("a"))
+	st = check()
+	if got := summary(st); got != "drifted [authoritative 192.0.2.12] lost resolver local, lost authoritative 192.0.2.12, drifted" {
+		t.Errorf("authoritative drift: %s", got)
+	}
+	i := slices.IndexFunc(st.Authoritative, func(s WatchServer) bool { return s.Name == "192.0.2.12" })
+	if ns2 := st.Authoritative[i]; ns2.Matching || ns2.LastMatch == "" || ns2.Since != st.CheckedAt {
+		t.Errorf("drifted server %+v", st.Authoritative[i])
+	}
+
+	h.NS[1].Add(www + " 300 IN A 192.0.2.10")
+	if got := summary(check()); got != "ok [] lost resolver local, lost authoritative 192.0.2.12, drifted, gained authoritative 192.0.2.12, recovered" {
+		t.Errorf("recovery: %s", got)
+	}
+
+	h.Resolver.Remove(www, dnspkg.ParseRecordType("a"))
+	if got := summary(check()); !strings.HasPrefix(got, "drifted [resolver 192.0.2.53]") {
+		t.Errorf("required resolver: %s", got)
+	}
+}
+
+func TestDriftMonitorError(t *testing.T) {
+	useHierarchy(t)
+	m, err := newDriftMonitor(dnspkg.WatchConfig{Interval: "1m", Records: []dnspkg.WatchRecord{
+		{Domain: "www.example.nope", Match: "192.0.2.10"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.check(context.Background(), m.records[0])
+	st := m.states()[0]
+	if st.Status != watchError || st.Code != "nxdomain" || st.RecordType != "A" || len(st.Events) != 1 || st.Events[0].Change != "error" {
+		t.Errorf("state %+v", st)
+	}
+}
+
+func TestNewDriftMonitorErrors(t *testing.T) {
+	for _, cfg := range []dnspkg.WatchConfig{
+		{Interval: "soon"},
+		{Interval: "1m", Records: []dnspkg.WatchRecord{{Domain: "example.com", Type: "srv", Match: "x"}}},
+		{Interval: "1m", Records: []dnspkg.WatchRecord{{Domain: "example.com", Type: "a"}}},
+		{Interval: "1m", Records: []dnspkg.WatchRecord{{Domain: "bad..name", Type: "a", Match: "x"}}},
+		{Interval: "1m", Records: []dnspkg.WatchRecord{{Domain: "example.com", Type: "a", Match: "x", Interval: "-1m"}}},
+	} {
+		if _, err := newDriftMonitor(cfg); dnspkg.ErrorCode(err) != "invalid_input" {
+			t.Errorf("newDriftMonitor(%+v) = %v", cfg, err)
+		}
+	}
+}
+
+func TestHandleWatch(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	m, err := newDriftMonitor(dnspkg.WatchConfig{Interval: "1m", Records: []dnspkg.WatchRecord{
+		{Domain: "www.example.test", Type: "a", Match: "192.0.2.10"},
+		{Domain: "example.test", Type: "ns", Match: "ns1.example.test"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchList = m
+	t.Cleanup(func() { watchList = nil })
+	m.check(context.Background(), m.records[0])
+
+	rec := httptest.NewRecorder()
+	handleWatch(rec, httptest.NewRequest(http.MethodGet, "/watch", nil))
+	var resp struct {
+		Enabled bool
+		Records []WatchState
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Enabled || len(resp.Records) != 2 || resp.Records[0].Status != watchOK || resp.Records[1].Status != watchPending {
+		t.Errorf("response %+v", resp)
+	}
+
+	rec = httptest.NewRecorder()
+	handleWatchCheck(rec, httptest.NewRequest(http.MethodPost, "/watch/check?domain=WWW.example.test.", nil))
+	if rec.Code != http.StatusAccepted || strings.TrimSpace(rec.Body.String()) != `{"rechecking":1}` || len(m.records[0].recheck) != 1 {
+		t.Errorf("recheck: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestDriftMonitorLostAnswer(t *testing.T) {
+	h := useHierarchy(t)
+	h.Publish(www + " 300 IN A 192.0.2.10")
+	rc := newReceiver(t)
+	n := useNotifier(t, dnspkg.WebhooksConfig{Targets: []dnspkg.WebhookTarget{{URL: rc.URL}}})
+	m, err := newDriftMonitor(dnspkg.WatchConfig{Interval: "1m", Misses: 2, Records: []dnspkg.WatchRecord{
+		{Domain: "www.example.test", Type: "a", Match: "192.0.2.10"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := m.records[0]
+	m.check(context.Background(), rec)
+
+	// One round loses the answer of ns1, the next gets it again
+	h.NS[0].Remove(www, dnspkg.ParseRecordType("a"))
+	m.check(context.Background(), rec)
+	st := m.states()[0]
+	i := slices.IndexFunc(st.Authoritative, func(s WatchServer) bool { return s.Name == "192.0.2.11" })
+	if ns1 := st.Authoritative[i]; !ns1.Matching || ns1.Misses != 1 || ns1.Record == "" {
+		t.Errorf("server after one miss %+v", ns1)
+	}
+	h.NS[0].Add(www + " 300 IN A 192.0.2.10")
+	m.check(context.Background(), rec)
+	n.wait()
+	st = m.states()[0]
+	if st.Status != watchOK || len(st.Events) != 0 || len(rc.bodies) != 0 || st.Authoritative[i].Misses != 0 {
+		t.Errorf("after a lost answer: %s, events %+v, %d deliveries", st.Status, st.Events, len(rc.bodies))
+	}
+
+	// Two misses in a row are drift
+	h.NS[0].Remove(www, dnspkg.ParseRecordType("a"))
+	m.check(context.Background(), rec)
+	m.check(context.Background(), rec)
+	n.wait()
+	if st := m.states()[0]; st.Status != watchDrifted || len(st.Events) != 2 || len(rc.bodies) != 1 {
+		t.Errorf("after two misses: %s, events %+v, %d deliveries", st.Status, st.Events, len(rc.bodies))
+	}
+}
+
+func TestDriftMonitorRecheckNames(t *testing.T) {
+	m, err := newDriftMonitor(dnspkg.WatchConfig{Interval: "1m", Records: []dnspkg.WatchRecord{
+		{Domain: "www.example.test", Type: "a", Match: "192.0.2.10"},
+		{Domain: "bücher.example.test", Type: "a", Match: "192.0.2.20"},
+		{Domain: "192.0.2.10", Type: "ptr", Match: "www.example.test"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		domain string
+		want   int // index of the record rechecked, -1 for none
+	}{
+		{"https://WWW.example.test/path", 0},
+		{"Bücher.example.test", 1},
+		{"xn--bcher-kva.example.test.", 1},
+		{"192.0.2.10", 2},
+		{"10.2.0.192.in-addr.arpa", 2},
+		{"other.example.test", -1},
+	} {
+		n := m.recheck(tc.domain)
+		for i, rec := range m.records {
+			queued := len(rec.recheck) == 1
+			if queued != (i == tc.want) {
+				t.Errorf("recheck(%q): record %d queued %v", tc.domain, i, queued)
+			}
+			if queued {
+				<-rec.recheck
+			}
+		}
+		if want := min(tc.want+1, 1); n != want {
+			t.Errorf("recheck(%q) = %d, want %d", tc.domain, n, want)
+		}
+	}
+}